@@ -0,0 +1,220 @@
+package backups
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+// The layout written by WAL-E for each Patroni cluster scope, below the store's base URI
+const (
+	baseBackupsDirectory = "basebackups_005"
+	walDirectory         = "wal_005"
+)
+
+var (
+	baseBackupSentinelRegExp = regexp.MustCompile("^base_([0-9A-F]{24})_[0-9A-F]{8}_backup_stop_sentinel\\.json$")
+	baseBackupRegExp         = regexp.MustCompile("^(base_([0-9A-F]{24})_[0-9A-F]{8})/")
+	walSegmentRegExp         = regexp.MustCompile("^([0-9A-F]{24})(\\.|$)")
+)
+
+// BaseBackup is a physical backup of a cluster, from which WAL is replayed when restoring
+type BaseBackup struct {
+	Name string `json:"name"`
+	// StartSegment is the first WAL segment needed to restore the base backup
+	StartSegment string    `json:"start_segment"`
+	Size         int64     `json:"size"`
+	FinishedAt   time.Time `json:"finished_at"`
+	// Complete is true once WAL-E has written the backup's stop sentinel
+	Complete bool `json:"complete"`
+}
+
+// BaseBackups lists the base backups of a Patroni cluster, oldest first
+func BaseBackups(store BackupStore, scope structs.ClusterID) ([]BaseBackup, error) {
+	prefix := fmt.Sprintf("%s/%s/", scope, baseBackupsDirectory)
+	objects, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := map[string]*BaseBackup{}
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, prefix)
+		if match := baseBackupSentinelRegExp.FindStringSubmatch(name); match != nil {
+			backup := findOrAddBaseBackup(backups, strings.TrimSuffix(name, "_backup_stop_sentinel.json"), match[1])
+			backup.Complete = true
+			backup.FinishedAt = object.LastModified
+		} else if match := baseBackupRegExp.FindStringSubmatch(name); match != nil {
+			backup := findOrAddBaseBackup(backups, match[1], match[2])
+			backup.Size += object.Size
+		}
+	}
+
+	sorted := []BaseBackup{}
+	for _, backup := range backups {
+		sorted = append(sorted, *backup)
+	}
+	sort.Sort(baseBackupsByStartSegment(sorted))
+	return sorted, nil
+}
+
+func findOrAddBaseBackup(backups map[string]*BaseBackup, name, startSegment string) *BaseBackup {
+	backup, ok := backups[name]
+	if !ok {
+		backup = &BaseBackup{Name: name, StartSegment: startSegment}
+		backups[name] = backup
+	}
+	return backup
+}
+
+// LatestBaseBackup is the most recent complete base backup of a Patroni cluster
+func LatestBaseBackup(store BackupStore, scope structs.ClusterID) (BaseBackup, error) {
+	backups, err := completeBaseBackups(store, scope)
+	if err != nil {
+		return BaseBackup{}, err
+	}
+	return backups[len(backups)-1], nil
+}
+
+// CopyBackups copies every backup of the Patroni cluster fromScope to be the backups of toScope,
+// and then checks that each copy has the size and checksum of its original.
+// The source must have a complete base backup.
+func CopyBackups(store BackupStore, fromScope, toScope structs.ClusterID) error {
+	if _, err := LatestBaseBackup(store, fromScope); err != nil {
+		return err
+	}
+
+	fromPrefix := string(fromScope) + "/"
+	toPrefix := string(toScope) + "/"
+	objects, err := store.List(fromPrefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		toKey := toPrefix + strings.TrimPrefix(object.Key, fromPrefix)
+		if err = store.Copy(object.Key, toKey); err != nil {
+			return fmt.Errorf("Backups: copying %s: %s", object.Key, err)
+		}
+	}
+	return VerifyCopy(store, objects, fromPrefix, toPrefix)
+}
+
+// VerifyCopy checks that each object below fromPrefix has a copy below toPrefix with the same size,
+// and the same checksum where the store reports comparable checksums
+func VerifyCopy(store BackupStore, objects []Object, fromPrefix, toPrefix string) error {
+	copies, err := store.List(toPrefix)
+	if err != nil {
+		return err
+	}
+	copiesByKey := map[string]Object{}
+	for _, copy := range copies {
+		copiesByKey[copy.Key] = copy
+	}
+	for _, object := range objects {
+		toKey := toPrefix + strings.TrimPrefix(object.Key, fromPrefix)
+		copy, ok := copiesByKey[toKey]
+		if !ok {
+			return fmt.Errorf("Backups: copy %s is missing", toKey)
+		}
+		if copy.Size != object.Size {
+			return fmt.Errorf("Backups: copy %s has %d bytes, expected %d", toKey, copy.Size, object.Size)
+		}
+		if comparableChecksum(object.Checksum) && comparableChecksum(copy.Checksum) && copy.Checksum != object.Checksum {
+			return fmt.Errorf("Backups: checksum of copy %s does not match %s", toKey, object.Key)
+		}
+	}
+	return nil
+}
+
+// comparableChecksum is false for missing checksums and for S3 multipart ETags ("<md5>-<parts>")
+func comparableChecksum(checksum string) bool {
+	return checksum != "" && !strings.Contains(checksum, "-")
+}
+
+// ApplyRetention deletes all but the newest keep complete base backups of a Patroni cluster,
+// and the WAL segments that are only needed to restore the deleted base backups.
+// Incomplete base backups newer than the oldest kept backup may still be in progress and are kept.
+func ApplyRetention(store BackupStore, scope structs.ClusterID, keep int) (deleted []string, err error) {
+	deleted = []string{}
+	if keep <= 0 {
+		return deleted, nil
+	}
+	backups, err := BaseBackups(store, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := 0
+	oldestKept := ""
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Complete {
+			kept++
+			if kept == keep {
+				oldestKept = backups[i].Name
+				break
+			}
+		}
+	}
+	if oldestKept == "" {
+		// fewer complete base backups than are to be kept
+		return deleted, nil
+	}
+
+	var oldestKeptStartSegment string
+	for _, backup := range backups {
+		if backup.Name == oldestKept {
+			oldestKeptStartSegment = backup.StartSegment
+			break
+		}
+	}
+
+	baseBackupsPrefix := fmt.Sprintf("%s/%s/", scope, baseBackupsDirectory)
+	objects, err := store.List(baseBackupsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, baseBackupsPrefix)
+		if name >= oldestKept {
+			continue
+		}
+		if err = store.Delete(object.Key); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, object.Key)
+	}
+
+	walPrefix := fmt.Sprintf("%s/%s/", scope, walDirectory)
+	objects, err = store.List(walPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		match := walSegmentRegExp.FindStringSubmatch(strings.TrimPrefix(object.Key, walPrefix))
+		// timeline history files are always kept
+		if match == nil || !walSegmentBefore(match[1], oldestKeptStartSegment) {
+			continue
+		}
+		if err = store.Delete(object.Key); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, object.Key)
+	}
+	return deleted, nil
+}
+
+// walSegmentBefore compares the log position of WAL segment names, ignoring their timeline,
+// so that segments of a later timeline are never deleted for being on that timeline
+func walSegmentBefore(segment, than string) bool {
+	return segment[8:] < than[8:]
+}
+
+type baseBackupsByStartSegment []BaseBackup
+
+func (b baseBackupsByStartSegment) Len() int           { return len(b) }
+func (b baseBackupsByStartSegment) Less(i, j int) bool { return b[i].StartSegment < b[j].StartSegment }
+func (b baseBackupsByStartSegment) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,142 @@
+package backups
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func putTestObjects(t *testing.T, store BackupStore, keys ...string) {
+	for _, key := range keys {
+		if err := store.Put(key, strings.NewReader(key)); err != nil {
+			t.Fatalf("Put failed %s", err)
+		}
+	}
+}
+
+const (
+	testBaseBackup1 = "base_000000010000000000000002_00000040"
+	testBaseBackup2 = "base_000000010000000000000004_00000040"
+	testBaseBackup3 = "base_000000020000000000000006_00000040"
+)
+
+func putTestBackups(t *testing.T, store BackupStore) {
+	putTestObjects(t, store,
+		"a/basebackups_005/"+testBaseBackup1+"/tar_partitions/part_00000000.tar.lzo",
+		"a/basebackups_005/"+testBaseBackup1+"_backup_stop_sentinel.json",
+		"a/basebackups_005/"+testBaseBackup2+"/tar_partitions/part_00000000.tar.lzo",
+		"a/basebackups_005/"+testBaseBackup2+"_backup_stop_sentinel.json",
+		"a/basebackups_005/"+testBaseBackup3+"/tar_partitions/part_00000000.tar.lzo",
+		"a/wal_005/000000010000000000000002.lzo",
+		"a/wal_005/000000010000000000000003.lzo",
+		"a/wal_005/000000010000000000000004.lzo",
+		"a/wal_005/000000010000000000000005.lzo",
+		"a/wal_005/00000002.history.lzo",
+		"a/wal_005/000000020000000000000006.lzo",
+	)
+}
+
+func TestBackups_BaseBackups(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+	putTestBackups(t, store)
+
+	backups, err := BaseBackups(store, "a")
+	if err != nil {
+		t.Fatalf("BaseBackups failed %s", err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("Expected 3 base backups, got %v", backups)
+	}
+	if backups[0].Name != testBaseBackup1 || backups[0].StartSegment != "000000010000000000000002" || !backups[0].Complete || backups[0].Size == 0 {
+		t.Fatalf("Unexpected first base backup %v", backups[0])
+	}
+	if backups[2].Complete {
+		t.Fatalf("Expected base backup without stop sentinel to be incomplete, got %v", backups[2])
+	}
+
+	latest, err := LatestBaseBackup(store, "a")
+	if err != nil {
+		t.Fatalf("LatestBaseBackup failed %s", err)
+	}
+	if latest.Name != testBaseBackup2 {
+		t.Fatalf("Expected latest complete base backup %s, got %s", testBaseBackup2, latest.Name)
+	}
+	if _, err = LatestBaseBackup(store, "missing"); err == nil {
+		t.Fatalf("Expected error for cluster without base backups")
+	}
+}
+
+func TestBackups_CopyBackups(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+	putTestBackups(t, store)
+
+	if err := CopyBackups(store, "a", "b"); err != nil {
+		t.Fatalf("CopyBackups failed %s", err)
+	}
+	from, _ := store.List("a/")
+	to, _ := store.List("b/")
+	if len(from) != len(to) {
+		t.Fatalf("Expected %d copied objects, got %d", len(from), len(to))
+	}
+	if err := CopyBackups(store, "missing", "c"); err == nil {
+		t.Fatalf("Expected error copying cluster without base backups")
+	}
+}
+
+func TestBackups_VerifyCopy(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+	putTestObjects(t, store, "a/wal_005/1", "b/wal_005/1")
+
+	objects := []Object{{Key: "a/wal_005/1", Size: int64(len("a/wal_005/1"))}}
+	if err := VerifyCopy(store, objects, "a/", "b/"); err != nil {
+		t.Fatalf("Expected copy of the same size to verify, got %s", err)
+	}
+	objects[0].Size++
+	if err := VerifyCopy(store, objects, "a/", "b/"); err == nil {
+		t.Fatalf("Expected copy of a different size to fail verification")
+	}
+	if err := VerifyCopy(store, objects, "a/", "c/"); err == nil {
+		t.Fatalf("Expected missing copy to fail verification")
+	}
+}
+
+func TestBackups_ApplyRetention(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+	putTestBackups(t, store)
+
+	deleted, err := ApplyRetention(store, "a", 1)
+	if err != nil {
+		t.Fatalf("ApplyRetention failed %s", err)
+	}
+	expectedDeleted := []string{
+		"a/basebackups_005/" + testBaseBackup1 + "/tar_partitions/part_00000000.tar.lzo",
+		"a/basebackups_005/" + testBaseBackup1 + "_backup_stop_sentinel.json",
+		"a/wal_005/000000010000000000000002.lzo",
+		"a/wal_005/000000010000000000000003.lzo",
+	}
+	if !reflect.DeepEqual(deleted, expectedDeleted) {
+		t.Fatalf("Expected deleted %v, got %v", expectedDeleted, deleted)
+	}
+
+	backups, _ := BaseBackups(store, "a")
+	if len(backups) != 2 || backups[0].Name != testBaseBackup2 {
+		t.Fatalf("Expected newest complete and in-progress base backups to be kept, got %v", backups)
+	}
+	wal, _ := store.List("a/wal_005/")
+	if len(wal) != 4 || wal[2].Key != "a/wal_005/00000002.history.lzo" {
+		t.Fatalf("Expected history file and WAL from the kept base backup to be kept, got %v", wal)
+	}
+
+	deleted, _ = ApplyRetention(store, "a", 2)
+	if len(deleted) != 0 {
+		t.Fatalf("Expected nothing to be deleted while fewer base backups exist than are kept, got %v", deleted)
+	}
+}
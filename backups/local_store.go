@@ -0,0 +1,156 @@
+package backups
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalStore keeps backups in a directory, such as a mounted network filesystem
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore creates a LocalStore rooted at a directory
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: root}
+}
+
+func (s *LocalStore) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		key, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+		if !strings.HasPrefix(key, prefix) || strings.HasSuffix(key, ".tmp") {
+			return nil
+		}
+		// reading every file to checksum it would make listing slow; Copy verifies checksums instead
+		objects = append(objects, Object{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(objectsByKey(objects))
+	return objects, nil
+}
+
+func (s *LocalStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Put writes to a temporary file that is renamed into place, so that a key is never partially written
+func (s *LocalStore) Put(key string, data io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Copy checks that the copy has the same checksum as the data read from fromKey
+func (s *LocalStore) Copy(fromKey, toKey string) error {
+	data, err := s.Get(fromKey)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	hash := md5.New()
+	if err = s.Put(toKey, io.TeeReader(data, hash)); err != nil {
+		return err
+	}
+	toPath, _ := s.path(toKey)
+	checksum, err := fileChecksum(toPath)
+	if err != nil {
+		return err
+	}
+	if checksum != hex.EncodeToString(hash.Sum(nil)) {
+		return fmt.Errorf("Backups: checksum of copy %s does not match %s", toKey, fromKey)
+	}
+	return nil
+}
+
+func (s *LocalStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStore) URI(key string) string {
+	return "file://" + filepath.ToSlash(filepath.Join(s.root, key))
+}
+
+// path refuses keys that would resolve outside of the store's directory
+func (s *LocalStore) path(key string) (string, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.root)+string(filepath.Separator)) {
+		return "", fmt.Errorf("Backups: invalid key %s", key)
+	}
+	return path, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type objectsByKey []Object
+
+func (o objectsByKey) Len() int           { return len(o) }
+func (o objectsByKey) Less(i, j int) bool { return o[i].Key < o[j].Key }
+func (o objectsByKey) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
@@ -0,0 +1,80 @@
+package backups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestLocalStore(t *testing.T) (*LocalStore, func()) {
+	dir, err := ioutil.TempDir("", "backups")
+	if err != nil {
+		t.Fatalf("Could not create temp dir %s", err)
+	}
+	return NewLocalStore(filepath.Join(dir, "store")), func() { os.RemoveAll(dir) }
+}
+
+func TestLocalStore_PutListCopyDelete(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+
+	objects, err := store.List("")
+	if err != nil || len(objects) != 0 {
+		t.Fatalf("Expected missing store directory to list nothing, got %v %v", objects, err)
+	}
+
+	if err = store.Put("a/wal_005/2.lzo", strings.NewReader("two")); err != nil {
+		t.Fatalf("Put failed %s", err)
+	}
+	if err = store.Put("a/wal_005/1.lzo", strings.NewReader("one")); err != nil {
+		t.Fatalf("Put failed %s", err)
+	}
+	if err = store.Put("b/wal_005/1.lzo", strings.NewReader("other")); err != nil {
+		t.Fatalf("Put failed %s", err)
+	}
+
+	objects, err = store.List("a/")
+	if err != nil {
+		t.Fatalf("List failed %s", err)
+	}
+	if len(objects) != 2 || objects[0].Key != "a/wal_005/1.lzo" || objects[1].Key != "a/wal_005/2.lzo" || objects[1].Size != 3 {
+		t.Fatalf("Expected two sorted objects below a/, got %v", objects)
+	}
+
+	if err = store.Copy("a/wal_005/2.lzo", "c/wal_005/2.lzo"); err != nil {
+		t.Fatalf("Copy failed %s", err)
+	}
+	data, err := store.Get("c/wal_005/2.lzo")
+	if err != nil {
+		t.Fatalf("Get failed %s", err)
+	}
+	content, _ := ioutil.ReadAll(data)
+	data.Close()
+	if string(content) != "two" {
+		t.Fatalf("Expected copied content 'two', got '%s'", content)
+	}
+
+	if err = store.Delete("a/wal_005/1.lzo"); err != nil {
+		t.Fatalf("Delete failed %s", err)
+	}
+	if err = store.Delete("a/wal_005/1.lzo"); err != nil {
+		t.Fatalf("Expected deleting a missing key to succeed, got %s", err)
+	}
+	objects, _ = store.List("a/")
+	if len(objects) != 1 {
+		t.Fatalf("Expected one object left below a/, got %v", objects)
+	}
+}
+
+func TestLocalStore_InvalidKey(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+
+	if err := store.Put("../outside", strings.NewReader("x")); err == nil {
+		t.Fatalf("Expected key outside of the store to be refused")
+	}
+}
@@ -0,0 +1,162 @@
+package backups
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+// RecoveryWindow is the range of times that a cluster can be restored to from its backups
+type RecoveryWindow struct {
+	// From is when the oldest complete base backup with archived WAL finished
+	From time.Time `json:"from"`
+	// To is when the last WAL segment that can be replayed from a base backup without a gap was archived
+	To time.Time `json:"to"`
+}
+
+// FindRecoveryWindow returns the recovery window of a Patroni cluster's backups
+func FindRecoveryWindow(store BackupStore, scope structs.ClusterID) (window RecoveryWindow, err error) {
+	windows, err := baseBackupRecoveryWindows(store, scope)
+	if err != nil {
+		return
+	}
+	window = windows[0].RecoveryWindow
+	for _, backupWindow := range windows {
+		if backupWindow.To.After(window.To) {
+			window.To = backupWindow.To
+		}
+	}
+	return
+}
+
+// baseBackupRecoveryWindow is the range of times that can be reached by replaying WAL from one base backup
+type baseBackupRecoveryWindow struct {
+	RecoveryWindow
+	BaseBackup BaseBackup
+}
+
+// baseBackupRecoveryWindows are the recovery windows of the complete base backups with archived WAL, oldest first
+func baseBackupRecoveryWindows(store BackupStore, scope structs.ClusterID) ([]baseBackupRecoveryWindow, error) {
+	backups, err := completeBaseBackups(store, scope)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := WALRanges(store, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := []baseBackupRecoveryWindow{}
+	for _, backup := range backups {
+		lastArchivedAt, ok := contiguousWALArchivedAt(backup.StartSegment, ranges)
+		if !ok {
+			continue
+		}
+		window := baseBackupRecoveryWindow{BaseBackup: backup}
+		window.From = backup.FinishedAt
+		window.To = backup.FinishedAt
+		if lastArchivedAt.After(window.To) {
+			window.To = lastArchivedAt
+		}
+		windows = append(windows, window)
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("Backups: no complete base backup of %s has its WAL archived", store.URI(string(scope)))
+	}
+	return windows, nil
+}
+
+// contiguousWALArchivedAt is when the last segment of the WAL that can be replayed from startSegment
+// was archived. Replay stops at the first missing segment, but follows a promotion to a later
+// timeline that continues from the segments replayed so far.
+// ok is false if startSegment has not been archived.
+func contiguousWALArchivedAt(startSegment string, ranges []WALRange) (lastArchivedAt time.Time, ok bool) {
+	if len(startSegment) < 24 {
+		return
+	}
+	position, err := walSegmentPosition(startSegment)
+	if err != nil {
+		return
+	}
+
+	var current *WALRange
+	var currentStart, currentEnd uint64
+	for i := range ranges {
+		start, startErr := walSegmentPosition(ranges[i].StartSegment)
+		end, endErr := walSegmentPosition(ranges[i].EndSegment)
+		if startErr != nil || endErr != nil {
+			continue
+		}
+		if current == nil {
+			if ranges[i].Timeline == startSegment[:8] && start <= position && position <= end {
+				current, currentStart, currentEnd = &ranges[i], start, end
+				lastArchivedAt, ok = current.LastArchivedAt, true
+			}
+			continue
+		}
+		// ranges are ordered by timeline, so later timelines follow the current range
+		if ranges[i].Timeline > current.Timeline && start >= currentStart && start <= currentEnd+1 {
+			current, currentStart, currentEnd = &ranges[i], start, end
+			if current.LastArchivedAt.After(lastArchivedAt) {
+				lastArchivedAt = current.LastArchivedAt
+			}
+		}
+	}
+	return
+}
+
+// ResolveRestoreTarget checks that a cluster can be restored to target from its backups,
+// and chooses the base backup to restore from.
+// A time must be reachable by replaying WAL without a gap from a base backup finished before it;
+// the newest such base backup is used.
+// A restore point cannot be checked until WAL is replayed, so the oldest base backup is used
+// in order to reach any restore point in the archived WAL.
+func ResolveRestoreTarget(store BackupStore, scope structs.ClusterID, target structs.RestoreTarget) (structs.RestoreTarget, error) {
+	backups, err := completeBaseBackups(store, scope)
+	if err != nil {
+		return target, err
+	}
+	if !target.IsTime() {
+		target.BaseBackup = backups[0].Name
+		return target, nil
+	}
+
+	windows, err := baseBackupRecoveryWindows(store, scope)
+	if err != nil {
+		return target, err
+	}
+	if target.Time.Before(windows[0].From) {
+		return target, fmt.Errorf("Backups: restore-to %s is before the oldest base backup, which finished at %s",
+			target, windows[0].From.Format(time.RFC3339))
+	}
+	target.BaseBackup = ""
+	for _, window := range windows {
+		if !window.From.After(target.Time) && !window.To.Before(target.Time) {
+			target.BaseBackup = window.BaseBackup.Name
+		}
+	}
+	if target.BaseBackup == "" {
+		return target, fmt.Errorf("Backups: restore-to %s is after the WAL that can be replayed without a gap from any base backup before it",
+			target)
+	}
+	return target, nil
+}
+
+// completeBaseBackups are the base backups that can be restored, oldest first; there must be at least one
+func completeBaseBackups(store BackupStore, scope structs.ClusterID) ([]BaseBackup, error) {
+	backups, err := BaseBackups(store, scope)
+	if err != nil {
+		return nil, err
+	}
+	complete := []BaseBackup{}
+	for _, backup := range backups {
+		if backup.Complete && backup.Size > 0 {
+			complete = append(complete, backup)
+		}
+	}
+	if len(complete) == 0 {
+		return nil, fmt.Errorf("Backups: %s has no complete base backup", store.URI(string(scope)))
+	}
+	return complete, nil
+}
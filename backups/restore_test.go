@@ -0,0 +1,104 @@
+package backups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+func TestBackups_ResolveRestoreTarget(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+	putTestBackups(t, store)
+
+	base := time.Date(2016, 8, 1, 0, 0, 0, 0, time.UTC)
+	modified := map[string]time.Time{
+		"a/basebackups_005/" + testBaseBackup1 + "_backup_stop_sentinel.json": base,
+		"a/basebackups_005/" + testBaseBackup2 + "_backup_stop_sentinel.json": base.Add(24 * time.Hour),
+	}
+	objects, _ := store.List("a/wal_005/")
+	for i, object := range objects {
+		modified[object.Key] = base.Add(time.Duration(i*12) * time.Hour)
+	}
+	for key, at := range modified {
+		if err := os.Chtimes(filepath.Join(store.root, key), at, at); err != nil {
+			t.Fatalf("Chtimes failed %s", err)
+		}
+	}
+
+	window, err := FindRecoveryWindow(store, "a")
+	if err != nil {
+		t.Fatalf("FindRecoveryWindow failed %s", err)
+	}
+	if !window.From.Equal(base) || !window.To.Equal(base.Add(60*time.Hour)) {
+		t.Fatalf("Unexpected recovery window %v", window)
+	}
+
+	target, err := ResolveRestoreTarget(store, "a", structs.RestoreTarget{Time: base.Add(30 * time.Hour)})
+	if err != nil {
+		t.Fatalf("ResolveRestoreTarget failed %s", err)
+	}
+	if target.BaseBackup != testBaseBackup2 {
+		t.Fatalf("Expected newest base backup before the target, %s, got %s", testBaseBackup2, target.BaseBackup)
+	}
+	target, _ = ResolveRestoreTarget(store, "a", structs.RestoreTarget{Time: base.Add(12 * time.Hour)})
+	if target.BaseBackup != testBaseBackup1 {
+		t.Fatalf("Expected base backup %s, got %s", testBaseBackup1, target.BaseBackup)
+	}
+
+	if _, err = ResolveRestoreTarget(store, "a", structs.RestoreTarget{Time: base.Add(-time.Hour)}); err == nil {
+		t.Fatalf("Expected error for time before the oldest base backup")
+	}
+	if _, err = ResolveRestoreTarget(store, "a", structs.RestoreTarget{Time: base.Add(61 * time.Hour)}); err == nil {
+		t.Fatalf("Expected error for time after the latest archived WAL")
+	}
+
+	target, err = ResolveRestoreTarget(store, "a", structs.RestoreTarget{Name: "before_migration"})
+	if err != nil || target.BaseBackup != testBaseBackup1 {
+		t.Fatalf("Expected restore point to use the oldest base backup %s, got %v %v", testBaseBackup1, target, err)
+	}
+	if _, err = ResolveRestoreTarget(store, "missing", structs.RestoreTarget{Name: "before_migration"}); err == nil {
+		t.Fatalf("Expected error for cluster without base backups")
+	}
+}
+
+func TestBackups_FindRecoveryWindow_WALGap(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+	putTestBackups(t, store)
+	// replay from either base backup stops at segment 4, so segment 6 cannot be reached
+	if err := store.Delete("a/wal_005/000000010000000000000005.lzo"); err != nil {
+		t.Fatalf("Delete failed %s", err)
+	}
+
+	base := time.Date(2016, 8, 1, 0, 0, 0, 0, time.UTC)
+	modified := map[string]time.Time{
+		"a/basebackups_005/" + testBaseBackup1 + "_backup_stop_sentinel.json": base,
+		"a/basebackups_005/" + testBaseBackup2 + "_backup_stop_sentinel.json": base.Add(24 * time.Hour),
+		"a/wal_005/000000010000000000000002.lzo":                              base,
+		"a/wal_005/000000010000000000000003.lzo":                              base.Add(12 * time.Hour),
+		"a/wal_005/000000010000000000000004.lzo":                              base.Add(24 * time.Hour),
+		"a/wal_005/000000020000000000000006.lzo":                              base.Add(48 * time.Hour),
+	}
+	for key, at := range modified {
+		if err := os.Chtimes(filepath.Join(store.root, key), at, at); err != nil {
+			t.Fatalf("Chtimes failed %s", err)
+		}
+	}
+
+	window, err := FindRecoveryWindow(store, "a")
+	if err != nil {
+		t.Fatalf("FindRecoveryWindow failed %s", err)
+	}
+	if !window.From.Equal(base) || !window.To.Equal(base.Add(24*time.Hour)) {
+		t.Fatalf("Expected recovery window to end at the gap in WAL, got %v", window)
+	}
+	if _, err = ResolveRestoreTarget(store, "a", structs.RestoreTarget{Time: base.Add(36 * time.Hour)}); err == nil {
+		t.Fatalf("Expected error for time after a gap in WAL")
+	}
+}
@@ -0,0 +1,354 @@
+package backups
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+const (
+	defaultS3Endpoint = "https://s3.amazonaws.com"
+	defaultS3Region   = "us-east-1"
+	s3RequestTimeout  = 5 * time.Minute
+
+	// objects larger than a part are uploaded in parts, so that only one part is held in memory
+	defaultS3PartSize = 16 * 1024 * 1024
+	// S3 copies objects of up to 5 GB in one request; larger objects are copied in parts
+	defaultS3MaxCopySize  = 5 * 1024 * 1024 * 1024
+	defaultS3CopyPartSize = 1024 * 1024 * 1024
+)
+
+// S3Store keeps backups in a bucket of Amazon S3 or an S3-compatible object store.
+// Objects are addressed path-style (<endpoint>/<bucket>/<key>), which S3-compatible stores support.
+type S3Store struct {
+	endpoint        string
+	bucket          string
+	prefix          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+	now             func() time.Time
+	partSize        int
+	maxCopySize     int64
+	copyPartSize    int64
+}
+
+// NewS3Store creates an S3Store for the keys below prefix in bucket
+func NewS3Store(bucket, prefix string, cfg config.BackupsS3) *S3Store {
+	store := &S3Store{
+		endpoint:        strings.TrimSuffix(cfg.Endpoint, "/"),
+		bucket:          bucket,
+		prefix:          prefix,
+		region:          cfg.Region,
+		accessKeyID:     cfg.AccessKeyID,
+		secretAccessKey: cfg.SecretAccessKey,
+		client:          &http.Client{Timeout: s3RequestTimeout},
+		now:             time.Now,
+		partSize:        defaultS3PartSize,
+		maxCopySize:     defaultS3MaxCopySize,
+		copyPartSize:    defaultS3CopyPartSize,
+	}
+	if store.endpoint == "" {
+		store.endpoint = defaultS3Endpoint
+	}
+	if store.region == "" {
+		store.region = defaultS3Region
+	}
+	return store
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.objectKey(prefix)}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		resp, err := s.request("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Backups: invalid listing of s3://%s/%s: %s", s.bucket, s.objectKey(prefix), err)
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{
+				Key:          strings.TrimPrefix(content.Key, s.objectKey("")),
+				Size:         content.Size,
+				LastModified: content.LastModified,
+				Checksum:     strings.Trim(content.ETag, `"`),
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.request("GET", s.objectKey(key), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put uploads data in one request if it fits in a part, and as a multipart upload otherwise
+func (s *S3Store) Put(key string, data io.Reader) error {
+	part := make([]byte, s.partSize)
+	n, err := io.ReadFull(data, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.request("PUT", s.objectKey(key), nil, nil, part[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	upload, err := s.createMultipartUpload(key)
+	if err != nil {
+		return err
+	}
+	for partNumber := 1; n > 0; partNumber++ {
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {upload.uploadID}}
+		resp, err := s.request("PUT", s.objectKey(key), query, nil, part[:n])
+		if err != nil {
+			return upload.abort(err)
+		}
+		resp.Body.Close()
+		upload.addPart(partNumber, resp.Header.Get("ETag"))
+
+		n, err = io.ReadFull(data, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return upload.abort(err)
+		}
+	}
+	return upload.complete()
+}
+
+// Copy is done by the object store, without downloading the object.
+// Objects larger than S3 copies in one request are copied in parts.
+func (s *S3Store) Copy(fromKey, toKey string) error {
+	copySource := canonicalURI("/" + s.bucket + "/" + s.objectKey(fromKey))
+	resp, err := s.request("HEAD", s.objectKey(fromKey), nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("Backups: copy of %s to %s failed: %s", fromKey, toKey, err)
+	}
+	resp.Body.Close()
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Backups: copy of %s to %s failed: unknown size: %s", fromKey, toKey, err)
+	}
+
+	if size <= s.maxCopySize {
+		headers := map[string]string{"x-amz-copy-source": copySource}
+		return s.copyRequest(fromKey, toKey, nil, headers, nil)
+	}
+
+	upload, err := s.createMultipartUpload(toKey)
+	if err != nil {
+		return err
+	}
+	partNumber := 1
+	for first := int64(0); first < size; first += s.copyPartSize {
+		last := first + s.copyPartSize - 1
+		if last >= size {
+			last = size - 1
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {upload.uploadID}}
+		headers := map[string]string{
+			"x-amz-copy-source":       copySource,
+			"x-amz-copy-source-range": fmt.Sprintf("bytes=%d-%d", first, last),
+		}
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		if err = s.copyRequest(fromKey, toKey, query, headers, &result); err != nil {
+			return upload.abort(err)
+		}
+		upload.addPart(partNumber, result.ETag)
+		partNumber++
+	}
+	return upload.complete()
+}
+
+// copyRequest decodes the XML response of a copy into result, unless result is nil
+func (s *S3Store) copyRequest(fromKey, toKey string, query url.Values, headers map[string]string, result interface{}) error {
+	resp, err := s.request("PUT", s.objectKey(toKey), query, headers, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 can report a failed copy with a 200 response that contains an error
+	responseText, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(responseText, []byte("<Error>")) {
+		return fmt.Errorf("Backups: copy of %s to %s failed: %s", fromKey, toKey, strings.TrimSpace(string(responseText)))
+	}
+	if result != nil {
+		return xml.Unmarshal(responseText, result)
+	}
+	return nil
+}
+
+// s3MultipartUpload is an object being uploaded or copied in parts
+type s3MultipartUpload struct {
+	store    *S3Store
+	key      string
+	uploadID string
+	parts    []s3CompletedPart
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3Store) createMultipartUpload(key string) (*s3MultipartUpload, error) {
+	resp, err := s.request("POST", s.objectKey(key), url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return nil, fmt.Errorf("Backups: could not start a multipart upload of %s: %v", s.URI(key), err)
+	}
+	return &s3MultipartUpload{store: s, key: key, uploadID: result.UploadID}, nil
+}
+
+func (upload *s3MultipartUpload) addPart(partNumber int, etag string) {
+	upload.parts = append(upload.parts, s3CompletedPart{PartNumber: partNumber, ETag: etag})
+}
+
+func (upload *s3MultipartUpload) complete() error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: upload.parts})
+	if err != nil {
+		return upload.abort(err)
+	}
+	s := upload.store
+	resp, err := s.request("POST", s.objectKey(upload.key), url.Values{"uploadId": {upload.uploadID}}, nil, body)
+	if err != nil {
+		return upload.abort(err)
+	}
+	defer resp.Body.Close()
+
+	// like a copy, completing an upload can fail with a 200 response that contains an error
+	responseText, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return upload.abort(err)
+	}
+	if bytes.Contains(responseText, []byte("<Error>")) {
+		return upload.abort(fmt.Errorf("Backups: multipart upload of %s failed: %s", s.URI(upload.key), strings.TrimSpace(string(responseText))))
+	}
+	return nil
+}
+
+// abort discards the uploaded parts, so that they are not stored (and charged for) indefinitely,
+// and returns err
+func (upload *s3MultipartUpload) abort(err error) error {
+	s := upload.store
+	resp, abortErr := s.request("DELETE", s.objectKey(upload.key), url.Values{"uploadId": {upload.uploadID}}, nil, nil)
+	if abortErr == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func (s *S3Store) Delete(key string) error {
+	resp, err := s.request("DELETE", s.objectKey(key), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) URI(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.objectKey(key))
+}
+
+func (s *S3Store) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+// request sends a signed request for an object (or the bucket, if objectKey is empty)
+// and returns the response of a successful (2xx) request
+func (s *S3Store) request(method, objectKey string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	uri, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	uri.Path = "/" + s.bucket
+	if objectKey != "" {
+		uri.Path += "/" + objectKey
+	}
+	uri.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, uri.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// keep keys such as "part_00000000.tar.lzo" exactly as signed
+	req.URL.Opaque = "//" + uri.Host + canonicalURI(uri.Path)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	signV4(req, payloadHash, s.region, s.accessKeyID, s.secretAccessKey, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		responseText, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Backups: %s s3://%s/%s returned %d: %s", method, s.bucket, objectKey, resp.StatusCode, strings.TrimSpace(string(responseText)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,52 @@
+package backups
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+// Object is a file held by a BackupStore
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	// Checksum is the hex MD5 of the content, an S3 multipart ETag that cannot be compared to it,
+	// or empty if the store does not report checksums when listing
+	Checksum string `json:"-"`
+}
+
+// BackupStore holds the continuous backups of clusters. Keys are relative to the store's base URI
+// and are separated by "/", such as "<scope>/wal_005/000000010000000000000002.lzo".
+type BackupStore interface {
+	// List returns every object whose key starts with prefix, ordered by key
+	List(prefix string) ([]Object, error)
+	Get(key string) (io.ReadCloser, error)
+	Put(key string, data io.Reader) error
+	Copy(fromKey, toKey string) error
+	Delete(key string) error
+	// URI is the location of a key, as given to cells and callback scripts
+	URI(key string) string
+}
+
+// NewBackupStore returns the store for cfg.BaseURI, or nil if backups are not configured
+func NewBackupStore(cfg config.Backups) (BackupStore, error) {
+	if cfg.BaseURI == "" {
+		return nil, nil
+	}
+	uri, err := url.Parse(cfg.BaseURI)
+	if err != nil {
+		return nil, fmt.Errorf("Backups: invalid base_uri %s: %s", cfg.BaseURI, err)
+	}
+	switch uri.Scheme {
+	case "s3":
+		return NewS3Store(uri.Host, strings.Trim(uri.Path, "/"), cfg.S3), nil
+	case "file", "":
+		return NewLocalStore(uri.Path), nil
+	}
+	return nil, fmt.Errorf("Backups: unsupported base_uri scheme %s", uri.Scheme)
+}
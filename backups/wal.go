@@ -0,0 +1,80 @@
+package backups
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+// WALRange is a run of consecutive archived WAL segments on one timeline.
+// WAL can only be replayed through a range, so a gap between ranges ends recovery.
+type WALRange struct {
+	Timeline        string    `json:"timeline"`
+	StartSegment    string    `json:"start_segment"`
+	EndSegment      string    `json:"end_segment"`
+	Segments        int       `json:"segments"`
+	Size            int64     `json:"size"`
+	FirstArchivedAt time.Time `json:"first_archived_at"`
+	LastArchivedAt  time.Time `json:"last_archived_at"`
+}
+
+// WALRanges lists the ranges of archived WAL of a Patroni cluster, ordered by timeline and position
+func WALRanges(store BackupStore, scope structs.ClusterID) ([]WALRange, error) {
+	prefix := fmt.Sprintf("%s/%s/", scope, walDirectory)
+	objects, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := []WALRange{}
+	var current *WALRange
+	var previousPosition uint64
+	for _, object := range objects {
+		match := walSegmentRegExp.FindStringSubmatch(strings.TrimPrefix(object.Key, prefix))
+		if match == nil {
+			continue
+		}
+		segment := match[1]
+		timeline := segment[:8]
+		position, err := walSegmentPosition(segment)
+		if err != nil {
+			continue
+		}
+		if current == nil || current.Timeline != timeline || position != previousPosition+1 {
+			ranges = append(ranges, WALRange{
+				Timeline:        timeline,
+				StartSegment:    segment,
+				FirstArchivedAt: object.LastModified,
+			})
+			current = &ranges[len(ranges)-1]
+		}
+		current.EndSegment = segment
+		current.Segments++
+		current.Size += object.Size
+		if object.LastModified.Before(current.FirstArchivedAt) {
+			current.FirstArchivedAt = object.LastModified
+		}
+		if object.LastModified.After(current.LastArchivedAt) {
+			current.LastArchivedAt = object.LastModified
+		}
+		previousPosition = position
+	}
+	return ranges, nil
+}
+
+// walSegmentPosition numbers the segments of a timeline consecutively; each of the
+// 32-bit logical log files named by a segment holds 0x100 segments of 16MB
+func walSegmentPosition(segment string) (uint64, error) {
+	logFile, err := strconv.ParseUint(segment[8:16], 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	logSegment, err := strconv.ParseUint(segment[16:24], 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return logFile*0x100 + logSegment, nil
+}
@@ -0,0 +1,34 @@
+package backups
+
+import "testing"
+
+func TestBackups_WALRanges(t *testing.T) {
+	t.Parallel()
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+	putTestObjects(t, store,
+		"a/wal_005/0000000100000000000000FE.lzo",
+		"a/wal_005/0000000100000000000000FF.lzo",
+		"a/wal_005/000000010000000100000000.lzo",
+		"a/wal_005/000000010000000100000002.lzo",
+		"a/wal_005/00000002.history.lzo",
+		"a/wal_005/000000020000000100000003.lzo",
+	)
+
+	ranges, err := WALRanges(store, "a")
+	if err != nil {
+		t.Fatalf("WALRanges failed %s", err)
+	}
+	if len(ranges) != 3 {
+		t.Fatalf("Expected 3 WAL ranges, got %v", ranges)
+	}
+	if ranges[0].StartSegment != "0000000100000000000000FE" || ranges[0].EndSegment != "000000010000000100000000" || ranges[0].Segments != 3 || ranges[0].Size == 0 {
+		t.Fatalf("Expected first range to continue into the next log file, got %v", ranges[0])
+	}
+	if ranges[1].StartSegment != "000000010000000100000002" || ranges[1].Segments != 1 {
+		t.Fatalf("Expected a gap to start a new range, got %v", ranges[1])
+	}
+	if ranges[2].Timeline != "00000002" || ranges[2].Segments != 1 {
+		t.Fatalf("Expected a new timeline to start a new range, got %v", ranges[2])
+	}
+}
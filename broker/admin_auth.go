@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+// Roles of admin users. An operator may do anything a read-only user may.
+const (
+	adminRoleReadOnly = "read-only"
+	adminRoleOperator = "operator"
+)
+
+var adminRoleLevels = map[string]int{
+	adminRoleReadOnly: 1,
+	adminRoleOperator: 2,
+}
+
+// adminAuth authenticates admin API requests with basic auth, and authorizes
+// each endpoint by the role of the admin user
+type adminAuth struct {
+	users map[string]*config.AdminUser
+}
+
+// adminHandler serves an admin endpoint to an authorized admin user
+type adminHandler func(w http.ResponseWriter, req *http.Request, admin *config.AdminUser)
+
+// newAdminAuth returns the authorization for the configured admin users. Brokers without
+// admin users allow their own credentials, which the Cloud Controller also has, only as read-only.
+func newAdminAuth(admins []*config.AdminUser, brokerUsername, brokerPassword string) (*adminAuth, error) {
+	if len(admins) == 0 {
+		admins = []*config.AdminUser{{Username: brokerUsername, Password: brokerPassword, Role: adminRoleReadOnly}}
+	}
+	users := map[string]*config.AdminUser{}
+	for _, admin := range admins {
+		if admin.Username == "" || admin.Password == "" {
+			return nil, fmt.Errorf("Broker: admin users must have a username and password")
+		}
+		if _, ok := adminRoleLevels[admin.Role]; !ok {
+			return nil, fmt.Errorf("Broker: admin user %s has role '%s'; expected %s or %s", admin.Username, admin.Role, adminRoleReadOnly, adminRoleOperator)
+		}
+		if _, ok := users[admin.Username]; ok {
+			return nil, fmt.Errorf("Broker: admin user %s is configured more than once", admin.Username)
+		}
+		users[admin.Username] = admin
+	}
+	return &adminAuth{users: users}, nil
+}
+
+// require wraps an endpoint so that it is only called for admin users with at least role
+func (a *adminAuth) require(role string, handler adminHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		username, password, ok := req.BasicAuth()
+		user, found := a.users[username]
+		if !ok || !found || subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			respond(w, http.StatusUnauthorized, "Not authorized")
+			return
+		}
+		if adminRoleLevels[user.Role] < adminRoleLevels[role] {
+			respond(w, http.StatusForbidden, fmt.Sprintf("Admin user %s is %s; %s %s requires %s", username, user.Role, req.Method, req.URL.Path, role))
+			return
+		}
+		handler(w, req, user)
+	}
+}
+
+// anyAdmin adapts an endpoint that serves every authorized admin user alike
+func anyAdmin(handler http.HandlerFunc) adminHandler {
+	return func(w http.ResponseWriter, req *http.Request, admin *config.AdminUser) {
+		handler(w, req)
+	}
+}
+
+// canViewCredentials is true if the admin user may see passwords and keys
+func canViewCredentials(admin *config.AdminUser) bool {
+	return admin.Role == adminRoleOperator
+}
@@ -5,25 +5,52 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/dingotiles/dingo-postgresql-broker/backups"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/state"
 	"github.com/frodenas/brokerapi"
-	"github.com/frodenas/brokerapi/auth"
 	"github.com/pivotal-golang/lager"
 )
 
-func NewAdminAPI(serviceBroker *Broker, logger lager.Logger, brokerCredentials brokerapi.BrokerCredentials) http.Handler {
-	router := newHTTPRouter()
+const (
+	switchoverPollInterval = 2 * time.Second
+	switchoverTimeout      = 5 * time.Minute
+)
 
-	router.Post("/admin/cells/{cell_guid}/demote", demoteCell(serviceBroker, router, logger))
-	router.Get("/admin/cells", adminCells(serviceBroker, router, logger))
-	router.Get("/admin/service_instances/{instance_id}", adminServiceInstances(serviceBroker, router, logger))
-	router.Get("/admin/spaces/{space_guid}/clusterdata_backup_by_name/{name}", adminFindServiceInstanceByName(serviceBroker, router, logger))
-	return wrapAuth(router, brokerCredentials)
-}
+// NewAdminAPI serves the admin endpoints to admin users. Read-only users may view
+// service instances and cells, without their credentials; the other endpoints require an operator.
+func NewAdminAPI(serviceBroker *Broker, logger lager.Logger) http.Handler {
+	router := newHTTPRouter()
+	auth := serviceBroker.adminAuth
+	readOnly := func(handler http.HandlerFunc) http.HandlerFunc {
+		return auth.require(adminRoleReadOnly, anyAdmin(handler))
+	}
+	operator := func(handler http.HandlerFunc) http.HandlerFunc {
+		return auth.require(adminRoleOperator, anyAdmin(handler))
+	}
 
-func wrapAuth(router httpRouter, credentials brokerapi.BrokerCredentials) http.Handler {
-	return auth.NewWrapper(credentials.Username, credentials.Password).Wrap(router)
+	router.Post("/admin/cells/{cell_guid}/demote", operator(demoteCell(serviceBroker, router, logger)))
+	// passwords are only shown to operators
+	router.Get("/admin/cells", auth.require(adminRoleReadOnly, adminCells(serviceBroker, router, logger)))
+	router.Get("/admin/service_instances/{instance_id}", auth.require(adminRoleReadOnly, adminServiceInstances(serviceBroker, router, logger)))
+	router.Get("/admin/service_instances/{instance_id}/backups", readOnly(adminServiceInstanceBackups(serviceBroker, router, logger)))
+	router.Post("/admin/service_instances/{instance_id}/backup", operator(adminBackupNow(serviceBroker, router, logger)))
+	router.Post("/admin/service_instances/{instance_id}/rotate_credentials", operator(adminRotateCredentials(serviceBroker, router, logger)))
+	router.Get("/admin/service_instances/{instance_id}/patroni", readOnly(adminPatroniStatus(serviceBroker, router, logger)))
+	router.Post("/admin/service_instances/{instance_id}/switchover", operator(adminSwitchover(serviceBroker, router, logger)))
+	router.Post("/admin/service_instances/{instance_id}/pause", operator(adminPause(serviceBroker, router, logger)))
+	router.Post("/admin/service_instances/{instance_id}/resume", operator(adminResume(serviceBroker, router, logger)))
+	router.Post("/admin/service_instances/{instance_id}/members/{member_id}/restart", operator(adminRestartMember(serviceBroker, router, logger)))
+	router.Post("/admin/service_instances/{instance_id}/members/{member_id}/reinitialize", operator(adminReinitializeMember(serviceBroker, router, logger)))
+	// recreation data holds credentials
+	router.Get("/admin/spaces/{space_guid}/clusterdata_backup_by_name/{name}", operator(adminFindServiceInstanceByName(serviceBroker, router, logger)))
+	router.Get("/admin/clusterdata", operator(adminListClusterData(serviceBroker, router, logger)))
+	router.Get("/admin/tombstones", operator(adminTombstones(serviceBroker, router, logger)))
+	router.Post("/admin/tombstones/{instance_id}/restore", operator(adminRestoreTombstone(serviceBroker, router, logger)))
+	return router
 }
 
 func respond(w http.ResponseWriter, status int, response interface{}) {
@@ -42,8 +69,8 @@ type adminCell struct {
 	Password         string `json:"password"`
 }
 
-func adminCells(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
+func adminCells(bkr *Broker, router httpRouter, logger lager.Logger) adminHandler {
+	return func(w http.ResponseWriter, req *http.Request, admin *config.AdminUser) {
 		logger := bkr.newLoggingSession("admin.cells", lager.Data{})
 		defer logger.Info("done")
 
@@ -58,6 +85,9 @@ func adminCells(bkr *Broker, router httpRouter, logger lager.Logger) http.Handle
 				Username:         cell.Username,
 				Password:         cell.Password,
 			}
+			if !canViewCredentials(admin) {
+				adminCell.Password = ""
+			}
 			resultCells = append(resultCells, &adminCell)
 		}
 
@@ -65,8 +95,32 @@ func adminCells(bkr *Broker, router httpRouter, logger lager.Logger) http.Handle
 	}
 }
 
-func adminServiceInstances(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
+// withoutSecrets returns a copy of a cluster's state without its passwords and TLS private key
+func withoutSecrets(cluster structs.ClusterState) structs.ClusterState {
+	cluster.AdminCredentials.Password = ""
+	cluster.SuperuserCredentials.Password = ""
+	cluster.AppCredentials.Password = ""
+	if cluster.TLS != nil {
+		clusterTLS := *cluster.TLS
+		clusterTLS.PrivateKey = ""
+		cluster.TLS = &clusterTLS
+	}
+	return cluster
+}
+
+// adminServiceInstance is the cluster state of a service instance, with the live replication lag of each node
+type adminServiceInstance struct {
+	structs.ClusterState
+	Nodes []adminNode `json:"nodes"`
+}
+
+type adminNode struct {
+	structs.Node
+	ReplicationLag *int64 `json:"replication_lag,omitempty"`
+}
+
+func adminServiceInstances(bkr *Broker, router httpRouter, logger lager.Logger) adminHandler {
+	return func(w http.ResponseWriter, req *http.Request, admin *config.AdminUser) {
 		vars := router.Vars(req)
 		instanceID := structs.ClusterID(vars["instance_id"])
 
@@ -77,9 +131,28 @@ func adminServiceInstances(bkr *Broker, router httpRouter, logger lager.Logger)
 		if err != nil {
 			logger.Error("load-cluster.error", err)
 			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Lag is informational; the cluster may have no leader right now
+		lags, err := bkr.patroni.ReplicationLag(cluster.Scope())
+		if err != nil {
+			logger.Info("replication-lag.unavailable", lager.Data{"error": err.Error()})
 		}
 
-		respond(w, http.StatusOK, cluster)
+		if !canViewCredentials(admin) {
+			cluster = withoutSecrets(cluster)
+		}
+		instance := adminServiceInstance{ClusterState: cluster, Nodes: []adminNode{}}
+		for _, node := range cluster.Nodes {
+			adminNode := adminNode{Node: *node}
+			if lag, ok := lags[node.ID]; ok {
+				adminNode.ReplicationLag = &lag
+			}
+			instance.Nodes = append(instance.Nodes, adminNode)
+		}
+
+		respond(w, http.StatusOK, instance)
 	}
 }
 
@@ -111,7 +184,10 @@ func demoteCell(bkr *Broker, router httpRouter, logger lager.Logger) http.Handle
 					return
 				}
 
-				err = bkr.patroni.FailoverFrom(thisCluster.InstanceID, node.ID)
+				err = bkr.patroni.FailoverFrom(thisCluster.Scope(), node.ID)
+				event := structs.NewLifecycleEvent(structs.EventFailover, "failover", *thisCluster, err)
+				event.FromNode = node.ID
+				bkr.fireEvent(event)
 				if err != nil {
 					logger.Error("failover.error",
 						fmt.Errorf("Couldn't failover member %s from instance %s: '%s'",
@@ -139,10 +215,432 @@ func adminFindServiceInstanceByName(bkr *Broker, router httpRouter, logger lager
 		data, err := bkr.callbacks.ClusterDataFindServiceInstanceByName(spaceGUID, name)
 		if err != nil {
 			logger.Error("error", err)
+			status := http.StatusInternalServerError
+			if _, ok := err.(clusterDataNotFoundError); ok {
+				status = http.StatusNotFound
+			}
+			respond(w, status, err.Error())
+			return
+		}
+
+		respond(w, http.StatusOK, data)
+	}
+}
+
+// adminListClusterData lists the recreation data of every service instance, including deleted ones,
+// kept by the broker's clusterdata store
+func adminListClusterData(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := bkr.newLoggingSession("admin.list-clusterdata", lager.Data{})
+		defer logger.Info("done")
+
+		if !bkr.callbacks.CanListRecreationData() {
+			respond(w, http.StatusNotImplemented, "Broker keeps clusterdata backups with callbacks, or not at all; listing requires clusterdata.base_uri")
+			return
+		}
+		all, err := bkr.callbacks.ListRecreationData()
+		if err != nil {
+			logger.Error("list.error", err)
+			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respond(w, http.StatusOK, all)
+	}
+}
+
+// adminClusterBackups are the backups of one Patroni cluster of a service instance
+type adminClusterBackups struct {
+	Scope          structs.ClusterID       `json:"scope"`
+	URI            string                  `json:"uri"`
+	BaseBackups    []backups.BaseBackup    `json:"base_backups"`
+	WAL            []backups.WALRange      `json:"wal"`
+	RecoveryWindow *backups.RecoveryWindow `json:"recovery_window,omitempty"`
+}
+
+// adminServiceInstanceBackups lists the backups of the cluster serving a service instance,
+// and of the cluster kept for rolling back an upgrade. Deleted service instances are
+// found from their recreation data, as their backups are kept.
+func adminServiceInstanceBackups(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+
+		logger := bkr.newLoggingSession("admin.service-instance-backups", lager.Data{"instance-id": instanceID})
+		defer logger.Info("done")
+
+		if bkr.backupStore == nil {
+			respond(w, http.StatusNotImplemented, "Broker missing configuration backups.base_uri")
+			return
+		}
+
+		exists, err := bkr.state.ClusterExists(instanceID)
+		if err != nil {
+			logger.Error("cluster-exists.error", err)
+			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var scopes []structs.ClusterID
+		if exists {
+			cluster, err := bkr.state.LoadCluster(instanceID)
+			if err != nil {
+				logger.Error("load-cluster.error", err)
+				respond(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			scopes = append(scopes, cluster.Scope())
+			if cluster.PreviousCluster != nil {
+				scopes = append(scopes, cluster.PreviousCluster.PatroniScope)
+			}
+		} else if bkr.callbacks.Configured() {
+			data, err := bkr.callbacks.RestoreRecreationData(instanceID)
+			if err != nil {
+				logger.Error("restore-recreation-data.error", err)
+				respond(w, http.StatusNotFound, fmt.Sprintf("Service instance %s not found", instanceID))
+				return
+			}
+			scopes = append(scopes, data.Scope())
+		} else {
+			respond(w, http.StatusNotFound, fmt.Sprintf("Service instance %s not found", instanceID))
+			return
+		}
+
+		result := []adminClusterBackups{}
+		for _, scope := range scopes {
+			clusterBackups := adminClusterBackups{Scope: scope, URI: bkr.backupStore.URI(string(scope))}
+			var err error
+			clusterBackups.BaseBackups, err = backups.BaseBackups(bkr.backupStore, scope)
+			if err == nil {
+				clusterBackups.WAL, err = backups.WALRanges(bkr.backupStore, scope)
+			}
+			if err != nil {
+				logger.Error("list-backups.error", err, lager.Data{"scope": scope})
+				respond(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			// a cluster without a complete base backup cannot be restored
+			if window, err := backups.FindRecoveryWindow(bkr.backupStore, scope); err == nil {
+				clusterBackups.RecoveryWindow = &window
+			}
+			result = append(result, clusterBackups)
+		}
+
+		respond(w, http.StatusOK, result)
+	}
+}
+
+// adminBackupNow starts a base backup of a service instance; its progress is reported by last_operation
+func adminBackupNow(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+
+		logger := bkr.newLoggingSession("admin.backup-now", lager.Data{"instance-id": instanceID})
+		defer logger.Info("done")
+
+		cluster, status, err := bkr.adminLoadClusterMember(instanceID, "")
+		if err != nil {
+			respond(w, status, err.Error())
+			return
+		}
+
+		if err = bkr.backupNow(state.NewClusterModel(bkr.state, cluster), logger); err != nil {
+			logger.Error("error", err)
+			respond(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		respond(w, http.StatusAccepted, fmt.Sprintf("Backup of %s started", instanceID))
+	}
+}
+
+type adminRotateCredentialsRequest struct {
+	Credentials []string `json:"credentials"`
+}
+
+// adminRotateCredentials sets new passwords for a service instance's credentials; all of them
+// unless the request body lists some. Its progress is reported by last_operation.
+func adminRotateCredentials(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+
+		logger := bkr.newLoggingSession("admin.rotate-credentials", lager.Data{"instance-id": instanceID})
+		defer logger.Info("done")
+
+		request := adminRotateCredentialsRequest{Credentials: structs.RotatableCredentials}
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+				respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid rotate credentials request: %s", err))
+				return
+			}
+		}
+		credentials, err := structs.OrderedCredentials(request.Credentials)
+		if err != nil {
+			respond(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		cluster, status, err := bkr.adminLoadClusterMember(instanceID, "")
+		if err != nil {
+			respond(w, status, err.Error())
+			return
+		}
+
+		if err = bkr.rotateCredentials(state.NewClusterModel(bkr.state, cluster), credentials, logger); err != nil {
+			logger.Error("error", err)
+			respond(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		respond(w, http.StatusAccepted, fmt.Sprintf("Rotating %v credentials of %s", credentials, instanceID))
+	}
+}
+
+// adminTombstones lists the deprovisioned service instances that can still be restored
+func adminTombstones(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := bkr.newLoggingSession("admin.tombstones", lager.Data{})
+		defer logger.Info("done")
+
+		tombstones, err := bkr.state.LoadAllTombstones()
+		if err != nil {
+			logger.Error("load-tombstones.error", err)
 			respond(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		respond(w, http.StatusOK, tombstones)
+	}
+}
 
-		respond(w, http.StatusNotFound, data)
+// adminRestoreTombstone recreates a deprovisioned service instance under its original ID and ports.
+// The optional request body holds the parameters accepted when recreating, such as "restore-to".
+func adminRestoreTombstone(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+
+		logger := bkr.newLoggingSession("admin.restore-tombstone", lager.Data{"instance-id": instanceID})
+		defer logger.Info("done")
+
+		var parameters map[string]interface{}
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&parameters); err != nil {
+				respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid restore request: %s", err))
+				return
+			}
+		}
+
+		if _, err := bkr.state.LoadTombstone(instanceID); err != nil {
+			respond(w, http.StatusNotFound, fmt.Sprintf("Deprovisioned service instance %s not found", instanceID))
+			return
+		}
+
+		_, _, err := bkr.Recreate(instanceID, brokerapi.ProvisionDetails{Parameters: parameters}, true)
+		if err != nil {
+			logger.Error("recreate.error", err)
+			respond(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		respond(w, http.StatusAccepted, fmt.Sprintf("Restoring %s", instanceID))
+	}
+}
+
+func adminPatroniStatus(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+
+		logger := bkr.newLoggingSession("admin.patroni-status", lager.Data{"instance-id": instanceID})
+		defer logger.Info("done")
+
+		cluster, status, err := bkr.adminLoadClusterMember(instanceID, "")
+		if err != nil {
+			respond(w, status, err.Error())
+			return
+		}
+
+		statuses, err := bkr.patroni.ClusterStatus(cluster.Scope())
+		if err != nil {
+			logger.Error("cluster-status.error", err)
+			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respond(w, http.StatusOK, statuses)
+	}
+}
+
+type adminSwitchoverRequest struct {
+	Candidate   string    `json:"candidate"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+}
+
+func adminSwitchover(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+
+		logger := bkr.newLoggingSession("admin.switchover", lager.Data{"instance-id": instanceID})
+		defer logger.Info("done")
+
+		var switchover adminSwitchoverRequest
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&switchover); err != nil {
+				respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid switchover request: %s", err))
+				return
+			}
+		}
+
+		cluster, status, err := bkr.adminLoadClusterMember(instanceID, switchover.Candidate)
+		if err != nil {
+			respond(w, status, err.Error())
+			return
+		}
+
+		// the leader is looked up beforehand so that the event can say which node stepped down
+		leaderID, _ := bkr.patroni.ClusterLeader(cluster.Scope())
+		err = bkr.patroni.Switchover(cluster.Scope(), switchover.Candidate, switchover.ScheduledAt)
+		if err != nil {
+			logger.Error("switchover.error", err)
+			event := structs.NewLifecycleEvent(structs.EventFailover, "switchover", cluster, err)
+			event.FromNode = leaderID
+			event.ToNode = switchover.Candidate
+			bkr.fireEvent(event)
+			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Patroni has accepted the switchover; the new leader is elected after the response
+		if switchover.ScheduledAt.IsZero() {
+			go bkr.waitForSwitchover(cluster, leaderID, switchover.Candidate, logger)
+			respond(w, http.StatusAccepted, fmt.Sprintf("Switchover of %s requested", instanceID))
+		} else {
+			respond(w, http.StatusAccepted, fmt.Sprintf("Switchover of %s scheduled for %s", instanceID, switchover.ScheduledAt.Format(time.RFC3339)))
+		}
+	}
+}
+
+// waitForSwitchover fires the failover event once a leader other than previousLeaderID,
+// and candidateID if one was requested, has taken over the cluster.
+func (bkr *Broker) waitForSwitchover(cluster structs.ClusterState, previousLeaderID, candidateID string, logger lager.Logger) {
+	var err error
+	leaderID := previousLeaderID
+	deadline := time.Now().Add(switchoverTimeout)
+	for {
+		leaderID, err = bkr.patroni.ClusterLeader(cluster.Scope())
+		if err == nil && leaderID != "" && leaderID != previousLeaderID {
+			if candidateID == "" || leaderID == candidateID {
+				break
+			}
+			err = fmt.Errorf("Broker: member %s took over %s rather than %s", leaderID, cluster.InstanceID, candidateID)
+			break
+		}
+		if time.Now().After(deadline) {
+			err = fmt.Errorf("Broker: no new leader of %s was elected within %s", cluster.InstanceID, switchoverTimeout)
+			break
+		}
+		time.Sleep(switchoverPollInterval)
+	}
+	if err != nil {
+		logger.Error("switchover.wait-for-leader", err)
+	}
+
+	event := structs.NewLifecycleEvent(structs.EventFailover, "switchover", cluster, err)
+	event.FromNode = previousLeaderID
+	event.ToNode = leaderID
+	if leaderID == "" || leaderID == previousLeaderID {
+		event.ToNode = candidateID
+	}
+	bkr.fireEvent(event)
+}
+
+func adminPause(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return adminClusterAction(bkr, router, "admin.pause", "Paused", bkr.patroni.Pause)
+}
+
+func adminResume(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return adminClusterAction(bkr, router, "admin.resume", "Resumed", bkr.patroni.Resume)
+}
+
+func adminRestartMember(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return adminMemberAction(bkr, router, "admin.restart-member", "Restarted", bkr.patroni.Restart)
+}
+
+func adminReinitializeMember(bkr *Broker, router httpRouter, logger lager.Logger) http.HandlerFunc {
+	return adminMemberAction(bkr, router, "admin.reinitialize-member", "Reinitialized", bkr.patroni.Reinitialize)
+}
+
+func adminClusterAction(bkr *Broker, router httpRouter, session, done string, action func(structs.ClusterID) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+
+		logger := bkr.newLoggingSession(session, lager.Data{"instance-id": instanceID})
+		defer logger.Info("done")
+
+		cluster, status, err := bkr.adminLoadClusterMember(instanceID, "")
+		if err != nil {
+			respond(w, status, err.Error())
+			return
+		}
+
+		if err := action(cluster.Scope()); err != nil {
+			logger.Error("error", err)
+			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respond(w, http.StatusOK, fmt.Sprintf("%s %s", done, instanceID))
+	}
+}
+
+func adminMemberAction(bkr *Broker, router httpRouter, session, done string, action func(structs.ClusterID, string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := router.Vars(req)
+		instanceID := structs.ClusterID(vars["instance_id"])
+		memberID := vars["member_id"]
+
+		logger := bkr.newLoggingSession(session, lager.Data{"instance-id": instanceID, "member-id": memberID})
+		defer logger.Info("done")
+
+		cluster, status, err := bkr.adminLoadClusterMember(instanceID, memberID)
+		if err != nil {
+			respond(w, status, err.Error())
+			return
+		}
+
+		if err := action(cluster.Scope(), memberID); err != nil {
+			logger.Error("error", err)
+			respond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respond(w, http.StatusOK, fmt.Sprintf("%s member %s of %s", done, memberID, instanceID))
+	}
+}
+
+// adminLoadClusterMember checks that memberID is a node of the service instance.
+// An empty memberID only checks the service instance. On error, it also returns the HTTP status to respond with.
+func (bkr *Broker) adminLoadClusterMember(instanceID structs.ClusterID, memberID string) (cluster structs.ClusterState, status int, err error) {
+	exists, err := bkr.state.ClusterExists(instanceID)
+	if err != nil {
+		return cluster, http.StatusInternalServerError, err
+	}
+	if !exists {
+		return cluster, http.StatusNotFound, fmt.Errorf("Service instance %s not found", instanceID)
+	}
+	cluster, err = bkr.state.LoadCluster(instanceID)
+	if err != nil {
+		return cluster, http.StatusInternalServerError, err
+	}
+	if memberID == "" {
+		return cluster, http.StatusOK, nil
+	}
+	for _, node := range cluster.Nodes {
+		if node.ID == memberID {
+			return cluster, http.StatusOK, nil
+		}
 	}
+	return cluster, http.StatusNotFound, fmt.Errorf("Service instance %s has no member %s", instanceID, memberID)
 }
@@ -0,0 +1,101 @@
+package broker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/testutil"
+)
+
+func TestAdminAPI_FindServiceInstanceByName(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestAdminAPI_FindServiceInstanceByName"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	for output, expectedStatus := range map[string]int{
+		`{"instance_id": "instance-id"}`: http.StatusOK,
+		`{}`:                             http.StatusNotFound,
+	} {
+		bkr := &Broker{logger: logger}
+		bkr.callbacks = NewCallbacks(config.Callbacks{
+			ClusterDataFindByName: &config.CallbackCommand{Command: "echo", Arguments: []string{output}},
+		}, logger)
+		router := newHTTPRouter()
+		router.Get("/admin/spaces/{space_guid}/clusterdata_backup_by_name/{name}", adminFindServiceInstanceByName(bkr, router, logger))
+
+		req, _ := http.NewRequest("GET", "/admin/spaces/space-guid/clusterdata_backup_by_name/test-db", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != expectedStatus {
+			t.Fatalf("Expected status %d for callback output %s, got %d", expectedStatus, output, resp.Code)
+		}
+	}
+}
+
+func TestAdminAPI_Roles(t *testing.T) {
+	t.Parallel()
+
+	auth, err := newAdminAuth([]*config.AdminUser{
+		{Username: "viewer", Password: "viewer-pw", Role: adminRoleReadOnly},
+		{Username: "ops", Password: "ops-pw", Role: adminRoleOperator},
+	}, "broker", "broker-pw")
+	if err != nil {
+		t.Fatalf("newAdminAuth failed %s", err)
+	}
+	var sawCredentials bool
+	handler := func(w http.ResponseWriter, req *http.Request, admin *config.AdminUser) {
+		sawCredentials = canViewCredentials(admin)
+		respond(w, http.StatusOK, "ok")
+	}
+
+	for _, test := range []struct {
+		username, password, role string
+		expectedStatus           int
+	}{
+		{"", "", adminRoleReadOnly, http.StatusUnauthorized},
+		{"broker", "broker-pw", adminRoleReadOnly, http.StatusUnauthorized},
+		{"viewer", "wrong", adminRoleReadOnly, http.StatusUnauthorized},
+		{"viewer", "viewer-pw", adminRoleReadOnly, http.StatusOK},
+		{"viewer", "viewer-pw", adminRoleOperator, http.StatusForbidden},
+		{"ops", "ops-pw", adminRoleReadOnly, http.StatusOK},
+		{"ops", "ops-pw", adminRoleOperator, http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", "/admin/cells", nil)
+		if test.username != "" {
+			req.SetBasicAuth(test.username, test.password)
+		}
+		resp := httptest.NewRecorder()
+		sawCredentials = false
+		auth.require(test.role, handler)(resp, req)
+		if resp.Code != test.expectedStatus {
+			t.Fatalf("Expected status %d for %s requiring %s, got %d", test.expectedStatus, test.username, test.role, resp.Code)
+		}
+		if resp.Code == http.StatusOK && sawCredentials != (test.username == "ops") {
+			t.Fatalf("Expected only operators to view credentials, %s did: %v", test.username, sawCredentials)
+		}
+	}
+}
+
+func TestAdminAPI_RolesConfiguration(t *testing.T) {
+	t.Parallel()
+
+	auth, err := newAdminAuth(nil, "broker", "broker-pw")
+	if err != nil {
+		t.Fatalf("newAdminAuth failed %s", err)
+	}
+	if user := auth.users["broker"]; user == nil || user.Role != adminRoleReadOnly {
+		t.Fatalf("Expected broker credentials to be read-only without admin users, got %v", auth.users)
+	}
+	for _, admins := range [][]*config.AdminUser{
+		{{Username: "ops", Password: "ops-pw", Role: "superuser"}},
+		{{Username: "ops", Role: adminRoleOperator}},
+		{{Username: "ops", Password: "a", Role: adminRoleOperator}, {Username: "ops", Password: "b", Role: adminRoleReadOnly}},
+	} {
+		if _, err = newAdminAuth(admins, "broker", "broker-pw"); err == nil {
+			t.Fatalf("Expected error for admin users %v", admins)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/backups"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/state"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	backupNowPollInterval = 15 * time.Second
+	backupNowTimeout      = 2 * time.Hour
+)
+
+// backupNow starts a base backup of a service instance's cluster, taken by its leader.
+// Progress is reported as a scheduling operation, so that last_operation can be polled until
+// the backup is complete.
+func (bkr *Broker) backupNow(clusterModel *state.ClusterModel, logger lager.Logger) error {
+	if bkr.backupStore == nil {
+		return fmt.Errorf("Broker missing configuration backups.base_uri to support 'backup-now' feature")
+	}
+	if clusterModel.SchedulingInfo().Status == structs.SchedulingStatusInProgress {
+		return fmt.Errorf("Broker: Service instance %s is being changed; back it up once that has completed", clusterModel.InstanceID())
+	}
+
+	go bkr.runBackupNow(clusterModel, logger.Session("backup-now"))
+	return nil
+}
+
+func (bkr *Broker) runBackupNow(clusterModel *state.ClusterModel, logger lager.Logger) {
+	logger.Info("start")
+	defer logger.Info("done")
+	defer bkr.fireScheduledEvent("", "backup", clusterModel)
+
+	scope := clusterModel.PatroniScope()
+	// the new base backup is recognised by sorting after the latest one; it starts at a later WAL segment
+	previous, _ := backups.LatestBaseBackup(bkr.backupStore, scope)
+
+	clusterModel.BeginScheduling(2)
+
+	clusterModel.SchedulingStepStarted("RequestBaseBackup")
+	leaderID, err := bkr.patroni.ClusterLeader(scope)
+	if err == nil {
+		err = bkr.scheduler.BackupNode(clusterModel, leaderID)
+	}
+	if err != nil {
+		logger.Error("request-base-backup", err)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful starting backup of database: %s", err))
+		return
+	}
+	clusterModel.SchedulingStepCompleted()
+
+	clusterModel.SchedulingStepStarted("WaitForBaseBackup")
+	backup, err := bkr.waitForBaseBackupAfter(scope, previous.Name, backupNowTimeout)
+	if err != nil {
+		logger.Error("wait-for-base-backup", err)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful backing up database: %s", err))
+		return
+	}
+	logger.Info("base-backup", lager.Data{"name": backup.Name, "size": backup.Size})
+	clusterModel.SchedulingStepCompleted()
+}
+
+// waitForBaseBackupAfter waits for a complete base backup that is newer than the named one
+func (bkr *Broker) waitForBaseBackupAfter(scope structs.ClusterID, previousName string, timeout time.Duration) (backups.BaseBackup, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		latest, err := backups.LatestBaseBackup(bkr.backupStore, scope)
+		if err == nil && latest.Name > previousName {
+			return latest, nil
+		}
+		if time.Now().After(deadline) {
+			return backups.BaseBackup{}, fmt.Errorf("Backups: no base backup of %s completed within %s", scope, timeout)
+		}
+		time.Sleep(backupNowPollInterval)
+	}
+}
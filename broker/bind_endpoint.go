@@ -21,6 +21,12 @@ type CredentialsHash struct {
 	SuperuserPassword string `json:"superuser_password,omitempty"`
 	SuperuserURI      string `json:"superuser_uri,omitempty"`
 	SuperuserJDBCURI  string `json:"superuser_jdbcUrl,omitempty"`
+	ReplicaHost       string `json:"replica_host,omitempty"`
+	ReplicaPort       int    `json:"replica_port,omitempty"`
+	ReplicaURI        string `json:"replica_uri,omitempty"`
+	SSLMode           string `json:"sslmode,omitempty"`
+	// CACert verifies the server's certificate; it is given with sslmode verify-full
+	CACert string `json:"ca_cert,omitempty"`
 }
 
 // Bind returns access credentials for a service instance
@@ -48,30 +54,54 @@ func (bkr *Broker) bind(instanceID structs.ClusterID, bindingID string, details
 	routerHost := bkr.config.BindHost
 	appUsername := cluster.AppCredentials.Username
 	appPassword := cluster.AppCredentials.Password
-	uri := fmt.Sprintf("postgres://%s:%s@%s:%d/postgres", appUsername, appPassword, routerHost, publicPort)
+	// clusters with a certificate require clients to verify it
+	query := ""
+	if cluster.TLS != nil {
+		query = "?sslmode=" + sslMode
+	}
+	uri := fmt.Sprintf("postgres://%s:%s@%s:%d/postgres%s", appUsername, appPassword, routerHost, publicPort, query)
 	// jdbc := fmt.Sprintf("jdbc:postgresql://%s:%d/postgres?username=%s&password=%s", routerHost, publicPort, appUsername, appPassword)
 	superuserUsername := cluster.SuperuserCredentials.Username
 	superuserPassword := cluster.SuperuserCredentials.Password
-	superuserURI := fmt.Sprintf("postgres://%s:%s@%s:%d/postgres", superuserUsername, superuserPassword, routerHost, publicPort)
+	superuserURI := fmt.Sprintf("postgres://%s:%s@%s:%d/postgres%s", superuserUsername, superuserPassword, routerHost, publicPort, query)
 	// superuserJDBCURI := fmt.Sprintf("jdbc:postgresql://%s:%d/postgres?username=%s&password=%s", routerHost, publicPort, superuserUsername, superuserPassword)
-	return brokerapi.BindingResponse{
-		Credentials: CredentialsHash{
-			Host:     routerHost,
-			Port:     publicPort,
-			Username: appUsername,
-			Password: appPassword,
-			URI:      uri,
-			// JDBCURI:           jdbc,
-			SuperuserUsername: superuserUsername,
-			SuperuserPassword: superuserPassword,
-			SuperuserURI:      superuserURI,
-			// SuperuserJDBCURI:  superuserJDBCURI,
-		},
-	}, nil
+	credentials := CredentialsHash{
+		Host:     routerHost,
+		Port:     publicPort,
+		Username: appUsername,
+		Password: appPassword,
+		URI:      uri,
+		// JDBCURI:           jdbc,
+		SuperuserUsername: superuserUsername,
+		SuperuserPassword: superuserPassword,
+		SuperuserURI:      superuserURI,
+		// SuperuserJDBCURI:  superuserJDBCURI,
+	}
+
+	// Read-only access is load-balanced across replicas, so only offered once there are some
+	if cluster.AllocatedReplicaPort != 0 && cluster.NodeCount() > 1 {
+		credentials.ReplicaHost = routerHost
+		credentials.ReplicaPort = cluster.AllocatedReplicaPort
+		credentials.ReplicaURI = fmt.Sprintf("postgres://%s:%s@%s:%d/postgres%s", appUsername, appPassword, routerHost, cluster.AllocatedReplicaPort, query)
+	}
+	if cluster.TLS != nil {
+		credentials.SSLMode = sslMode
+		credentials.CACert = cluster.TLS.CACert
+	}
+
+	event := structs.NewLifecycleEvent(structs.EventBound, "bind", cluster, nil)
+	event.BindingID = bindingID
+	bkr.fireEvent(event)
+
+	return brokerapi.BindingResponse{Credentials: credentials}, nil
 }
 
 func (bkr *Broker) assertBindPrecondition(instanceID structs.ClusterID) error {
-	if bkr.state.ClusterExists(instanceID) == false {
+	exists, err := bkr.state.ClusterExists(instanceID)
+	if err != nil {
+		return err
+	}
+	if exists == false {
 		return fmt.Errorf("Service instance %s doesn't exist", instanceID)
 	}
 	return nil
@@ -1,18 +1,34 @@
 package broker
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/dingotiles/dingo-postgresql-broker/backups"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/certificates"
+	"github.com/dingotiles/dingo-postgresql-broker/clusterdata"
 	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/encryption"
 	"github.com/dingotiles/dingo-postgresql-broker/patroni"
+	"github.com/dingotiles/dingo-postgresql-broker/postgresql"
 	"github.com/dingotiles/dingo-postgresql-broker/routing"
 	"github.com/dingotiles/dingo-postgresql-broker/scheduler"
 	"github.com/dingotiles/dingo-postgresql-broker/state"
 	"github.com/frodenas/brokerapi"
 	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+const (
+	orphanedPortsSweepInterval   = 5 * time.Minute
+	routingTablesRestartInterval = 10 * time.Second
+	backupRetentionInterval      = 1 * time.Hour
 )
 
 // Broker is the core struct for the Broker webapp
@@ -22,41 +38,97 @@ type Broker struct {
 
 	logger lager.Logger
 	cells  []*config.Cell
+	// tlsConfig is nil unless the broker serves HTTPS
+	tlsConfig *tls.Config
+	adminAuth *adminAuth
 
-	callbacks *Callbacks
-	backups   config.Backups
+	callbacks   *Callbacks
+	backups     config.Backups
+	backupStore backups.BackupStore
+	keyring     *encryption.Keyring
+	postgresql  config.PostgreSQL
+	// certificateAuthority is nil unless PostgreSQL TLS is configured
+	certificateAuthority *certificates.Authority
 
 	router    interfaces.Router
 	scheduler interfaces.Scheduler
 	state     interfaces.State
 	patroni   interfaces.Patroni
+	psql      interfaces.Postgresql
 	cf        interfaces.CloudFoundry
 }
 
 // NewBroker is a constructor for a Broker webapp struct
 func NewBroker(config *config.Config) (*Broker, error) {
 	bkr := &Broker{
-		config:  config.Broker,
-		catalog: config.Catalog,
-		backups: config.Backups,
-		cells:   config.Scheduler.Cells,
+		config:     config.Broker,
+		catalog:    config.Catalog,
+		backups:    config.Backups,
+		postgresql: config.PostgreSQL,
+		cells:      config.Scheduler.Cells,
 	}
 
 	bkr.logger = bkr.setupLogger()
+	if config.Broker.TLS.Enabled() {
+		tlsConfig, err := config.Broker.TLS.ServerConfig()
+		if err != nil {
+			bkr.logger.Error("new-broker.tls-config.error", err)
+			return nil, err
+		}
+		bkr.tlsConfig = tlsConfig
+	}
+	adminAuth, err := newAdminAuth(config.Broker.Admins, config.Broker.Username, config.Broker.Password)
+	if err != nil {
+		bkr.logger.Error("new-broker.admin-auth.error", err)
+		return nil, err
+	}
+	if len(config.Broker.Admins) == 0 {
+		bkr.logger.Info("new-broker.admin-auth.broker-credentials", lager.Data{"message": "no broker.admins configured; the broker credentials may only call the admin API as read-only"})
+	}
+	bkr.adminAuth = adminAuth
 	bkr.callbacks = NewCallbacks(config.Callbacks, bkr.logger)
-	var err error
-	bkr.state, err = state.NewStateEtcd(config.Etcd, bkr.logger)
+	keyring, err := encryption.NewKeyring(config.Encryption)
+	if err != nil {
+		bkr.logger.Error("new-broker.new-keyring.error", err)
+		return nil, err
+	}
+	bkr.keyring = keyring
+	bkr.callbacks.keyring = keyring
+
+	stateEtcd, err := state.NewStateEtcd(config.Etcd, bkr.logger)
 	if err != nil {
 		bkr.logger.Error("new-broker.new-state.error", err)
 		return nil, err
 	}
+	stateEtcd.SetKeyring(keyring)
+	bkr.state = stateEtcd
 
-	bkr.patroni, err = patroni.NewPatroni(config.Etcd, bkr.logger)
+	bkr.patroni, err = patroni.NewPatroni(config.Etcd, config.Patroni, bkr.logger)
 	if err != nil {
 		bkr.logger.Error("new-broker.new-patroni.error", err)
 		return nil, err
 	}
 
+	bkr.psql = postgresql.NewPsql(config.PostgreSQL, bkr.logger)
+
+	bkr.certificateAuthority, err = certificates.NewAuthority(config.PostgreSQL.TLS)
+	if err != nil {
+		bkr.logger.Error("new-broker.new-certificate-authority.error", err)
+		return nil, err
+	}
+
+	bkr.backupStore, err = backups.NewBackupStore(config.Backups)
+	if err != nil {
+		bkr.logger.Error("new-broker.new-backup-store.error", err)
+		return nil, err
+	}
+
+	bkr.callbacks.clusterDataStore, err = clusterdata.NewStore(config.ClusterData)
+	if err != nil {
+		bkr.logger.Error("new-broker.new-clusterdata-store.error", err)
+		return nil, err
+	}
+
 	bkr.scheduler, err = scheduler.NewScheduler(config.Scheduler, bkr.patroni, bkr.logger)
 	if err != nil {
 		bkr.logger.Error("new-broker.new-scheduler.error", err)
@@ -68,6 +140,10 @@ func NewBroker(config *config.Config) (*Broker, error) {
 		bkr.logger.Error("new-broker.new-router.error", err)
 		return nil, err
 	}
+	if err = bkr.router.InitializePorts(); err != nil {
+		bkr.logger.Error("new-broker.initialize-ports.error", err)
+		return nil, err
+	}
 
 	// Optionally, provisioned services can asynchronously look up service name
 	// to aide disaster recovery/undo-delete/recreate-from-backup by users
@@ -93,10 +169,85 @@ func (bkr *Broker) Run() {
 	brokerAPI := brokerapi.New(bkr, bkr.logger, credentials)
 	http.Handle("/v2/", brokerAPI)
 
-	adminAPI := NewAdminAPI(bkr, bkr.logger, credentials)
+	adminAPI := NewAdminAPI(bkr, bkr.logger)
 	http.Handle("/admin/", adminAPI)
 
-	bkr.logger.Fatal("http-listen", http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", port), nil))
+	go bkr.sweepOrphanedPorts()
+	go bkr.publishRoutingTables()
+	go bkr.removeExpiredPreviousClusters()
+	go bkr.removeExpiredTombstones()
+	go bkr.retireReplacedAppUsers()
+	if bkr.certificateAuthority != nil {
+		go bkr.warnOfExpiringClusterTLS()
+	}
+	if bkr.backupStore != nil && bkr.backups.RetainBaseBackups > 0 {
+		go bkr.applyBackupRetention()
+	}
+
+	address := fmt.Sprintf("0.0.0.0:%d", port)
+	if bkr.tlsConfig != nil {
+		// the certificate and key are already loaded into tlsConfig, rather than read from files
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			bkr.logger.Fatal("https-listen", err)
+		}
+		bkr.logger.Fatal("https-listen", http.Serve(tls.NewListener(listener, bkr.tlsConfig), nil))
+	}
+	bkr.logger.Fatal("http-listen", http.ListenAndServe(address, nil))
+}
+
+// sweepOrphanedPorts periodically reclaims ports reserved by provisions that never stored any state
+func (bkr *Broker) sweepOrphanedPorts() {
+	for range time.Tick(orphanedPortsSweepInterval) {
+		logger := bkr.logger.Session("sweep-orphaned-ports")
+		reclaimed, err := bkr.router.SweepOrphanedReservations(portHolders{state: bkr.state})
+		if err != nil {
+			logger.Error("error", err)
+			continue
+		}
+		if len(reclaimed) > 0 {
+			logger.Info("reclaimed", lager.Data{"instance-ids": reclaimed})
+		}
+	}
+}
+
+// publishRoutingTables keeps /routing/table/<id> pointing at the leader of each cluster
+func (bkr *Broker) publishRoutingTables() {
+	logger := bkr.logger.Session("publish-routing-tables")
+	for {
+		err := bkr.router.PublishRoutingTables(context.Background())
+		logger.Error("restarting", err)
+		time.Sleep(routingTablesRestartInterval)
+	}
+}
+
+// applyBackupRetention periodically deletes the base backups of each cluster beyond the
+// configured number to keep, along with the WAL segments that only they needed
+func (bkr *Broker) applyBackupRetention() {
+	for range time.Tick(backupRetentionInterval) {
+		logger := bkr.logger.Session("apply-backup-retention")
+		clusters, err := bkr.state.LoadAllRunningClusters()
+		if err != nil {
+			logger.Error("load-clusters", err)
+			continue
+		}
+		for _, cluster := range clusters {
+			scopes := []structs.ClusterID{cluster.Scope()}
+			if cluster.PreviousCluster != nil {
+				scopes = append(scopes, cluster.PreviousCluster.PatroniScope)
+			}
+			for _, scope := range scopes {
+				deleted, err := backups.ApplyRetention(bkr.backupStore, scope, bkr.backups.RetainBaseBackups)
+				if err != nil {
+					logger.Error("apply", err, lager.Data{"scope": scope})
+					continue
+				}
+				if len(deleted) > 0 {
+					logger.Info("deleted", lager.Data{"scope": scope, "count": len(deleted)})
+				}
+			}
+		}
+	}
 }
 
 func (bkr *Broker) setupLogger() lager.Logger {
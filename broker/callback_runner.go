@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	defaultCallbackTimeout = 30 * time.Second
+	callbackRetryInterval  = 2 * time.Second
+)
+
+// callbackCommandError is a callback command that failed, with what it wrote to stderr
+type callbackCommandError struct {
+	name   string
+	err    error
+	stderr string
+}
+
+func (e callbackCommandError) Error() string {
+	if e.stderr == "" {
+		return fmt.Sprintf("Callback %s failed: %s", e.name, e.err)
+	}
+	return fmt.Sprintf("Callback %s failed: %s: %s", e.name, e.err, e.stderr)
+}
+
+// run gives input to a callback, on stdin or as the request body, and returns its stdout or response body.
+// Each attempt is stopped after the callback's timeout, and failed attempts are retried
+// up to the callback's number of retries.
+func (c *Callbacks) run(name string, callback *config.CallbackCommand, input []byte) (output []byte, err error) {
+	logger := c.logger.Session("callbacks.run", lager.Data{"callback": name})
+	timeout := defaultCallbackTimeout
+	if callback.TimeoutSeconds > 0 {
+		timeout = time.Duration(callback.TimeoutSeconds) * time.Second
+	}
+
+	for attempt := 0; attempt <= callback.Retries; attempt++ {
+		if attempt > 0 {
+			logger.Info("retry", lager.Data{"attempt": attempt, "error": err.Error()})
+			time.Sleep(callbackRetryInterval)
+		}
+		retry := true
+		if callback.HTTP != nil {
+			output, retry, err = callHTTP(callback.HTTP, input, timeout)
+		} else {
+			output, err = runCommand(name, callback, input, timeout)
+		}
+		if err == nil || !retry {
+			return
+		}
+	}
+	return
+}
+
+// runCommand runs a callback command, killing it if it has not finished within timeout
+func runCommand(name string, callback *config.CallbackCommand, input []byte, timeout time.Duration) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(callback.Command, callback.Arguments...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, callbackCommandError{name: name, err: err}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, callbackCommandError{name: name, err: err, stderr: strings.TrimSpace(stderr.String())}
+	}
+	return stdout.Bytes(), nil
+}
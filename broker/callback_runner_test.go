@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/testutil"
+)
+
+func TestCallbacks_run_Timeout(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCallbacks_run_Timeout"
+	logger := testutil.NewTestLogger(testPrefix, t)
+	callbacks := NewCallbacks(config.Callbacks{}, logger)
+
+	started := time.Now()
+	_, err := callbacks.run("test", &config.CallbackCommand{Command: "sleep", Arguments: []string{"10"}, TimeoutSeconds: 1}, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected callback to time out, got %v", err)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Fatalf("Expected callback to be stopped after its timeout")
+	}
+}
+
+func TestCallbacks_run_StderrAndRetries(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCallbacks_run_StderrAndRetries"
+	logger := testutil.NewTestLogger(testPrefix, t)
+	callbacks := NewCallbacks(config.Callbacks{}, logger)
+
+	testDir, err := ioutil.TempDir("", testPrefix)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	attemptsFile := fmt.Sprintf("%s/attempts", testDir)
+
+	// fails on the first two attempts, then echoes its input
+	script := fmt.Sprintf(`echo x >> %s; if [ $(wc -l < %s) -lt 3 ]; then echo "not yet" >&2; exit 1; fi; cat`, attemptsFile, attemptsFile)
+	callback := &config.CallbackCommand{Command: "sh", Arguments: []string{"-c", script}}
+	_, err = callbacks.run("test", callback, []byte("input"))
+	if err == nil || !strings.Contains(err.Error(), "not yet") {
+		t.Fatalf("Expected error to include stderr, got %v", err)
+	}
+
+	callback.Retries = 1
+	output, err := callbacks.run("test", callback, []byte("input"))
+	if err != nil {
+		t.Fatalf("Expected retried callback to succeed, got %s", err)
+	}
+	if string(output) != "input" {
+		t.Fatalf("Expected stdout 'input', got '%s'", output)
+	}
+}
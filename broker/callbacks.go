@@ -1,160 +1,130 @@
 package broker
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
-	"sync"
+	"net/http"
 
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/clusterdata"
 	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/encryption"
 	"github.com/pivotal-golang/lager"
 )
 
+// Callbacks keep the recreation data of service instances, using the clusterdata callbacks
+// if they are configured and otherwise the broker's own clusterdata store, and fire lifecycle events
 type Callbacks struct {
-	backupCallback             *config.CallbackCommand
-	restoreCallback            *config.CallbackCommand
-	findByNameCallback         *config.CallbackCommand
-	copyDatabaseBackupCallback *config.CallbackCommand
-	logger                     lager.Logger
+	backupCallback     *config.CallbackCommand
+	restoreCallback    *config.CallbackCommand
+	findByNameCallback *config.CallbackCommand
+	eventCallbacks     map[string]*config.CallbackCommand
+	clusterDataStore   *clusterdata.Store
+	keyring            *encryption.Keyring
+	logger             lager.Logger
 }
 
 func NewCallbacks(config config.Callbacks, logger lager.Logger) *Callbacks {
 	callbacks := &Callbacks{
-		backupCallback:             config.ClusterDataBackup,
-		restoreCallback:            config.ClusterDataRestore,
-		findByNameCallback:         config.ClusterDataFindByName,
-		copyDatabaseBackupCallback: config.BackupsCopy,
-		logger: logger,
+		backupCallback:     config.ClusterDataBackup,
+		restoreCallback:    config.ClusterDataRestore,
+		findByNameCallback: config.ClusterDataFindByName,
+		eventCallbacks:     config.Events,
+		logger:             logger,
+	}
+	for event := range config.Events {
+		if !isLifecycleEvent(event) {
+			logger.Info("callbacks.events.unknown", lager.Data{"event": event, "events": structs.LifecycleEvents})
+		}
 	}
 	return callbacks
 }
 
 func (c *Callbacks) Configured() bool {
-	return c.backupCallback != nil && c.restoreCallback != nil
+	return (c.backupCallback != nil && c.restoreCallback != nil) || c.clusterDataStore != nil
 }
 
-func (c *Callbacks) WriteRecreationData(clusterData *structs.ClusterRecreationData) {
+// WriteRecreationData backs up the details needed to recreate a service instance
+func (c *Callbacks) WriteRecreationData(clusterData *structs.ClusterRecreationData) error {
 	callback := c.backupCallback
 	logger := c.logger
 
-	if callback == nil {
+	if callback == nil && c.clusterDataStore == nil {
 		logger.Info("callbacks.write-data.noop")
-		return
+		return nil
 	}
 
-	data, err := json.Marshal(clusterData)
+	encrypted, err := c.keyring.EncryptRecreationData(*clusterData)
 	if err != nil {
-		logger.Error("callbacks.write-data.data-marshal", err)
-		return
+		logger.Error("callbacks.write-data.encrypt", err)
+		return err
 	}
+	clusterData = &encrypted
 
-	cmd := exec.Command(callback.Command, callback.Arguments...)
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		logger.Error("callbacks.write-data.stdin-pipe", err)
-		return
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		logger.Error("callbacks.write-data.stdout-pipe", err)
-		return
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		logger.Error("callbacks.write-data.stderr-pipe", err)
-		return
+	if callback == nil {
+		if err = c.clusterDataStore.Write(clusterData); err != nil {
+			logger.Error("callbacks.write-data.store-error", err)
+			return err
+		}
+		logger.Info("callbacks.write-data.done", lager.Data{"store": c.clusterDataStore.URI()})
+		return nil
 	}
-	err = cmd.Start()
+
+	data, err := json.Marshal(clusterData)
 	if err != nil {
-		logger.Error("callbacks.write-data.start", err)
-		return
+		logger.Error("callbacks.write-data.data-marshal", err)
+		return err
 	}
-	var wg sync.WaitGroup
-	wg.Add(3)
-	go func() {
-		defer wg.Done()
-		defer stdin.Close()
-		io.Copy(stdin, bytes.NewBufferString(string(data)))
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(os.Stdout, stdout)
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(os.Stderr, stderr)
-	}()
-	wg.Wait()
-	err = cmd.Wait()
-	if err != nil {
-		logger.Error("callbacks.write-data.cmd", err)
-		return
+
+	if _, err = c.run("clusterdata_backup", callback, data); err != nil {
+		logger.Error("callbacks.write-data.error", err)
+		return err
 	}
 	logger.Info("callbacks.write-data.done")
+	return nil
 }
 
 func (c *Callbacks) RestoreRecreationData(instanceID structs.ClusterID) (*structs.ClusterRecreationData, error) {
 	callback := c.restoreCallback
 	logger := c.logger
 
+	if callback == nil && c.clusterDataStore != nil {
+		clusterData, err := c.clusterDataStore.Restore(instanceID)
+		if err != nil {
+			logger.Error("callbacks.restore.store-error", err)
+			return nil, err
+		}
+		logger.Info("callbacks.restore.done", lager.Data{"store": c.clusterDataStore.URI()})
+		return c.decrypt(clusterData)
+	}
 	if callback == nil {
 		err := fmt.Errorf("Broker not configured to support service recreation")
 		logger.Error("callbacks.restore.callback-missing", err, lager.Data{"missing-config": "callbacks.clusterdata_restore"})
 		return nil, err
 	}
 
-	cmd := exec.Command(callback.Command, callback.Arguments...)
-	stdin, err := cmd.StdinPipe()
+	output, err := c.run("clusterdata_restore", callback, []byte(instanceID))
 	if err != nil {
-		logger.Error("callbacks.restore.stdin-pipe", err)
-		return nil, err
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		logger.Error("callbacks.restore.stdout-pipe", err)
-		return nil, err
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		logger.Error("callbacks.restore.stderr-pipe", err)
-		return nil, err
-	}
-	err = cmd.Start()
-	if err != nil {
-		logger.Error("callbacks.restore.start", err)
+		logger.Error("callbacks.restore.error", err)
 		return nil, err
 	}
-	var wg sync.WaitGroup
-	wg.Add(3)
-	go func() {
-		defer wg.Done()
-		defer stdin.Close()
-		io.Copy(stdin, bytes.NewBufferString(string(instanceID)))
-	}()
 	clusterData := &structs.ClusterRecreationData{}
-	go func() {
-		defer wg.Done()
-		if err := json.NewDecoder(stdout).Decode(&clusterData); err != nil {
-			logger.Error("callbacks.restore.marshal-error", err)
-			return
-		}
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(os.Stderr, stderr)
-	}()
-	wg.Wait()
-	err = cmd.Wait()
-	if err != nil {
-		logger.Error("callbacks.restore.error", err)
-		return nil, err
+	if err = json.Unmarshal(output, clusterData); err != nil {
+		logger.Error("callbacks.restore.marshal-error", err)
+		return nil, fmt.Errorf("Callback clusterdata_restore returned invalid JSON: %s", err)
 	}
-	logger.Info("callbacks.restore.done", lager.Data{"clusterData": clusterData})
-	return clusterData, nil
+	logger.Info("callbacks.restore.done", lager.Data{"instance-id": clusterData.InstanceID})
+	return c.decrypt(clusterData)
+}
+
+// clusterDataNotFoundError is returned when the find-by-name callback knows of no such service instance
+type clusterDataNotFoundError struct {
+	spaceGUID string
+	name      string
+}
+
+func (e clusterDataNotFoundError) Error() string {
+	return fmt.Sprintf("Failed to fetch backup clusterdata for %s / %s", e.spaceGUID, e.name)
 }
 
 // Input: {"space_guid": "GUID", "name": "NAME"}
@@ -163,6 +133,18 @@ func (c *Callbacks) ClusterDataFindServiceInstanceByName(spaceGUID, name string)
 	callback := c.findByNameCallback
 	logger := c.logger
 
+	if callback == nil && c.clusterDataStore != nil {
+		clusterData, err := c.clusterDataStore.FindByName(spaceGUID, name)
+		if err != nil {
+			logger.Error("callbacks.find-by-name.store-error", err)
+			return nil, err
+		}
+		if clusterData == nil {
+			return nil, clusterDataNotFoundError{spaceGUID: spaceGUID, name: name}
+		}
+		logger.Info("callbacks.find-by-name.done", lager.Data{"store": c.clusterDataStore.URI(), "instance-id": clusterData.InstanceID})
+		return c.decrypt(clusterData)
+	}
 	if callback == nil {
 		err := fmt.Errorf("Broker not configured to support discovery of existing clusterdata backups by name")
 		logger.Error("callbacks.find-by-name.callback-missing", err, lager.Data{"missing-config": "callbacks.clusterdata_find_by_name"})
@@ -174,127 +156,89 @@ func (c *Callbacks) ClusterDataFindServiceInstanceByName(spaceGUID, name string)
 		"name":       name,
 	})
 	if err != nil {
-		logger.Error("callbacks.write-data.data-marshal", err)
+		logger.Error("callbacks.find-by-name.data-marshal", err)
 		return nil, err
 	}
 
-	cmd := exec.Command(callback.Command, callback.Arguments...)
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		logger.Error("callbacks.find-by-name.stdin-pipe", err)
-		return nil, err
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		logger.Error("callbacks.find-by-name.stdout-pipe", err)
-		return nil, err
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		logger.Error("callbacks.find-by-name.stderr-pipe", err)
-		return nil, err
+	output, err := c.run("clusterdata_find_by_name", callback, data)
+	if httpErr, ok := err.(callbackHTTPError); ok && httpErr.statusCode == http.StatusNotFound {
+		return nil, clusterDataNotFoundError{spaceGUID: spaceGUID, name: name}
 	}
-	err = cmd.Start()
 	if err != nil {
-		logger.Error("callbacks.find-by-name.start", err)
+		logger.Error("callbacks.find-by-name.error", err)
 		return nil, err
 	}
-	var wg sync.WaitGroup
-	wg.Add(3)
-	go func() {
-		defer wg.Done()
-		defer stdin.Close()
-		io.Copy(stdin, bytes.NewBufferString(string(data)))
-	}()
 	clusterData := &structs.ClusterRecreationData{}
-	go func() {
-		defer wg.Done()
-		if err = json.NewDecoder(stdout).Decode(&clusterData); err != nil {
-			logger.Error("callbacks.find-by-name.marshal-error", err)
-			return
-		}
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(os.Stderr, stderr)
-	}()
-	wg.Wait()
-	err = cmd.Wait()
-	if err != nil {
-		logger.Error("callbacks.find-by-name.error", err)
-		return nil, err
+	if err = json.Unmarshal(output, clusterData); err != nil {
+		logger.Error("callbacks.find-by-name.marshal-error", err)
+		return nil, fmt.Errorf("Callback clusterdata_find_by_name returned invalid JSON: %s", err)
 	}
 	if clusterData.InstanceID == "" {
-		return nil, fmt.Errorf("Failed to fetch backup clusterdata for %s / %s", spaceGUID, name)
+		return nil, clusterDataNotFoundError{spaceGUID: spaceGUID, name: name}
 	}
-	logger.Info("callbacks.find-by-name.done", lager.Data{"clusterData": clusterData})
-	return clusterData, nil
+	logger.Info("callbacks.find-by-name.done", lager.Data{"instance-id": clusterData.InstanceID})
+	return c.decrypt(clusterData)
 }
 
-// Input: {"space_guid": "GUID", "name": "NAME"}
-// Output: {"instance_id":"71c27bbe-...", ...}
-func (c *Callbacks) CopyDatabaseBackup(fromDatabaseBackupURI, toDatabaseBackupURI string, callerlogger lager.Logger) (err error) {
-	callback := c.copyDatabaseBackupCallback
-	logger := callerlogger.Session("callbacks.copy-database-backup")
-	logger.Info("start", lager.Data{"from-uri": fromDatabaseBackupURI, "to-uri": toDatabaseBackupURI})
-	defer logger.Info("done")
+// CanListRecreationData is true if recreation data is kept by the broker's clusterdata store;
+// data backed up by the clusterdata_backup callback cannot be listed
+func (c *Callbacks) CanListRecreationData() bool {
+	return c.backupCallback == nil && c.clusterDataStore != nil
+}
 
-	if callback == nil {
-		err = fmt.Errorf("Broker not configured to copy/clone database backups")
-		logger.Error("callback-missing", err, lager.Data{"missing-config": "callbacks.clusterdata_find_by_name"})
-		return
+// ListRecreationData returns the recreation data of every service instance, deleted or not
+func (c *Callbacks) ListRecreationData() ([]*structs.ClusterRecreationData, error) {
+	if !c.CanListRecreationData() {
+		return nil, fmt.Errorf("Broker not configured to list clusterdata backups; requires clusterdata.base_uri without a clusterdata_backup callback")
 	}
-
-	data, err := json.Marshal(map[string]string{
-		"from_uri": fromDatabaseBackupURI,
-		"to_uri":   toDatabaseBackupURI,
-	})
+	all, err := c.clusterDataStore.List()
 	if err != nil {
-		logger.Error("data-marshal", err)
-		return
+		return nil, err
 	}
+	for i, clusterData := range all {
+		if all[i], err = c.decrypt(clusterData); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
 
-	cmd := exec.Command(callback.Command, callback.Arguments...)
-	stdin, err := cmd.StdinPipe()
+// decrypt returns recreation data with the credentials that were encrypted when it was written
+func (c *Callbacks) decrypt(clusterData *structs.ClusterRecreationData) (*structs.ClusterRecreationData, error) {
+	decrypted, err := c.keyring.DecryptRecreationData(*clusterData)
 	if err != nil {
-		logger.Error("stdin-pipe", err)
-		return
+		c.logger.Error("callbacks.decrypt", err, lager.Data{"instance-id": clusterData.InstanceID})
+		return nil, fmt.Errorf("Cannot decrypt clusterdata backup of %s: %s", clusterData.InstanceID, err)
 	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		logger.Error("stdout-pipe", err)
-		return
+	return &decrypted, nil
+}
+
+func isLifecycleEvent(event string) bool {
+	for _, known := range structs.LifecycleEvents {
+		if event == known {
+			return true
+		}
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		logger.Error("stderr-pipe", err)
-		return
+	return false
+}
+
+// FireEvent sends a lifecycle event to the callback configured for it, if any
+func (c *Callbacks) FireEvent(event structs.LifecycleEvent) error {
+	callback := c.eventCallbacks[event.Event]
+	if callback == nil {
+		return nil
 	}
-	err = cmd.Start()
+	logger := c.logger.Session("callbacks.event", lager.Data{"event": event.Event, "instance-id": event.InstanceID})
+
+	data, err := json.Marshal(event)
 	if err != nil {
-		logger.Error("start", err)
-		return
+		logger.Error("data-marshal", err)
+		return err
 	}
-	var wg sync.WaitGroup
-	wg.Add(3)
-	go func() {
-		defer wg.Done()
-		defer stdin.Close()
-		io.Copy(stdin, bytes.NewBufferString(string(data)))
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(os.Stdout, stdout)
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(os.Stderr, stderr)
-	}()
-	wg.Wait()
-	err = cmd.Wait()
-	if err != nil {
+	if _, err = c.run(fmt.Sprintf("events.%s", event.Event), callback, data); err != nil {
 		logger.Error("error", err)
-		return
+		return err
 	}
+	logger.Info("done")
 	return nil
 }
@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+// callbackHTTPError is an unsuccessful response from a callback web service
+type callbackHTTPError struct {
+	url        string
+	statusCode int
+	body       string
+}
+
+func (e callbackHTTPError) Error() string {
+	return fmt.Sprintf("Callback %s returned %d: %s", e.url, e.statusCode, e.body)
+}
+
+// callHTTP sends the callback's input to a web service and returns the response body.
+// Connection errors and 5xx responses may succeed if retried.
+func callHTTP(callback *config.CallbackHTTP, input []byte, timeout time.Duration) (output []byte, retry bool, err error) {
+	client, err := callbackHTTPClient(callback, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	method := callback.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequest(method, callback.URL, bytes.NewReader(input))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range callback.Headers {
+		req.Header.Set(name, value)
+	}
+	if callback.Username != "" {
+		req.SetBasicAuth(callback.Username, callback.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	output, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode/100 != 2 {
+		err = callbackHTTPError{url: callback.URL, statusCode: resp.StatusCode, body: strings.TrimSpace(string(output))}
+		return nil, resp.StatusCode >= 500, err
+	}
+	return output, false, nil
+}
+
+func callbackHTTPClient(callback *config.CallbackHTTP, timeout time.Duration) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: callback.SkipSslValidation}
+	if callback.CACert != "" {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(callback.CACert)) {
+			return nil, fmt.Errorf("Callback %s has an invalid ca_cert", callback.URL)
+		}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}, nil
+}
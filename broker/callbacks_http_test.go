@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/testutil"
+)
+
+func TestCallbacks_HTTP_RestoreRecreationData(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCallbacks_HTTP_RestoreRecreationData"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	recreationData := &structs.ClusterRecreationData{InstanceID: "instance-id", PlanID: "PlanID", AllocatedPort: 1234}
+	var mutex sync.Mutex
+	attempts := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		username, password, _ := r.BasicAuth()
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "PUT" || username != "user" || password != "secret" || r.Header.Get("X-Team") != "platform" || string(body) != "instance-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// the first attempt fails, to be retried
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"instance_id": "instance-id", "plan_id": "PlanID", "allocated_port": 1234}`))
+	}))
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+
+	callbacks := NewCallbacks(config.Callbacks{
+		ClusterDataRestore: &config.CallbackCommand{
+			HTTP: &config.CallbackHTTP{
+				URL:      server.URL,
+				Method:   "PUT",
+				Headers:  map[string]string{"X-Team": "platform"},
+				Username: "user",
+				Password: "secret",
+				CACert:   string(caCert),
+			},
+			Retries: 1,
+		},
+	}, logger)
+	restoredData, err := callbacks.RestoreRecreationData("instance-id")
+	if err != nil {
+		t.Fatalf("RestoreRecreationData failed %s", err)
+	}
+	if !reflect.DeepEqual(recreationData, restoredData) {
+		t.Fatalf("Retrieved Data doesn't equal original. %v != %v", restoredData, recreationData)
+	}
+	if attempts != 2 {
+		t.Fatalf("Expected a failed request to be retried once, got %d attempts", attempts)
+	}
+}
+
+func TestCallbacks_HTTP_FindByNameNotFound(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCallbacks_HTTP_FindByNameNotFound"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	callbacks := NewCallbacks(config.Callbacks{
+		ClusterDataFindByName: &config.CallbackCommand{HTTP: &config.CallbackHTTP{URL: server.URL}, Retries: 2},
+	}, logger)
+	_, err := callbacks.ClusterDataFindServiceInstanceByName("space-guid", "test-db")
+	if _, ok := err.(clusterDataNotFoundError); !ok {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("Expected a 404 response not to be retried, got %d attempts", attempts)
+	}
+}
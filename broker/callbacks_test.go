@@ -6,10 +6,14 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/clusterdata"
 	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/encryption"
 	"github.com/dingotiles/dingo-postgresql-broker/testutil"
 	"github.com/pborman/uuid"
 )
@@ -145,3 +149,175 @@ func TestCallbacks_RestoreRecreationData(t *testing.T) {
 		t.Fatalf("InstanceID %s was not passed via stdin", instanceID)
 	}
 }
+
+func TestCallbacks_FireEvent(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCallbacks_FireEvent"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	testDir, err := ioutil.TempDir("", testPrefix)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	fileName := fmt.Sprintf("%s/%s", testDir, testPrefix)
+
+	cfg := config.Callbacks{
+		Events: map[string]*config.CallbackCommand{
+			structs.EventSchedulingFailed: &config.CallbackCommand{
+				Command:   "tee",
+				Arguments: []string{fileName},
+			},
+		},
+	}
+	callbacks := NewCallbacks(cfg, logger)
+
+	cluster := structs.ClusterState{InstanceID: "instance-id", PlanID: "PlanID", SpaceGUID: "SpaceGUID"}
+	// events without a callback are ignored
+	if err = callbacks.FireEvent(structs.NewLifecycleEvent(structs.EventProvisioned, "provision", cluster, nil)); err != nil {
+		t.Fatalf("Unconfigured event should be ignored, got %s", err)
+	}
+	if _, err = os.Stat(fileName); !os.IsNotExist(err) {
+		t.Fatalf("Unconfigured event should not run another event's callback")
+	}
+
+	event := structs.NewLifecycleEvent(structs.EventSchedulingFailed, "provision", cluster, fmt.Errorf("no cells"))
+	if err = callbacks.FireEvent(event); err != nil {
+		t.Fatalf("FireEvent failed: %s", err)
+	}
+	rawData, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Could not open file %s, Err: %s", fileName, err)
+	}
+	written := map[string]interface{}{}
+	if err = json.Unmarshal(rawData, &written); err != nil {
+		t.Fatalf("Event is not JSON: %s", err)
+	}
+	expected := map[string]interface{}{
+		"schema_version":    float64(1),
+		"event":             "scheduling-failed",
+		"status":            "failed",
+		"timestamp":         event.Timestamp.Format(time.RFC3339Nano),
+		"operation":         "provision",
+		"instance_id":       "instance-id",
+		"service_id":        "",
+		"plan_id":           "PlanID",
+		"organization_guid": "",
+		"space_guid":        "SpaceGUID",
+		"error":             "no cells",
+	}
+	if !reflect.DeepEqual(expected, written) {
+		t.Fatalf("Event %v should be %v", written, expected)
+	}
+}
+
+func TestCallbacks_ClusterDataStore(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCallbacks_ClusterDataStore"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	testDir, err := ioutil.TempDir("", testPrefix)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	callbacks := NewCallbacks(config.Callbacks{}, logger)
+	callbacks.clusterDataStore, err = clusterdata.NewStore(config.ClusterData{BaseURI: "file://" + testDir})
+	if err != nil {
+		t.Fatalf("NewStore failed: %s", err)
+	}
+	if !callbacks.Configured() || !callbacks.CanListRecreationData() {
+		t.Fatalf("Callbacks should use the clusterdata store when no callbacks are configured")
+	}
+
+	recreationData := &structs.ClusterRecreationData{
+		InstanceID:          "instance-id",
+		SpaceGUID:           "SpaceGUID",
+		ServiceInstanceName: "test-db",
+	}
+	if err = callbacks.WriteRecreationData(recreationData); err != nil {
+		t.Fatalf("WriteRecreationData failed: %s", err)
+	}
+	restoredData, err := callbacks.RestoreRecreationData("instance-id")
+	if err != nil || !reflect.DeepEqual(recreationData, restoredData) {
+		t.Fatalf("Retrieved Data doesn't equal original. %v != %v (%v)", restoredData, recreationData, err)
+	}
+	foundData, err := callbacks.ClusterDataFindServiceInstanceByName("SpaceGUID", "test-db")
+	if err != nil || !reflect.DeepEqual(recreationData, foundData) {
+		t.Fatalf("Found Data doesn't equal original. %v != %v (%v)", foundData, recreationData, err)
+	}
+	if _, err = callbacks.ClusterDataFindServiceInstanceByName("SpaceGUID", "other-db"); err == nil {
+		t.Fatalf("Expected not to find other-db")
+	} else if _, ok := err.(clusterDataNotFoundError); !ok {
+		t.Fatalf("Expected a not found error, got %s", err)
+	}
+
+	// a configured callback overrides the store
+	fileName := fmt.Sprintf("%s/%s", testDir, testPrefix)
+	callbacks.backupCallback = &config.CallbackCommand{Command: "tee", Arguments: []string{fileName}}
+	if err = callbacks.WriteRecreationData(&structs.ClusterRecreationData{InstanceID: "other-id"}); err != nil {
+		t.Fatalf("WriteRecreationData failed: %s", err)
+	}
+	if _, err = os.Stat(fileName); err != nil {
+		t.Fatalf("Expected clusterdata_backup callback to be run: %s", err)
+	}
+	if _, err = callbacks.clusterDataStore.Restore("other-id"); err == nil {
+		t.Fatalf("Expected clusterdata_backup callback to be used instead of the store")
+	}
+	if callbacks.CanListRecreationData() {
+		t.Fatalf("Data backed up by a callback cannot be listed")
+	}
+}
+
+func TestCallbacks_EncryptsRecreationData(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCallbacks_EncryptsRecreationData"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	testDir, err := ioutil.TempDir("", testPrefix)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	fileName := fmt.Sprintf("%s/%s", testDir, testPrefix)
+
+	callbacks := NewCallbacks(config.Callbacks{
+		ClusterDataBackup:  &config.CallbackCommand{Command: "tee", Arguments: []string{fileName}},
+		ClusterDataRestore: &config.CallbackCommand{Command: "cat", Arguments: []string{fileName}},
+	}, logger)
+	callbacks.keyring, err = encryption.NewKeyring(config.Encryption{
+		ActiveKey: "k1",
+		Keys:      map[string]string{"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="},
+	})
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %s", err)
+	}
+
+	recreationData := &structs.ClusterRecreationData{
+		InstanceID:       "instance-id",
+		AdminCredentials: structs.PostgresCredentials{Username: "pgadmin", Password: "adminpw"},
+		AppCredentials:   structs.PostgresCredentials{Username: "appuser", Password: "apppw"},
+	}
+	if err = callbacks.WriteRecreationData(recreationData); err != nil {
+		t.Fatalf("WriteRecreationData failed: %s", err)
+	}
+	rawData, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Could not open file %s, Err: %s", fileName, err)
+	}
+	if strings.Contains(string(rawData), "adminpw") || strings.Contains(string(rawData), "apppw") {
+		t.Fatalf("Expected passwords to be encrypted, got %s", rawData)
+	}
+	if recreationData.AdminCredentials.Password != "adminpw" {
+		t.Fatalf("Expected caller's recreation data to be unchanged")
+	}
+
+	restoredData, err := callbacks.RestoreRecreationData("instance-id")
+	if err != nil || !reflect.DeepEqual(recreationData, restoredData) {
+		t.Fatalf("Restored Data doesn't equal original. %v != %v (%v)", restoredData, recreationData, err)
+	}
+}
@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/certificates"
+	"github.com/dingotiles/dingo-postgresql-broker/state"
+	"github.com/pivotal-golang/lager"
+)
+
+// sslMode is the sslmode given to bindings of clusters that accept TLS connections
+const sslMode = "verify-full"
+
+const (
+	// clusterTLSRenewBefore is how long before it expires that a cluster's certificate
+	// is replaced by the next update of the service instance
+	clusterTLSRenewBefore         = 30 * 24 * time.Hour
+	clusterTLSExpirySweepInterval = 24 * time.Hour
+)
+
+// issueClusterTLS gives a cluster of a TLS plan a server certificate, valid for the
+// host that bindings connect to. Clusters of other plans are left unchanged.
+func (bkr *Broker) issueClusterTLS(cluster *structs.ClusterState) error {
+	if bkr.certificateAuthority == nil || !bkr.postgresql.TLS.EnabledForPlan(cluster.PlanID) {
+		return nil
+	}
+	certificate, privateKey, err := bkr.certificateAuthority.Issue(string(cluster.InstanceID), []string{bkr.config.BindHost})
+	if err != nil {
+		return err
+	}
+	cluster.TLS = &structs.ClusterTLS{
+		Certificate: certificate,
+		PrivateKey:  privateKey,
+		CACert:      bkr.certificateAuthority.CACert(),
+	}
+	return nil
+}
+
+// clusterTLSNeedsIssuing is true if a cluster of a TLS plan has no certificate, such as after
+// being updated to a TLS plan, or if its certificate expires within clusterTLSRenewBefore
+func (bkr *Broker) clusterTLSNeedsIssuing(cluster structs.ClusterState) bool {
+	if bkr.certificateAuthority == nil || !bkr.postgresql.TLS.EnabledForPlan(cluster.PlanID) {
+		return false
+	}
+	if cluster.TLS == nil {
+		return true
+	}
+	expiry, err := certificates.Expiry(cluster.TLS.Certificate)
+	return err != nil || time.Now().Add(clusterTLSRenewBefore).After(expiry)
+}
+
+// warnOfExpiringClusterTLS periodically logs the clusters whose certificates expire within
+// clusterTLSRenewBefore. Updating the service instance replaces its certificate.
+func (bkr *Broker) warnOfExpiringClusterTLS() {
+	for range time.Tick(clusterTLSExpirySweepInterval) {
+		logger := bkr.logger.Session("warn-of-expiring-cluster-tls")
+		clusters, err := bkr.state.LoadAllRunningClusters()
+		if err != nil {
+			logger.Error("load-clusters", err)
+			continue
+		}
+		for _, cluster := range clusters {
+			if cluster.TLS == nil {
+				continue
+			}
+			data := lager.Data{"instance-id": cluster.InstanceID}
+			expiry, err := certificates.Expiry(cluster.TLS.Certificate)
+			if err != nil {
+				logger.Error("expiry", err, data)
+				continue
+			}
+			if time.Now().Add(clusterTLSRenewBefore).After(expiry) {
+				data["expires-at"] = expiry
+				logger.Error("expiring", fmt.Errorf("Broker: the TLS certificate of %s expires at %s; update the service instance to replace it",
+					cluster.InstanceID, expiry.Format(time.RFC3339)), data)
+			}
+		}
+	}
+}
+
+// renewClusterTLS issues a new certificate to a cluster that needs one, and returns true if it did
+func (bkr *Broker) renewClusterTLS(clusterModel *state.ClusterModel) (bool, error) {
+	cluster := clusterModel.ClusterState()
+	if !bkr.clusterTLSNeedsIssuing(cluster) {
+		return false, nil
+	}
+	if err := bkr.issueClusterTLS(&cluster); err != nil {
+		return false, err
+	}
+	if err := clusterModel.SetTLS(cluster.TLS); err != nil {
+		return false, err
+	}
+	return true, nil
+}
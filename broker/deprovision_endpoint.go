@@ -33,17 +33,46 @@ func (bkr *Broker) deprovision(instanceID structs.ClusterID, details brokerapi.D
 	err = bkr.scheduler.StopCluster(clusterModel)
 	if err != nil {
 		logger.Error("stop-cluster", err)
+		bkr.fireEvent(structs.NewLifecycleEvent(structs.EventDeprovisioned, "deprovision", clusterModel.ClusterState(), err))
 		return false, err
 	}
 
+	// the tombstone is saved before anything is removed, so that a service instance
+	// that cannot be tombstoned keeps its state and ports
+	tombstoned := bkr.config.TombstoneRetentionHours > 0
+	if tombstoned {
+		if err = bkr.tombstoneCluster(clusterModel, logger); err != nil {
+			logger.Error("tombstone", err)
+			bkr.fireEvent(structs.NewLifecycleEvent(structs.EventDeprovisioned, "deprovision", clusterModel.ClusterState(), err))
+			return false, err
+		}
+	}
+
+	if previous := clusterModel.PreviousCluster(); previous != nil {
+		bkr.removeReplacedCluster(clusterModel, *previous, logger)
+	}
+	if clusterModel.PatroniScope() != clusterModel.InstanceID() {
+		// remove the keys of the Patroni cluster created by a major version upgrade
+		bkr.state.DeleteCluster(clusterModel.PatroniScope())
+	}
 	bkr.state.DeleteCluster(clusterModel.InstanceID())
-	bkr.router.RemoveClusterAssignment(clusterModel.InstanceID())
+	if tombstoned {
+		// routing stops, but the ports stay reserved by the tombstone
+		bkr.router.UnassignCluster(clusterModel.InstanceID())
+	} else {
+		bkr.router.RemoveClusterAssignment(clusterModel.InstanceID())
+	}
+	bkr.fireEvent(structs.NewLifecycleEvent(structs.EventDeprovisioned, "deprovision", clusterModel.ClusterState(), nil))
 
 	return false, nil
 }
 
 func (bkr *Broker) assertDeprovisionPrecondition(instanceID structs.ClusterID, details brokerapi.DeprovisionDetails) error {
-	if bkr.state.ClusterExists(instanceID) == false {
+	exists, err := bkr.state.ClusterExists(instanceID)
+	if err != nil {
+		return err
+	}
+	if exists == false {
 		return fmt.Errorf("Service instance %s doesn't exist", instanceID)
 	}
 
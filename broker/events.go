@@ -0,0 +1,29 @@
+package broker
+
+import (
+	"errors"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/state"
+)
+
+// fireEvent sends a lifecycle event to its callback in the background, so that a slow
+// or failing callback does not hold up the operation it describes
+func (bkr *Broker) fireEvent(event structs.LifecycleEvent) {
+	go bkr.callbacks.FireEvent(event)
+}
+
+// fireScheduledEvent reports how an asynchronous operation ended, as recorded by its
+// scheduling info for last_operation. An operation that did not complete also fires
+// "scheduling-failed". Operations without an event of their own pass an empty event.
+func (bkr *Broker) fireScheduledEvent(event, operation string, clusterModel *state.ClusterModel) {
+	cluster := clusterModel.ClusterState()
+	var err error
+	if cluster.SchedulingInfo.Status != structs.SchedulingStatusSuccess {
+		err = errors.New(cluster.SchedulingInfo.LastMessage)
+		bkr.fireEvent(structs.NewLifecycleEvent(structs.EventSchedulingFailed, operation, cluster, err))
+	}
+	if event != "" {
+		bkr.fireEvent(structs.NewLifecycleEvent(event, operation, cluster, err))
+	}
+}
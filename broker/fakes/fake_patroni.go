@@ -3,6 +3,7 @@ package fakes
 
 import (
 	"sync"
+	"time"
 
 	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
@@ -53,6 +54,95 @@ type FakePatroni struct {
 	failoverFromReturns struct {
 		result1 error
 	}
+	SwitchoverStub        func(instanceID structs.ClusterID, candidateID string, scheduledAt time.Time) error
+	switchoverMutex       sync.RWMutex
+	switchoverArgsForCall []struct {
+		instanceID  structs.ClusterID
+		candidateID string
+		scheduledAt time.Time
+	}
+	switchoverReturns struct {
+		result1 error
+	}
+	RestartStub        func(instanceID structs.ClusterID, memberID string) error
+	restartMutex       sync.RWMutex
+	restartArgsForCall []struct {
+		instanceID structs.ClusterID
+		memberID   string
+	}
+	restartReturns struct {
+		result1 error
+	}
+	ReinitializeStub        func(instanceID structs.ClusterID, memberID string) error
+	reinitializeMutex       sync.RWMutex
+	reinitializeArgsForCall []struct {
+		instanceID structs.ClusterID
+		memberID   string
+	}
+	reinitializeReturns struct {
+		result1 error
+	}
+	PauseStub        func(structs.ClusterID) error
+	pauseMutex       sync.RWMutex
+	pauseArgsForCall []struct {
+		arg1 structs.ClusterID
+	}
+	pauseReturns struct {
+		result1 error
+	}
+	ResumeStub        func(structs.ClusterID) error
+	resumeMutex       sync.RWMutex
+	resumeArgsForCall []struct {
+		arg1 structs.ClusterID
+	}
+	resumeReturns struct {
+		result1 error
+	}
+	ClusterStatusStub        func(structs.ClusterID) ([]structs.PatroniMemberStatus, error)
+	clusterStatusMutex       sync.RWMutex
+	clusterStatusArgsForCall []struct {
+		arg1 structs.ClusterID
+	}
+	clusterStatusReturns struct {
+		result1 []structs.PatroniMemberStatus
+		result2 error
+	}
+	ReplicationLagStub        func(structs.ClusterID) (map[string]int64, error)
+	replicationLagMutex       sync.RWMutex
+	replicationLagArgsForCall []struct {
+		arg1 structs.ClusterID
+	}
+	replicationLagReturns struct {
+		result1 map[string]int64
+		result2 error
+	}
+	SetSynchronousModeStub        func(instanceID structs.ClusterID, enabled bool) error
+	setSynchronousModeMutex       sync.RWMutex
+	setSynchronousModeArgsForCall []struct {
+		instanceID structs.ClusterID
+		enabled    bool
+	}
+	setSynchronousModeReturns struct {
+		result1 error
+	}
+	PatchPostgresqlParametersStub        func(instanceID structs.ClusterID, changes map[string]interface{}) error
+	patchPostgresqlParametersMutex       sync.RWMutex
+	patchPostgresqlParametersArgsForCall []struct {
+		instanceID structs.ClusterID
+		changes    map[string]interface{}
+	}
+	patchPostgresqlParametersReturns struct {
+		result1 error
+	}
+	LeaderConnURLStub        func(structs.ClusterID) (string, error)
+	leaderConnURLMutex       sync.RWMutex
+	leaderConnURLArgsForCall []struct {
+		arg1 structs.ClusterID
+	}
+	leaderConnURLReturns struct {
+		result1 string
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -226,6 +316,347 @@ func (fake *FakePatroni) FailoverFromReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *FakePatroni) Switchover(instanceID structs.ClusterID, candidateID string, scheduledAt time.Time) error {
+	fake.switchoverMutex.Lock()
+	fake.switchoverArgsForCall = append(fake.switchoverArgsForCall, struct {
+		instanceID  structs.ClusterID
+		candidateID string
+		scheduledAt time.Time
+	}{instanceID, candidateID, scheduledAt})
+	fake.recordInvocation("Switchover", []interface{}{instanceID, candidateID, scheduledAt})
+	fake.switchoverMutex.Unlock()
+	if fake.SwitchoverStub != nil {
+		return fake.SwitchoverStub(instanceID, candidateID, scheduledAt)
+	} else {
+		return fake.switchoverReturns.result1
+	}
+}
+
+func (fake *FakePatroni) SwitchoverCallCount() int {
+	fake.switchoverMutex.RLock()
+	defer fake.switchoverMutex.RUnlock()
+	return len(fake.switchoverArgsForCall)
+}
+
+func (fake *FakePatroni) SwitchoverArgsForCall(i int) (structs.ClusterID, string, time.Time) {
+	fake.switchoverMutex.RLock()
+	defer fake.switchoverMutex.RUnlock()
+	return fake.switchoverArgsForCall[i].instanceID, fake.switchoverArgsForCall[i].candidateID, fake.switchoverArgsForCall[i].scheduledAt
+}
+
+func (fake *FakePatroni) SwitchoverReturns(result1 error) {
+	fake.SwitchoverStub = nil
+	fake.switchoverReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakePatroni) Restart(instanceID structs.ClusterID, memberID string) error {
+	fake.restartMutex.Lock()
+	fake.restartArgsForCall = append(fake.restartArgsForCall, struct {
+		instanceID structs.ClusterID
+		memberID   string
+	}{instanceID, memberID})
+	fake.recordInvocation("Restart", []interface{}{instanceID, memberID})
+	fake.restartMutex.Unlock()
+	if fake.RestartStub != nil {
+		return fake.RestartStub(instanceID, memberID)
+	} else {
+		return fake.restartReturns.result1
+	}
+}
+
+func (fake *FakePatroni) RestartCallCount() int {
+	fake.restartMutex.RLock()
+	defer fake.restartMutex.RUnlock()
+	return len(fake.restartArgsForCall)
+}
+
+func (fake *FakePatroni) RestartArgsForCall(i int) (structs.ClusterID, string) {
+	fake.restartMutex.RLock()
+	defer fake.restartMutex.RUnlock()
+	return fake.restartArgsForCall[i].instanceID, fake.restartArgsForCall[i].memberID
+}
+
+func (fake *FakePatroni) RestartReturns(result1 error) {
+	fake.RestartStub = nil
+	fake.restartReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakePatroni) Reinitialize(instanceID structs.ClusterID, memberID string) error {
+	fake.reinitializeMutex.Lock()
+	fake.reinitializeArgsForCall = append(fake.reinitializeArgsForCall, struct {
+		instanceID structs.ClusterID
+		memberID   string
+	}{instanceID, memberID})
+	fake.recordInvocation("Reinitialize", []interface{}{instanceID, memberID})
+	fake.reinitializeMutex.Unlock()
+	if fake.ReinitializeStub != nil {
+		return fake.ReinitializeStub(instanceID, memberID)
+	} else {
+		return fake.reinitializeReturns.result1
+	}
+}
+
+func (fake *FakePatroni) ReinitializeCallCount() int {
+	fake.reinitializeMutex.RLock()
+	defer fake.reinitializeMutex.RUnlock()
+	return len(fake.reinitializeArgsForCall)
+}
+
+func (fake *FakePatroni) ReinitializeArgsForCall(i int) (structs.ClusterID, string) {
+	fake.reinitializeMutex.RLock()
+	defer fake.reinitializeMutex.RUnlock()
+	return fake.reinitializeArgsForCall[i].instanceID, fake.reinitializeArgsForCall[i].memberID
+}
+
+func (fake *FakePatroni) ReinitializeReturns(result1 error) {
+	fake.ReinitializeStub = nil
+	fake.reinitializeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakePatroni) Pause(arg1 structs.ClusterID) error {
+	fake.pauseMutex.Lock()
+	fake.pauseArgsForCall = append(fake.pauseArgsForCall, struct {
+		arg1 structs.ClusterID
+	}{arg1})
+	fake.recordInvocation("Pause", []interface{}{arg1})
+	fake.pauseMutex.Unlock()
+	if fake.PauseStub != nil {
+		return fake.PauseStub(arg1)
+	} else {
+		return fake.pauseReturns.result1
+	}
+}
+
+func (fake *FakePatroni) PauseCallCount() int {
+	fake.pauseMutex.RLock()
+	defer fake.pauseMutex.RUnlock()
+	return len(fake.pauseArgsForCall)
+}
+
+func (fake *FakePatroni) PauseArgsForCall(i int) structs.ClusterID {
+	fake.pauseMutex.RLock()
+	defer fake.pauseMutex.RUnlock()
+	return fake.pauseArgsForCall[i].arg1
+}
+
+func (fake *FakePatroni) PauseReturns(result1 error) {
+	fake.PauseStub = nil
+	fake.pauseReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakePatroni) Resume(arg1 structs.ClusterID) error {
+	fake.resumeMutex.Lock()
+	fake.resumeArgsForCall = append(fake.resumeArgsForCall, struct {
+		arg1 structs.ClusterID
+	}{arg1})
+	fake.recordInvocation("Resume", []interface{}{arg1})
+	fake.resumeMutex.Unlock()
+	if fake.ResumeStub != nil {
+		return fake.ResumeStub(arg1)
+	} else {
+		return fake.resumeReturns.result1
+	}
+}
+
+func (fake *FakePatroni) ResumeCallCount() int {
+	fake.resumeMutex.RLock()
+	defer fake.resumeMutex.RUnlock()
+	return len(fake.resumeArgsForCall)
+}
+
+func (fake *FakePatroni) ResumeArgsForCall(i int) structs.ClusterID {
+	fake.resumeMutex.RLock()
+	defer fake.resumeMutex.RUnlock()
+	return fake.resumeArgsForCall[i].arg1
+}
+
+func (fake *FakePatroni) ResumeReturns(result1 error) {
+	fake.ResumeStub = nil
+	fake.resumeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakePatroni) ClusterStatus(arg1 structs.ClusterID) ([]structs.PatroniMemberStatus, error) {
+	fake.clusterStatusMutex.Lock()
+	fake.clusterStatusArgsForCall = append(fake.clusterStatusArgsForCall, struct {
+		arg1 structs.ClusterID
+	}{arg1})
+	fake.recordInvocation("ClusterStatus", []interface{}{arg1})
+	fake.clusterStatusMutex.Unlock()
+	if fake.ClusterStatusStub != nil {
+		return fake.ClusterStatusStub(arg1)
+	} else {
+		return fake.clusterStatusReturns.result1, fake.clusterStatusReturns.result2
+	}
+}
+
+func (fake *FakePatroni) ClusterStatusCallCount() int {
+	fake.clusterStatusMutex.RLock()
+	defer fake.clusterStatusMutex.RUnlock()
+	fake.replicationLagMutex.RLock()
+	defer fake.replicationLagMutex.RUnlock()
+	return len(fake.clusterStatusArgsForCall)
+}
+
+func (fake *FakePatroni) ClusterStatusArgsForCall(i int) structs.ClusterID {
+	fake.clusterStatusMutex.RLock()
+	defer fake.clusterStatusMutex.RUnlock()
+	return fake.clusterStatusArgsForCall[i].arg1
+}
+
+func (fake *FakePatroni) ClusterStatusReturns(result1 []structs.PatroniMemberStatus, result2 error) {
+	fake.ClusterStatusStub = nil
+	fake.clusterStatusReturns = struct {
+		result1 []structs.PatroniMemberStatus
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakePatroni) ReplicationLag(arg1 structs.ClusterID) (map[string]int64, error) {
+	fake.replicationLagMutex.Lock()
+	fake.replicationLagArgsForCall = append(fake.replicationLagArgsForCall, struct {
+		arg1 structs.ClusterID
+	}{arg1})
+	fake.recordInvocation("ReplicationLag", []interface{}{arg1})
+	fake.replicationLagMutex.Unlock()
+	if fake.ReplicationLagStub != nil {
+		return fake.ReplicationLagStub(arg1)
+	} else {
+		return fake.replicationLagReturns.result1, fake.replicationLagReturns.result2
+	}
+}
+
+func (fake *FakePatroni) ReplicationLagCallCount() int {
+	fake.replicationLagMutex.RLock()
+	defer fake.replicationLagMutex.RUnlock()
+	return len(fake.replicationLagArgsForCall)
+}
+
+func (fake *FakePatroni) ReplicationLagArgsForCall(i int) structs.ClusterID {
+	fake.replicationLagMutex.RLock()
+	defer fake.replicationLagMutex.RUnlock()
+	return fake.replicationLagArgsForCall[i].arg1
+}
+
+func (fake *FakePatroni) ReplicationLagReturns(result1 map[string]int64, result2 error) {
+	fake.ReplicationLagStub = nil
+	fake.replicationLagReturns = struct {
+		result1 map[string]int64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakePatroni) SetSynchronousMode(instanceID structs.ClusterID, enabled bool) error {
+	fake.setSynchronousModeMutex.Lock()
+	fake.setSynchronousModeArgsForCall = append(fake.setSynchronousModeArgsForCall, struct {
+		instanceID structs.ClusterID
+		enabled    bool
+	}{instanceID, enabled})
+	fake.recordInvocation("SetSynchronousMode", []interface{}{instanceID, enabled})
+	fake.setSynchronousModeMutex.Unlock()
+	if fake.SetSynchronousModeStub != nil {
+		return fake.SetSynchronousModeStub(instanceID, enabled)
+	} else {
+		return fake.setSynchronousModeReturns.result1
+	}
+}
+
+func (fake *FakePatroni) SetSynchronousModeCallCount() int {
+	fake.setSynchronousModeMutex.RLock()
+	defer fake.setSynchronousModeMutex.RUnlock()
+	return len(fake.setSynchronousModeArgsForCall)
+}
+
+func (fake *FakePatroni) SetSynchronousModeArgsForCall(i int) (structs.ClusterID, bool) {
+	fake.setSynchronousModeMutex.RLock()
+	defer fake.setSynchronousModeMutex.RUnlock()
+	return fake.setSynchronousModeArgsForCall[i].instanceID, fake.setSynchronousModeArgsForCall[i].enabled
+}
+
+func (fake *FakePatroni) SetSynchronousModeReturns(result1 error) {
+	fake.SetSynchronousModeStub = nil
+	fake.setSynchronousModeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakePatroni) PatchPostgresqlParameters(instanceID structs.ClusterID, changes map[string]interface{}) error {
+	fake.patchPostgresqlParametersMutex.Lock()
+	fake.patchPostgresqlParametersArgsForCall = append(fake.patchPostgresqlParametersArgsForCall, struct {
+		instanceID structs.ClusterID
+		changes    map[string]interface{}
+	}{instanceID, changes})
+	fake.recordInvocation("PatchPostgresqlParameters", []interface{}{instanceID, changes})
+	fake.patchPostgresqlParametersMutex.Unlock()
+	if fake.PatchPostgresqlParametersStub != nil {
+		return fake.PatchPostgresqlParametersStub(instanceID, changes)
+	} else {
+		return fake.patchPostgresqlParametersReturns.result1
+	}
+}
+
+func (fake *FakePatroni) PatchPostgresqlParametersCallCount() int {
+	fake.patchPostgresqlParametersMutex.RLock()
+	defer fake.patchPostgresqlParametersMutex.RUnlock()
+	return len(fake.patchPostgresqlParametersArgsForCall)
+}
+
+func (fake *FakePatroni) PatchPostgresqlParametersArgsForCall(i int) (structs.ClusterID, map[string]interface{}) {
+	fake.patchPostgresqlParametersMutex.RLock()
+	defer fake.patchPostgresqlParametersMutex.RUnlock()
+	return fake.patchPostgresqlParametersArgsForCall[i].instanceID, fake.patchPostgresqlParametersArgsForCall[i].changes
+}
+
+func (fake *FakePatroni) PatchPostgresqlParametersReturns(result1 error) {
+	fake.PatchPostgresqlParametersStub = nil
+	fake.patchPostgresqlParametersReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakePatroni) LeaderConnURL(arg1 structs.ClusterID) (string, error) {
+	fake.leaderConnURLMutex.Lock()
+	fake.leaderConnURLArgsForCall = append(fake.leaderConnURLArgsForCall, struct {
+		arg1 structs.ClusterID
+	}{arg1})
+	fake.recordInvocation("LeaderConnURL", []interface{}{arg1})
+	fake.leaderConnURLMutex.Unlock()
+	if fake.LeaderConnURLStub != nil {
+		return fake.LeaderConnURLStub(arg1)
+	} else {
+		return fake.leaderConnURLReturns.result1, fake.leaderConnURLReturns.result2
+	}
+}
+
+func (fake *FakePatroni) LeaderConnURLCallCount() int {
+	fake.leaderConnURLMutex.RLock()
+	defer fake.leaderConnURLMutex.RUnlock()
+	return len(fake.leaderConnURLArgsForCall)
+}
+
+func (fake *FakePatroni) LeaderConnURLArgsForCall(i int) structs.ClusterID {
+	fake.leaderConnURLMutex.RLock()
+	defer fake.leaderConnURLMutex.RUnlock()
+	return fake.leaderConnURLArgsForCall[i].arg1
+}
+
+func (fake *FakePatroni) LeaderConnURLReturns(result1 string, result2 error) {
+	fake.LeaderConnURLStub = nil
+	fake.leaderConnURLReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakePatroni) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -239,6 +670,24 @@ func (fake *FakePatroni) Invocations() map[string][][]interface{} {
 	defer fake.waitForLeaderMutex.RUnlock()
 	fake.failoverFromMutex.RLock()
 	defer fake.failoverFromMutex.RUnlock()
+	fake.switchoverMutex.RLock()
+	defer fake.switchoverMutex.RUnlock()
+	fake.restartMutex.RLock()
+	defer fake.restartMutex.RUnlock()
+	fake.reinitializeMutex.RLock()
+	defer fake.reinitializeMutex.RUnlock()
+	fake.pauseMutex.RLock()
+	defer fake.pauseMutex.RUnlock()
+	fake.resumeMutex.RLock()
+	defer fake.resumeMutex.RUnlock()
+	fake.clusterStatusMutex.RLock()
+	defer fake.clusterStatusMutex.RUnlock()
+	fake.setSynchronousModeMutex.RLock()
+	defer fake.setSynchronousModeMutex.RUnlock()
+	fake.patchPostgresqlParametersMutex.RLock()
+	defer fake.patchPostgresqlParametersMutex.RUnlock()
+	fake.leaderConnURLMutex.RLock()
+	defer fake.leaderConnURLMutex.RUnlock()
 	return fake.invocations
 }
 
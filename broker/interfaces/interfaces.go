@@ -1,33 +1,63 @@
 package interfaces
 
 import (
+	"time"
+
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"golang.org/x/net/context"
 )
 
 type Scheduler interface {
 	RunCluster(ClusterModel, structs.ClusterFeatures) error
 	StopCluster(ClusterModel) error
 	VerifyClusterFeatures(structs.ClusterFeatures) error
+	BackupNode(clusterModel ClusterModel, nodeID string) error
 }
 
 type Router interface {
-	AllocatePort() (int, error)
+	AllocatePort(structs.ClusterID) (int, error)
 	AssignPortToCluster(structs.ClusterID, int) error
+	AllocateReplicaPort(structs.ClusterID) (int, error)
+	AssignReplicaPortToCluster(structs.ClusterID, int) error
+	AssignScopeToCluster(clusterID structs.ClusterID, scope structs.ClusterID) error
 	RemoveClusterAssignment(structs.ClusterID) error
+	UnassignCluster(structs.ClusterID) error
+	SweepOrphanedReservations(PortHolders) ([]structs.ClusterID, error)
+	PublishRoutingTables(context.Context) error
+	InitializePorts() error
 }
 
 type State interface {
-	ClusterExists(structs.ClusterID) bool
+	ClusterExists(structs.ClusterID) (bool, error)
 	SaveCluster(structs.ClusterState) error
 	LoadCluster(structs.ClusterID) (structs.ClusterState, error)
 	DeleteCluster(structs.ClusterID) error
+	DeleteClusterState(structs.ClusterID) error
 	LoadAllRunningClusters() ([]*structs.ClusterState, error)
+	LoadAllClusterIDs() ([]structs.ClusterID, error)
+	SaveTombstone(structs.Tombstone) error
+	LoadTombstone(structs.ClusterID) (structs.Tombstone, error)
+	LoadAllTombstones() ([]*structs.Tombstone, error)
+	DeleteTombstone(structs.ClusterID) error
+}
+
+// PortHolders reports whether a service instance still holds its port reservation
+type PortHolders interface {
+	HoldsPorts(structs.ClusterID) (bool, error)
 }
 
 type ClusterModel interface {
 	ClusterState() structs.ClusterState
 	InstanceID() structs.ClusterID
+	PatroniScope() structs.ClusterID
 	AllocatedPort() int
+	AllocatedReplicaPort() int
+	Synchronous() bool
+	SetSynchronous(bool) error
+	PostgresqlParameters() map[string]string
+	SetPostgresqlParameters(map[string]string) error
+	Extensions() []string
+	SetExtensions([]string) error
 	NodeCount() int
 	Nodes() []*structs.Node
 	AddNode(structs.Node) error
@@ -46,6 +76,24 @@ type Patroni interface {
 	WaitForAllMembers(instanceID structs.ClusterID, expectedNodeCount int) error
 	WaitForLeader(structs.ClusterID) error
 	FailoverFrom(instanceID structs.ClusterID, memberID string) error
+	Switchover(instanceID structs.ClusterID, candidateID string, scheduledAt time.Time) error
+	Restart(instanceID structs.ClusterID, memberID string) error
+	Reinitialize(instanceID structs.ClusterID, memberID string) error
+	Pause(structs.ClusterID) error
+	Resume(structs.ClusterID) error
+	ClusterStatus(structs.ClusterID) ([]structs.PatroniMemberStatus, error)
+	ReplicationLag(structs.ClusterID) (map[string]int64, error)
+	SetSynchronousMode(instanceID structs.ClusterID, enabled bool) error
+	PatchPostgresqlParameters(instanceID structs.ClusterID, changes map[string]interface{}) error
+	LeaderConnURL(structs.ClusterID) (string, error)
+}
+
+type Postgresql interface {
+	CreateExtensions(connURL string, credentials structs.PostgresCredentials, extensions []string) error
+	CopyDatabases(fromConnURL, toConnURL string, credentials structs.PostgresCredentials) error
+	SetPassword(connURL string, credentials structs.PostgresCredentials, role, password string) error
+	CreateAppUser(connURL string, credentials structs.PostgresCredentials, username, password, owner string) error
+	RetireUser(connURL string, credentials structs.PostgresCredentials, username string) error
 }
 
 type CloudFoundry interface {
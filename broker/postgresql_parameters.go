@@ -0,0 +1,50 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+// assertPostgresqlParametersAllowed checks requested PostgreSQL parameters against the plan's allow-list
+func (bkr *Broker) assertPostgresqlParametersAllowed(planID string, parameters map[string]string) error {
+	allowed := map[string]bool{}
+	for _, name := range bkr.postgresql.AllowedParametersForPlan(planID) {
+		allowed[name] = true
+	}
+
+	var disallowed []string
+	for _, name := range structs.SortedPostgresqlParameterNames(parameters) {
+		if !allowed[name] {
+			disallowed = append(disallowed, name)
+			continue
+		}
+		if strings.ContainsAny(parameters[name], "\n\r'") {
+			return fmt.Errorf("Broker: postgresql parameter %s has an invalid value", name)
+		}
+	}
+	if len(disallowed) > 0 {
+		return fmt.Errorf("Broker: postgresql parameters not allowed for this plan: %s", strings.Join(disallowed, ", "))
+	}
+	return nil
+}
+
+// assertExtensionsAllowed checks requested PostgreSQL extensions against the operator's allow-list
+func (bkr *Broker) assertExtensionsAllowed(extensions []string) error {
+	allowed := map[string]bool{}
+	for _, name := range bkr.postgresql.AllowedExtensions {
+		allowed[name] = true
+	}
+
+	var disallowed []string
+	for _, name := range extensions {
+		if !allowed[name] {
+			disallowed = append(disallowed, name)
+		}
+	}
+	if len(disallowed) > 0 {
+		return fmt.Errorf("Broker: extensions not allowed: %s", strings.Join(disallowed, ", "))
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 
+	"github.com/dingotiles/dingo-postgresql-broker/backups"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
 	"github.com/dingotiles/dingo-postgresql-broker/state"
 	"github.com/frodenas/brokerapi"
@@ -28,30 +29,73 @@ func (bkr *Broker) provision(instanceID structs.ClusterID, details brokerapi.Pro
 		logger.Error("cluster-features", err)
 		return resp, false, err
 	}
+	if features.PostgresVersion == "" {
+		features.PostgresVersion = bkr.postgresql.DefaultVersionForPlan(details.PlanID)
+	}
+	if features.RestoreTarget != nil && features.CloneFromServiceName == "" {
+		err = fmt.Errorf("Broker: restore-to requires clone-from, or recreating a deleted service instance")
+		logger.Error("cluster-features", err)
+		return resp, false, err
+	}
 
 	if err = bkr.assertProvisionPrecondition(instanceID, features); err != nil {
 		logger.Error("preconditions.error", err)
 		return resp, false, err
 	}
 
-	port, err := bkr.router.AllocatePort()
-	clusterState := bkr.initCluster(instanceID, port, details)
+	if err = bkr.assertPostgresqlParametersAllowed(details.PlanID, features.PostgresqlParameters); err != nil {
+		logger.Error("postgresql-parameters.error", err)
+		return resp, false, err
+	}
+
+	if err = bkr.assertExtensionsAllowed(features.Extensions); err != nil {
+		logger.Error("extensions.error", err)
+		return resp, false, err
+	}
+
+	port, err := bkr.router.AllocatePort(instanceID)
+	if err != nil {
+		logger.Error("allocate-port", err)
+		return resp, false, fmt.Errorf("Unable to allocate a public port for service instance %s: %s", instanceID, err)
+	}
+	replicaPort, err := bkr.router.AllocateReplicaPort(instanceID)
+	if err != nil {
+		logger.Error("allocate-replica-port", err)
+		// no cluster state is saved yet, so only the port reservation is released
+		if releaseErr := bkr.router.RemoveClusterAssignment(instanceID); releaseErr != nil {
+			logger.Error("allocate-replica-port.release-port", releaseErr)
+		}
+		return resp, false, fmt.Errorf("Unable to allocate a public replica port for service instance %s: %s", instanceID, err)
+	}
+	clusterState := bkr.initCluster(instanceID, port, replicaPort, details)
+	clusterState.PostgresVersion = features.PostgresVersion
+	if err = bkr.issueClusterTLS(&clusterState); err != nil {
+		logger.Error("issue-tls-certificate", err)
+		bkr.abandonProvision(instanceID, logger)
+		return resp, false, err
+	}
 	clusterModel := state.NewClusterModel(bkr.state, clusterState)
 
 	clusterModel.SchedulingMessage("Initializing...")
 
 	if bkr.callbacks.Configured() {
 		logger.Info("recreation-data.writing")
-		bkr.callbacks.WriteRecreationData(clusterState.RecreationData())
+		if err = bkr.callbacks.WriteRecreationData(clusterState.RecreationData()); err != nil {
+			logger.Error("recreation-data.save-failure.write", err)
+			bkr.abandonProvision(instanceID, logger)
+			return resp, false, fmt.Errorf("Cluster recreation data could not be saved: %s", err)
+		}
 		logger.Info("recreation-data.restoring")
 		data, err := bkr.callbacks.RestoreRecreationData(instanceID)
 		if err != nil {
 			logger.Error("recreation-data.save-failure.error", err)
+			bkr.abandonProvision(instanceID, logger)
 			return resp, false, err
 		}
 		if !reflect.DeepEqual(clusterState.RecreationData(), data) {
 			err = fmt.Errorf("Cluster recreation data was not saved successfully")
 			logger.Error("recreation-data.save-failure.deep-equal", err)
+			bkr.abandonProvision(instanceID, logger)
 			return resp, false, err
 		}
 		logger.Info("recreation-data.success")
@@ -59,7 +103,8 @@ func (bkr *Broker) provision(instanceID structs.ClusterID, details brokerapi.Pro
 
 	var existingClusterData *structs.ClusterRecreationData
 	if features.CloneFromServiceName != "" {
-		if bkr.backups.BaseURI == "" {
+		if bkr.backupStore == nil {
+			bkr.abandonProvision(instanceID, logger)
 			return resp, false, fmt.Errorf("Broker missing configuration backups.base_uri to support 'clone-from' feature")
 		}
 
@@ -68,15 +113,26 @@ func (bkr *Broker) provision(instanceID structs.ClusterID, details brokerapi.Pro
 		existingClusterData, err = bkr.lookupClusterDataBackupByServiceInstanceName(details.SpaceGUID, features.CloneFromServiceName, logger)
 		if err != nil {
 			logger.Error("lookup-service-name.error", err)
+			bkr.abandonProvision(instanceID, logger)
 			return resp, false, err
 		}
 		logger.Info("lookup-service-name.success")
+
+		restoreTarget, err := bkr.resolveRestoreTarget(existingClusterData.Scope(), features.RestoreTarget, logger)
+		if err == nil {
+			err = clusterModel.SetRestoreTarget(restoreTarget)
+		}
+		if err != nil {
+			bkr.abandonProvision(instanceID, logger)
+			return resp, false, fmt.Errorf("Cannot clone %s: %s", features.CloneFromServiceName, err)
+		}
 	}
 
 	// Continue processing in background
 	go func() {
 		logger.Info("async-begin")
 		defer logger.Info("async-complete")
+		defer bkr.fireScheduledEvent(structs.EventProvisioned, "provision", clusterModel)
 
 		if existingClusterData != nil {
 			clusterModel.SchedulingMessage(fmt.Sprintf("Cloning existing database %s", existingClusterData.ServiceInstanceName))
@@ -91,6 +147,11 @@ func (bkr *Broker) provision(instanceID structs.ClusterID, details brokerapi.Pro
 			logger.Error("run-cluster", err)
 			return
 		}
+		if clusterModel.ClusterState().RestoreTarget != nil {
+			if err := clusterModel.SetRestoreTarget(nil); err != nil {
+				logger.Error("clear-restore-target", err)
+			}
+		}
 
 		if err := bkr.router.AssignPortToCluster(instanceID, port); err != nil {
 			logger.Error("assign-port", err)
@@ -98,19 +159,26 @@ func (bkr *Broker) provision(instanceID structs.ClusterID, details brokerapi.Pro
 			return
 		}
 
+		if err := bkr.router.AssignReplicaPortToCluster(instanceID, replicaPort); err != nil {
+			logger.Error("assign-replica-port", err)
+			clusterModel.SchedulingError(fmt.Errorf("Unsuccessful mapping database replicas to routing mesh. Please contact administrator: %s", err.Error()))
+			return
+		}
+
 		bkr.fetchAndBackupServiceInstanceName(instanceID, &clusterState, logger)
 	}()
 	return resp, true, err
 }
 
-func (bkr *Broker) initCluster(instanceID structs.ClusterID, port int, details brokerapi.ProvisionDetails) structs.ClusterState {
+func (bkr *Broker) initCluster(instanceID structs.ClusterID, port int, replicaPort int, details brokerapi.ProvisionDetails) structs.ClusterState {
 	return structs.ClusterState{
-		InstanceID:       instanceID,
-		OrganizationGUID: details.OrganizationGUID,
-		PlanID:           details.PlanID,
-		ServiceID:        details.ServiceID,
-		SpaceGUID:        details.SpaceGUID,
-		AllocatedPort:    port,
+		InstanceID:           instanceID,
+		OrganizationGUID:     details.OrganizationGUID,
+		PlanID:               details.PlanID,
+		ServiceID:            details.ServiceID,
+		SpaceGUID:            details.SpaceGUID,
+		AllocatedPort:        port,
+		AllocatedReplicaPort: replicaPort,
 		AdminCredentials: structs.PostgresCredentials{
 			Username: "pgadmin",
 			Password: NewPassword(16),
@@ -126,8 +194,23 @@ func (bkr *Broker) initCluster(instanceID structs.ClusterID, port int, details b
 	}
 }
 
+// abandonProvision removes the partially initialized state of a service instance
+// and releases its port reservation when provisioning fails before scheduling begins.
+func (bkr *Broker) abandonProvision(instanceID structs.ClusterID, logger lager.Logger) {
+	if err := bkr.state.DeleteCluster(instanceID); err != nil {
+		logger.Error("abandon.delete-cluster", err)
+	}
+	if err := bkr.router.RemoveClusterAssignment(instanceID); err != nil {
+		logger.Error("abandon.release-port", err)
+	}
+}
+
 func (bkr *Broker) assertProvisionPrecondition(instanceID structs.ClusterID, features structs.ClusterFeatures) error {
-	if bkr.state.ClusterExists(instanceID) {
+	exists, err := bkr.state.ClusterExists(instanceID)
+	if err != nil {
+		return err
+	}
+	if exists {
 		return fmt.Errorf("service instance %s already exists", instanceID)
 	}
 
@@ -151,7 +234,10 @@ func (bkr *Broker) fetchAndBackupServiceInstanceName(instanceID structs.ClusterI
 			logger.Info("backup-name.not-found")
 		} else {
 			clusterState.ServiceInstanceName = serviceInstanceName
-			bkr.callbacks.WriteRecreationData(clusterState.RecreationData())
+			if err = bkr.callbacks.WriteRecreationData(clusterState.RecreationData()); err != nil {
+				logger.Error("backup-name.update-recreation-data.write", err)
+				return
+			}
 			data, err := bkr.callbacks.RestoreRecreationData(instanceID)
 			if !reflect.DeepEqual(clusterState.RecreationData(), data) {
 				logger.Error("backup-name.update-recreation-data.failure", err)
@@ -176,19 +262,47 @@ func (bkr *Broker) lookupClusterDataBackupByServiceInstanceName(spaceGUID, name
 	return
 }
 
+// resolveRestoreTarget checks that the backups of the Patroni cluster scope can be restored,
+// to target if it is given, and returns where a cluster restored from them stops replaying WAL
+func (bkr *Broker) resolveRestoreTarget(scope structs.ClusterID, target *structs.RestoreTarget, logger lager.Logger) (*structs.RestoreTarget, error) {
+	if target == nil {
+		_, err := backups.LatestBaseBackup(bkr.backupStore, scope)
+		if err != nil {
+			logger.Error("lookup-base-backup", err)
+		}
+		return nil, err
+	}
+	resolved, err := backups.ResolveRestoreTarget(bkr.backupStore, scope, *target)
+	if err != nil {
+		logger.Error("resolve-restore-target", err)
+		return nil, err
+	}
+	logger.Info("resolve-restore-target", lager.Data{"restore-to": resolved.String(), "base-backup": resolved.BaseBackup})
+	return &resolved, nil
+}
+
 // If requested to pre-populate database from a backup of previous/existing database
 // and updates clusterState with DB credentials
 func (bkr *Broker) prepopulateDatabaseFromExistingClusterData(existingClusterData *structs.ClusterRecreationData, toInstanceID structs.ClusterID, clusterModel *state.ClusterModel, logger lager.Logger) (err error) {
-	if bkr.backups.BaseURI == "" {
+	err = bkr.copyDatabaseBackup(existingClusterData.Scope(), toInstanceID, logger)
+	if err != nil {
+		return err
+	}
+
+	return clusterModel.UpdateCredentials(existingClusterData)
+}
+
+// copyDatabaseBackup copies the continuous backups of one Patroni cluster to be the
+// backups of a new cluster, which restores from them when it starts
+func (bkr *Broker) copyDatabaseBackup(fromScope, toScope structs.ClusterID, logger lager.Logger) (err error) {
+	if bkr.backupStore == nil {
 		return fmt.Errorf("Failed to copy existing database backup: missing backups.base_uri configuration")
 	}
-	fromDatabaseBackup := fmt.Sprintf("%s/%s", bkr.backups.BaseURI, existingClusterData.InstanceID)
-	toDatabaseBackup := fmt.Sprintf("%s/%s", bkr.backups.BaseURI, toInstanceID)
-	err = bkr.callbacks.CopyDatabaseBackup(fromDatabaseBackup, toDatabaseBackup, bkr.logger)
+	logger.Info("copy-database-backup", lager.Data{"from-uri": bkr.backupStore.URI(string(fromScope)), "to-uri": bkr.backupStore.URI(string(toScope))})
+	err = backups.CopyBackups(bkr.backupStore, fromScope, toScope)
 	if err != nil {
 		logger.Error("prepopulate-database", err)
 		return fmt.Errorf("Failed to copy existing database backup to new database: %s", err.Error())
 	}
-
-	return clusterModel.UpdateCredentials(existingClusterData)
+	return nil
 }
@@ -20,30 +20,95 @@ func (bkr *Broker) Recreate(instanceID structs.ClusterID, details brokerapi.Prov
 		return resp, false, err
 	}
 
-	if err = bkr.assertRecreatePrecondition(instanceID, features); err != nil {
+	if err = bkr.assertRecreatePrecondition(instanceID); err != nil {
 		logger.Error("preconditions.error", err)
 		return resp, false, err
 	}
 
-	recreationData, err := bkr.callbacks.RestoreRecreationData(instanceID)
+	recreationData, tombstoned, err := bkr.loadRecreationData(instanceID)
 	if err != nil {
 		err = fmt.Errorf("Cannot recreate service from backup; unable to restore original service instance data: %s", err)
 		return
 	}
 
 	clusterState := bkr.initClusterStateFromRecreationData(recreationData)
+	// the cluster is recreated with its original version unless another is requested
+	if features.PostgresVersion == "" {
+		features.PostgresVersion = clusterState.PostgresVersion
+	}
+	if err = bkr.scheduler.VerifyClusterFeatures(features); err != nil {
+		logger.Error("preconditions.error", err)
+		return
+	}
+	// service instances recreated from data that predates TLS, or with an expiring certificate, are given a certificate now
+	if bkr.clusterTLSNeedsIssuing(clusterState) {
+		if err = bkr.issueClusterTLS(&clusterState); err != nil {
+			logger.Error("issue-tls-certificate", err)
+			return
+		}
+	}
+	if bkr.backupStore != nil {
+		// a cluster without a complete base backup would start with an empty database
+		clusterState.RestoreTarget, err = bkr.resolveRestoreTarget(recreationData.Scope(), features.RestoreTarget, logger)
+		if err != nil {
+			err = fmt.Errorf("Cannot recreate service from backup: %s", err)
+			return
+		}
+	} else if features.RestoreTarget != nil {
+		err = fmt.Errorf("Broker missing configuration backups.base_uri to support 'restore-to' feature")
+		return
+	}
+	// without a "synchronous" parameter the cluster is recreated with its replication mode
+	if features.Synchronous == nil {
+		synchronous := clusterState.Synchronous
+		features.Synchronous = &synchronous
+		if err = features.AssertSynchronousNodeCount(); err != nil {
+			return
+		}
+	}
 	clusterModel := state.NewClusterModel(bkr.state, clusterState)
 
 	go func() {
+		defer bkr.fireScheduledEvent(structs.EventProvisioned, "recreate", clusterModel)
+
 		err := bkr.scheduler.RunCluster(clusterModel, features)
 		if err != nil {
 			logger.Error("run-cluster", err)
 			return
 		}
+		if clusterModel.ClusterState().RestoreTarget != nil {
+			if err = clusterModel.SetRestoreTarget(nil); err != nil {
+				logger.Error("clear-restore-target", err)
+			}
+		}
+
+		// a service instance upgraded to a new major version is recreated from the backups of its upgraded cluster
+		err = bkr.router.AssignScopeToCluster(clusterModel.InstanceID(), clusterModel.PatroniScope())
+		if err != nil {
+			logger.Error("assign-scope", err)
+			return
+		}
 
 		err = bkr.router.AssignPortToCluster(clusterModel.InstanceID(), clusterModel.AllocatedPort())
 		if err != nil {
 			logger.Error("assign-port", err)
+			return
+		}
+
+		if clusterModel.AllocatedReplicaPort() != 0 {
+			err = bkr.router.AssignReplicaPortToCluster(clusterModel.InstanceID(), clusterModel.AllocatedReplicaPort())
+			if err != nil {
+				logger.Error("assign-replica-port", err)
+				return
+			}
+		}
+
+		// the service instance is running again, so its ports are no longer held by the tombstone;
+		// a failed recreate keeps the tombstone so that it can be retried
+		if tombstoned {
+			if err = bkr.state.DeleteTombstone(instanceID); err != nil {
+				logger.Error("delete-tombstone", err)
+			}
 		}
 	}()
 
@@ -61,13 +126,27 @@ func (bkr *Broker) initClusterStateFromRecreationData(recreationData *structs.Cl
 		AppCredentials:       recreationData.AppCredentials,
 		SuperuserCredentials: recreationData.SuperuserCredentials,
 		AllocatedPort:        recreationData.AllocatedPort,
+		AllocatedReplicaPort: recreationData.AllocatedReplicaPort,
+		PatroniScope:         recreationData.PatroniScope,
+		PostgresVersion:      recreationData.PostgresVersion,
+		Synchronous:          recreationData.Synchronous,
+		PostgresqlParameters: recreationData.PostgresqlParameters,
+		Extensions:           recreationData.Extensions,
+		AppOwnerRole:         recreationData.AppOwnerRole,
+		RetiringUsers:        recreationData.RetiringUsers,
+		TLS:                  recreationData.TLS,
 	}
 }
 
-func (bkr *Broker) assertRecreatePrecondition(instanceID structs.ClusterID, features structs.ClusterFeatures) error {
-	if bkr.state.ClusterExists(instanceID) {
+// assertRecreatePrecondition checks that the service instance is not running; its cluster features
+// are verified once they are completed from the recreation data.
+func (bkr *Broker) assertRecreatePrecondition(instanceID structs.ClusterID) error {
+	exists, err := bkr.state.ClusterExists(instanceID)
+	if err != nil {
+		return err
+	}
+	if exists {
 		return fmt.Errorf("service instance %s already exists", instanceID)
 	}
-
-	return bkr.scheduler.VerifyClusterFeatures(features)
+	return nil
 }
@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// ReEncryptCredentials saves the credentials of every service instance again, encrypted with
+// the active key: in etcd, in tombstones, and in the clusterdata backups. Credentials can be
+// decrypted with any configured key, so the previous key can be removed from the configuration
+// once this has succeeded.
+func (bkr *Broker) ReEncryptCredentials() error {
+	logger := bkr.newLoggingSession("reencrypt", lager.Data{"active-key": bkr.keyring.ActiveKeyID()})
+	defer logger.Info("done")
+
+	if bkr.keyring == nil {
+		return fmt.Errorf("Broker missing configuration encryption.active_key and encryption.keys")
+	}
+
+	failures := 0
+	// listed by ID, as LoadAllRunningClusters skips clusters that cannot be decrypted
+	instanceIDs, err := bkr.state.LoadAllClusterIDs()
+	if err != nil {
+		logger.Error("load-clusters", err)
+		return err
+	}
+	for _, instanceID := range instanceIDs {
+		cluster, err := bkr.state.LoadCluster(instanceID)
+		if err == nil {
+			err = bkr.state.SaveCluster(cluster)
+		}
+		if err == nil && bkr.callbacks.Configured() {
+			err = bkr.callbacks.WriteRecreationData(cluster.RecreationData())
+		}
+		if err != nil {
+			logger.Error("cluster", err, lager.Data{"instance-id": instanceID})
+			failures++
+			continue
+		}
+		logger.Info("cluster", lager.Data{"instance-id": instanceID})
+	}
+
+	tombstones, err := bkr.state.LoadAllTombstones()
+	if err != nil {
+		logger.Error("load-tombstones", err)
+		return err
+	}
+	for _, listed := range tombstones {
+		instanceID := listed.InstanceID()
+		tombstone, err := bkr.state.LoadTombstone(instanceID)
+		if err == nil {
+			err = bkr.state.SaveTombstone(tombstone)
+		}
+		if err != nil {
+			logger.Error("tombstone", err, lager.Data{"instance-id": instanceID})
+			failures++
+			continue
+		}
+		logger.Info("tombstone", lager.Data{"instance-id": instanceID})
+	}
+
+	// the clusterdata store also keeps the recreation data of service instances deleted long ago
+	if bkr.callbacks.CanListRecreationData() {
+		all, err := bkr.callbacks.ListRecreationData()
+		if err != nil {
+			logger.Error("list-clusterdata", err)
+			return err
+		}
+		for _, clusterData := range all {
+			if err = bkr.callbacks.WriteRecreationData(clusterData); err != nil {
+				logger.Error("clusterdata", err, lager.Data{"instance-id": clusterData.InstanceID})
+				failures++
+			}
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("Broker: %d service instances could not be re-encrypted; see the logs", failures)
+	}
+	return nil
+}
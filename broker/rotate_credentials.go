@@ -0,0 +1,138 @@
+package broker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/state"
+	"github.com/pivotal-golang/lager"
+)
+
+const retiringUsersSweepInterval = 10 * time.Minute
+
+// rotateCredentials replaces the passwords of a service instance's credentials, applied by
+// its leader. Progress is reported as a scheduling operation for last_operation.
+func (bkr *Broker) rotateCredentials(clusterModel *state.ClusterModel, credentials []string, logger lager.Logger) error {
+	if clusterModel.SchedulingInfo().Status == structs.SchedulingStatusInProgress {
+		return fmt.Errorf("Broker: Service instance %s is being changed; rotate its credentials once that has completed", clusterModel.InstanceID())
+	}
+
+	go bkr.runRotateCredentials(clusterModel, credentials, logger.Session("rotate-credentials", lager.Data{"credentials": credentials}))
+	return nil
+}
+
+func (bkr *Broker) runRotateCredentials(clusterModel *state.ClusterModel, credentials []string, logger lager.Logger) {
+	logger.Info("start")
+	defer logger.Info("done")
+	defer bkr.fireScheduledEvent(structs.EventUpdated, "rotate-credentials", clusterModel)
+
+	clusterModel.BeginScheduling(len(credentials))
+	connURL, err := bkr.patroni.LeaderConnURL(clusterModel.PatroniScope())
+	if err != nil {
+		logger.Error("leader-conn-url", err)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful rotating credentials: %s", err))
+		return
+	}
+
+	for _, name := range credentials {
+		clusterModel.SchedulingStepStarted(fmt.Sprintf("RotateCredentials(%s)", name))
+		if err = bkr.rotate(clusterModel, connURL, name); err != nil {
+			logger.Error("rotate", err, lager.Data{"name": name})
+			clusterModel.SchedulingError(fmt.Errorf("Unsuccessful rotating %s credentials: %s", name, err))
+			return
+		}
+		logger.Info("rotated", lager.Data{"name": name})
+		clusterModel.SchedulingStepCompleted()
+	}
+
+	if bkr.callbacks.Configured() {
+		cluster := clusterModel.ClusterState()
+		if err = bkr.callbacks.WriteRecreationData(cluster.RecreationData()); err != nil {
+			logger.Error("write-recreation-data", err)
+		}
+	}
+}
+
+// rotate sets a new password for one of a service instance's credentials. The app
+// credentials are replaced by a new app user, so that existing bindings keep working
+// with the previous app user during the grace period.
+// The new admin password is saved before it is set, and the previous one restored if setting it
+// fails, so that the state never holds an admin password that is older than the role's.
+func (bkr *Broker) rotate(clusterModel *state.ClusterModel, connURL string, name string) error {
+	cluster := clusterModel.ClusterState()
+	superuser := cluster.SuperuserCredentials
+	switch name {
+	case structs.CredentialsApp:
+		owner := cluster.AppOwner()
+		credentials := structs.PostgresCredentials{
+			Username: fmt.Sprintf("%s_%d", owner, time.Now().Unix()),
+			Password: NewPassword(16),
+		}
+		if err := bkr.psql.CreateAppUser(connURL, superuser, credentials.Username, credentials.Password, owner); err != nil {
+			return err
+		}
+		grace := time.Duration(bkr.postgresql.CredentialRotationGraceHours) * time.Hour
+		return clusterModel.ReplaceAppCredentials(credentials, time.Now().UTC().Add(grace))
+	case structs.CredentialsAdmin:
+		previous := cluster.AdminCredentials
+		credentials := structs.PostgresCredentials{Username: previous.Username, Password: NewPassword(16)}
+		if err := clusterModel.SetAdminCredentials(credentials); err != nil {
+			return err
+		}
+		if err := bkr.psql.SetPassword(connURL, superuser, credentials.Username, credentials.Password); err != nil {
+			if restoreErr := clusterModel.SetAdminCredentials(previous); restoreErr != nil {
+				return fmt.Errorf("%s; and could not restore the previous admin credentials: %s", err, restoreErr)
+			}
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("Broker: unknown credentials '%s'", name)
+}
+
+// retireReplacedAppUsers periodically stops app users replaced by a credential rotation
+// from logging in, once their grace period has ended
+func (bkr *Broker) retireReplacedAppUsers() {
+	for range time.Tick(retiringUsersSweepInterval) {
+		logger := bkr.logger.Session("retire-replaced-app-users")
+		clusters, err := bkr.state.LoadAllRunningClusters()
+		if err != nil {
+			logger.Error("load-clusters", err)
+			continue
+		}
+		for _, cluster := range clusters {
+			clusterModel := state.NewClusterModel(bkr.state, *cluster)
+			for _, user := range clusterModel.RetiringUsers() {
+				if time.Now().Before(user.RetireAt) {
+					continue
+				}
+				bkr.retireUser(clusterModel, user.Username, logger)
+			}
+		}
+	}
+}
+
+func (bkr *Broker) retireUser(clusterModel *state.ClusterModel, username string, logger lager.Logger) {
+	data := lager.Data{"instance-id": clusterModel.InstanceID(), "username": username}
+	connURL, err := bkr.patroni.LeaderConnURL(clusterModel.PatroniScope())
+	if err != nil {
+		logger.Error("leader-conn-url", err, data)
+		return
+	}
+	if err = bkr.psql.RetireUser(connURL, clusterModel.ClusterState().SuperuserCredentials, username); err != nil {
+		logger.Error("retire-user", err, data)
+		return
+	}
+	logger.Info("retired", data)
+	if err = clusterModel.ForgetRetiringUser(username); err != nil {
+		logger.Error("forget-retiring-user", err, data)
+		return
+	}
+	if bkr.callbacks.Configured() {
+		cluster := clusterModel.ClusterState()
+		if err = bkr.callbacks.WriteRecreationData(cluster.RecreationData()); err != nil {
+			logger.Error("write-recreation-data", err, data)
+		}
+	}
+}
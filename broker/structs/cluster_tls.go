@@ -0,0 +1,10 @@
+package structs
+
+// ClusterTLS is the server certificate of a cluster's PostgreSQL, issued by the broker's CA.
+// Its nodes are given it when they are provisioned, and bindings require TLS.
+type ClusterTLS struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+	// CACert is the PEM certificate that clients verify the server's certificate with
+	CACert string `json:"ca_cert"`
+}
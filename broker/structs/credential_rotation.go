@@ -0,0 +1,94 @@
+package structs
+
+import (
+	"fmt"
+	"time"
+)
+
+// The credentials of a service instance that can be rotated
+const (
+	CredentialsApp       = "app"
+	CredentialsAdmin     = "admin"
+	CredentialsSuperuser = "superuser"
+)
+
+// RotatableCredentials are the credentials rotated by "all", in the order they are rotated.
+// The superuser cannot be rotated, as Patroni connects to PostgreSQL with the password
+// it was configured with when its container was created.
+var RotatableCredentials = []string{CredentialsApp, CredentialsAdmin}
+
+// RetiringUser is an app user replaced by a credential rotation. Existing bindings
+// can still log in with it until RetireAt, after which it can no longer log in.
+type RetiringUser struct {
+	Username string    `json:"username"`
+	RetireAt time.Time `json:"retire_at"`
+}
+
+// AppOwner is the role that owns the app's database objects. Each app user created by a
+// credential rotation is a member of it and acts as it, so that the objects are shared.
+func (c *ClusterState) AppOwner() string {
+	if c.AppOwnerRole != "" {
+		return c.AppOwnerRole
+	}
+	return c.AppCredentials.Username
+}
+
+// credentialsFromParameter parses the "rotate-credentials" parameter, which is "all",
+// one of RotatableCredentials, or a list of them
+func credentialsFromParameter(raw interface{}) ([]string, error) {
+	var requested []string
+	switch value := raw.(type) {
+	case string:
+		if value == "all" {
+			return RotatableCredentials, nil
+		}
+		requested = []string{value}
+	case []interface{}:
+		for _, item := range value {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("Broker: rotate-credentials must only list %v", RotatableCredentials)
+			}
+			requested = append(requested, name)
+		}
+	case []string:
+		requested = value
+	default:
+		return nil, fmt.Errorf("Broker: rotate-credentials must be \"all\" or a list of %v", RotatableCredentials)
+	}
+	return OrderedCredentials(requested)
+}
+
+// OrderedCredentials validates the names of credentials to rotate, and returns them
+// without duplicates in the order they are rotated
+func OrderedCredentials(requested []string) ([]string, error) {
+	wanted := map[string]bool{}
+	for _, name := range requested {
+		if name == CredentialsSuperuser {
+			return nil, fmt.Errorf("Broker: superuser credentials cannot be rotated, as Patroni connects with them; rotate-credentials accepts %v", RotatableCredentials)
+		}
+		if !isRotatable(name) {
+			return nil, fmt.Errorf("Broker: unknown credentials '%s' in rotate-credentials; expected %v", name, RotatableCredentials)
+		}
+		wanted[name] = true
+	}
+	if len(wanted) == 0 {
+		return nil, fmt.Errorf("Broker: rotate-credentials requires at least one of %v", RotatableCredentials)
+	}
+	ordered := []string{}
+	for _, name := range RotatableCredentials {
+		if wanted[name] {
+			ordered = append(ordered, name)
+		}
+	}
+	return ordered, nil
+}
+
+func isRotatable(name string) bool {
+	for _, rotatable := range RotatableCredentials {
+		if name == rotatable {
+			return true
+		}
+	}
+	return false
+}
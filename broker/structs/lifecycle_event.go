@@ -0,0 +1,76 @@
+package structs
+
+import "time"
+
+// LifecycleEventSchemaVersion changes only when fields of LifecycleEvent are removed or change meaning
+const LifecycleEventSchemaVersion = 1
+
+// Lifecycle events that can be given to callbacks.events
+const (
+	EventProvisioned      = "provisioned"
+	EventUpdated          = "updated"
+	EventBound            = "bound"
+	EventUnbound          = "unbound"
+	EventDeprovisioned    = "deprovisioned"
+	EventFailover         = "failover"
+	EventSchedulingFailed = "scheduling-failed"
+)
+
+// LifecycleEvents are all the events that can be configured
+var LifecycleEvents = []string{
+	EventProvisioned, EventUpdated, EventBound, EventUnbound,
+	EventDeprovisioned, EventFailover, EventSchedulingFailed,
+}
+
+// Outcomes of the operation that a LifecycleEvent reports
+const (
+	EventStatusSucceeded = "succeeded"
+	EventStatusFailed    = "failed"
+)
+
+// LifecycleEvent is the JSON sent to an event callback after an operation on a
+// service instance has completed or failed
+type LifecycleEvent struct {
+	SchemaVersion int       `json:"schema_version"`
+	Event         string    `json:"event"`
+	Status        string    `json:"status"`
+	Timestamp     time.Time `json:"timestamp"`
+	// Operation is what the broker was doing, such as "provision", "recreate", "upgrade" or "switchover"
+	Operation           string    `json:"operation"`
+	InstanceID          ClusterID `json:"instance_id"`
+	ServiceID           string    `json:"service_id"`
+	PlanID              string    `json:"plan_id"`
+	OrganizationGUID    string    `json:"organization_guid"`
+	SpaceGUID           string    `json:"space_guid"`
+	ServiceInstanceName string    `json:"service_instance_name,omitempty"`
+	PostgresVersion     string    `json:"postgres_version,omitempty"`
+	BindingID           string    `json:"binding_id,omitempty"`
+	// FromNode is the node that was the leader before a failover
+	FromNode string `json:"from_node,omitempty"`
+	// ToNode is the node that a switchover made the leader, if one was asked for
+	ToNode string `json:"to_node,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewLifecycleEvent describes an operation on a service instance, which failed if err is not nil
+func NewLifecycleEvent(event, operation string, cluster ClusterState, err error) LifecycleEvent {
+	lifecycleEvent := LifecycleEvent{
+		SchemaVersion:       LifecycleEventSchemaVersion,
+		Event:               event,
+		Status:              EventStatusSucceeded,
+		Timestamp:           time.Now().UTC(),
+		Operation:           operation,
+		InstanceID:          cluster.InstanceID,
+		ServiceID:           cluster.ServiceID,
+		PlanID:              cluster.PlanID,
+		OrganizationGUID:    cluster.OrganizationGUID,
+		SpaceGUID:           cluster.SpaceGUID,
+		ServiceInstanceName: cluster.ServiceInstanceName,
+		PostgresVersion:     cluster.PostgresVersion,
+	}
+	if err != nil {
+		lifecycleEvent.Status = EventStatusFailed
+		lifecycleEvent.Error = err.Error()
+	}
+	return lifecycleEvent
+}
@@ -0,0 +1,80 @@
+package structs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// restartRequiredParameters only take effect after PostgreSQL is restarted
+var restartRequiredParameters = map[string]bool{
+	"max_connections":           true,
+	"shared_buffers":            true,
+	"max_prepared_transactions": true,
+	"max_locks_per_transaction": true,
+	"max_worker_processes":      true,
+	"max_wal_senders":           true,
+	"max_replication_slots":     true,
+	"shared_preload_libraries":  true,
+	"track_activity_query_size": true,
+	"wal_buffers":               true,
+	"huge_pages":                true,
+}
+
+// PostgresqlParameterRequiresRestart is true if a change to the parameter only applies after a restart
+func PostgresqlParameterRequiresRestart(name string) bool {
+	return restartRequiredParameters[name]
+}
+
+// PostgresqlParameterChanges returns the changes for Patroni's dynamic configuration that turn
+// current into requested parameters; removed parameters are nil so that Patroni resets them.
+// restart is true if any changed parameter requires PostgreSQL to be restarted.
+func PostgresqlParameterChanges(current, requested map[string]string) (changes map[string]interface{}, restart bool) {
+	changes = map[string]interface{}{}
+	for name, value := range requested {
+		if currentValue, ok := current[name]; !ok || currentValue != value {
+			changes[name] = value
+		}
+	}
+	for name := range current {
+		if _, ok := requested[name]; !ok {
+			changes[name] = nil
+		}
+	}
+	for name := range changes {
+		if PostgresqlParameterRequiresRestart(name) {
+			restart = true
+		}
+	}
+	return
+}
+
+// SortedPostgresqlParameterNames returns the parameter names in alphabetical order
+func SortedPostgresqlParameterNames(parameters map[string]string) []string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func postgresqlParametersFromParameter(raw interface{}) (map[string]string, error) {
+	rawParameters, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Broker: postgresql must be a map of parameter names to values")
+	}
+	parameters := map[string]string{}
+	for name, value := range rawParameters {
+		switch value := value.(type) {
+		case float64:
+			// JSON numbers are decoded as float64, and PostgreSQL does not accept exponents such as 1e+06
+			parameters[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		case string, bool, int, int64:
+			parameters[name] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("Broker: postgresql parameter %s must be a string, number or boolean", name)
+		}
+	}
+	return parameters, nil
+}
@@ -0,0 +1,42 @@
+package structs
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// PostgreSQL truncates restore point names to 63 bytes
+var restorePointNameRegExp = regexp.MustCompile("^[A-Za-z0-9_.-]{1,63}$")
+
+// RestoreTarget is where a cluster restored from backups stops replaying WAL:
+// either a time, or a restore point created with pg_create_restore_point()
+type RestoreTarget struct {
+	Time time.Time `json:"time,omitempty"`
+	Name string    `json:"name,omitempty"`
+	// BaseBackup is the base backup the cluster is restored from, chosen by the broker
+	BaseBackup string `json:"base_backup,omitempty"`
+}
+
+// restoreTargetFromParameter parses the "restore-to" parameter: an RFC 3339 timestamp, or a restore point name
+func restoreTargetFromParameter(restoreTo string) (*RestoreTarget, error) {
+	if restoreTime, err := time.Parse(time.RFC3339, restoreTo); err == nil {
+		return &RestoreTarget{Time: restoreTime.UTC()}, nil
+	}
+	if !restorePointNameRegExp.MatchString(restoreTo) {
+		return nil, fmt.Errorf("Broker: restore-to '%s' must be an RFC 3339 timestamp, such as 2016-08-01T12:00:00Z, or the name of a restore point", restoreTo)
+	}
+	return &RestoreTarget{Name: restoreTo}, nil
+}
+
+// IsTime is true if WAL is replayed up to a time rather than to a named restore point
+func (t *RestoreTarget) IsTime() bool {
+	return t.Name == ""
+}
+
+func (t *RestoreTarget) String() string {
+	if t.IsTime() {
+		return t.Time.Format(time.RFC3339)
+	}
+	return t.Name
+}
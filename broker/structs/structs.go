@@ -2,6 +2,8 @@ package structs
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -29,6 +31,15 @@ type ClusterRecreationData struct {
 	SuperuserCredentials PostgresCredentials `json:"superuser_credentials"`
 	AppCredentials       PostgresCredentials `json:"app_credentials"`
 	AllocatedPort        int                 `json:"allocated_port"`
+	AllocatedReplicaPort int                 `json:"allocated_replica_port,omitempty"`
+	PatroniScope         ClusterID           `json:"patroni_scope,omitempty"`
+	PostgresVersion      string              `json:"postgres_version,omitempty"`
+	Synchronous          bool                `json:"synchronous,omitempty"`
+	PostgresqlParameters map[string]string   `json:"postgresql_parameters,omitempty"`
+	Extensions           []string            `json:"extensions,omitempty"`
+	AppOwnerRole         string              `json:"app_owner_role,omitempty"`
+	RetiringUsers        []RetiringUser      `json:"retiring_users,omitempty"`
+	TLS                  *ClusterTLS         `json:"tls,omitempty"`
 }
 
 type ClusterState struct {
@@ -41,9 +52,33 @@ type ClusterState struct {
 	SuperuserCredentials PostgresCredentials `json:"superuser_credentials"`
 	AppCredentials       PostgresCredentials `json:"app_credentials"`
 	AllocatedPort        int                 `json:"allocated_port"`
+	AllocatedReplicaPort int                 `json:"allocated_replica_port,omitempty"`
+	PatroniScope         ClusterID           `json:"patroni_scope,omitempty"`
+	PostgresVersion      string              `json:"postgres_version,omitempty"`
+	Synchronous          bool                `json:"synchronous,omitempty"`
+	PostgresqlParameters map[string]string   `json:"postgresql_parameters,omitempty"`
+	Extensions           []string            `json:"extensions,omitempty"`
 	SchedulingInfo       SchedulingInfo      `json:"info"`
 	ServiceInstanceName  string              `json:"service_instance_name"`
 	Nodes                []*Node             `json:"nodes"`
+	PreviousCluster      *PreviousCluster    `json:"previous_cluster,omitempty"`
+	// RestoreTarget is given to the nodes of a cluster being restored from backups, until it is running
+	RestoreTarget *RestoreTarget `json:"restore_target,omitempty"`
+	// AppOwnerRole is set once the app credentials have been rotated; see AppOwner
+	AppOwnerRole string `json:"app_owner_role,omitempty"`
+	// RetiringUsers are app users replaced by credential rotations that can still log in
+	RetiringUsers []RetiringUser `json:"retiring_users,omitempty"`
+	// TLS is nil unless the cluster accepts TLS connections
+	TLS *ClusterTLS `json:"tls,omitempty"`
+}
+
+// PreviousCluster is the cluster that served a service instance before a major version upgrade.
+// It is kept, read-only, so that the upgrade can be rolled back until RetainUntil.
+type PreviousCluster struct {
+	PatroniScope    ClusterID `json:"patroni_scope"`
+	PostgresVersion string    `json:"postgres_version"`
+	Nodes           []*Node   `json:"nodes"`
+	RetainUntil     time.Time `json:"retain_until"`
 }
 
 type SchedulingInfo struct {
@@ -57,6 +92,23 @@ func (c *ClusterState) NodeCount() int {
 	return len(c.Nodes)
 }
 
+// Scope is the name of the Patroni cluster serving the service instance.
+// It is the instance ID unless the cluster was replaced by a major version upgrade.
+func (c *ClusterState) Scope() ClusterID {
+	if c.PatroniScope != "" {
+		return c.PatroniScope
+	}
+	return c.InstanceID
+}
+
+// Scope is the name of the Patroni cluster, which also names its database backups
+func (d *ClusterRecreationData) Scope() ClusterID {
+	if d.PatroniScope != "" {
+		return d.PatroniScope
+	}
+	return d.InstanceID
+}
+
 func (c *ClusterState) RecreationData() *ClusterRecreationData {
 	return &ClusterRecreationData{
 		ServiceInstanceName:  c.ServiceInstanceName,
@@ -69,6 +121,15 @@ func (c *ClusterState) RecreationData() *ClusterRecreationData {
 		SuperuserCredentials: c.SuperuserCredentials,
 		AppCredentials:       c.AppCredentials,
 		AllocatedPort:        c.AllocatedPort,
+		AllocatedReplicaPort: c.AllocatedReplicaPort,
+		PatroniScope:         c.PatroniScope,
+		PostgresVersion:      c.PostgresVersion,
+		Synchronous:          c.Synchronous,
+		PostgresqlParameters: c.PostgresqlParameters,
+		Extensions:           c.Extensions,
+		AppOwnerRole:         c.AppOwnerRole,
+		RetiringUsers:        c.RetiringUsers,
+		TLS:                  c.TLS,
 	}
 }
 
@@ -97,6 +158,19 @@ type ClusterFeatures struct {
 	NodeCount            int      `mapstructure:"node-count"`
 	CellGUIDs            []string `mapstructure:"cells"`
 	CloneFromServiceName string   `mapstructure:"clone-from"`
+	Synchronous          *bool    `mapstructure:"synchronous"` // nil if the "synchronous" parameter was not given
+	Extensions           []string `mapstructure:"extensions"`
+	PostgresVersion      string   `mapstructure:"postgres-version"`
+	RestoreTo            string   `mapstructure:"restore-to"`
+	BackupNow            bool     `mapstructure:"backup-now"`
+	// RotateCredentials are the credentials to rotate, in the order they are rotated
+	RotateCredentials []string `mapstructure:"-"`
+	// PostgresqlParameters is nil if the "postgresql" parameter was not given
+	PostgresqlParameters map[string]string `mapstructure:"-"`
+	// RestoreTarget is parsed from RestoreTo, and is nil if it was not given
+	RestoreTarget *RestoreTarget `mapstructure:"-"`
+	// ReplaceNodes replaces every node, such as to give them a new TLS certificate
+	ReplaceNodes bool `mapstructure:"-"`
 }
 
 type PostgresCredentials struct {
@@ -104,6 +178,25 @@ type PostgresCredentials struct {
 	Password string `json:"password"`
 }
 
+// PatroniMemberStatus is a member's own report of its state, from its Patroni REST API (GET /patroni)
+type PatroniMemberStatus struct {
+	MemberID            string `json:"member_id"`
+	APIURL              string `json:"api_url"`
+	State               string `json:"state,omitempty"`
+	Role                string `json:"role,omitempty"`
+	ServerVersion       int    `json:"server_version,omitempty"`
+	PostmasterStartTime string `json:"postmaster_start_time,omitempty"`
+	Timeline            int    `json:"timeline,omitempty"`
+	Pause               bool   `json:"pause,omitempty"`
+	Xlog                struct {
+		Location         int64 `json:"location,omitempty"`
+		ReceivedLocation int64 `json:"received_location,omitempty"`
+		ReplayedLocation int64 `json:"replayed_location,omitempty"`
+		Paused           bool  `json:"paused,omitempty"`
+	} `json:"xlog"`
+	Error string `json:"error,omitempty"`
+}
+
 type Node struct {
 	ID       string `json:"node_id"`
 	CellGUID string `json:"cell_guid"`
@@ -112,6 +205,11 @@ type Node struct {
 }
 
 func ClusterFeaturesFromParameters(params map[string]interface{}) (features ClusterFeatures, err error) {
+	// allow versions such as 9.6 to be given as JSON numbers
+	if version, ok := params["postgres-version"].(float64); ok {
+		features.PostgresVersion = strconv.FormatFloat(version, 'f', -1, 64)
+		params = withoutKey(params, "postgres-version")
+	}
 	err = mapstructure.Decode(params, &features)
 	if err != nil {
 		return
@@ -123,6 +221,50 @@ func ClusterFeaturesFromParameters(params map[string]interface{}) (features Clus
 		err = fmt.Errorf("Broker: node-count (%d) must be a positive number", features.NodeCount)
 		return
 	}
-
+	if raw, ok := params["postgresql"]; ok {
+		features.PostgresqlParameters, err = postgresqlParametersFromParameter(raw)
+		if err != nil {
+			return
+		}
+	}
+	if features.RestoreTo != "" {
+		features.RestoreTarget, err = restoreTargetFromParameter(features.RestoreTo)
+		if err != nil {
+			return
+		}
+	}
+	if raw, ok := params["rotate-credentials"]; ok {
+		features.RotateCredentials, err = credentialsFromParameter(raw)
+		if err != nil {
+			return
+		}
+		if len(params) > 1 {
+			err = fmt.Errorf("Broker: rotate-credentials cannot be combined with other parameters")
+			return
+		}
+	}
+	if features.BackupNow && len(params) > 1 {
+		err = fmt.Errorf("Broker: backup-now cannot be combined with other parameters")
+		return
+	}
+	err = features.AssertSynchronousNodeCount()
 	return
 }
+
+// AssertSynchronousNodeCount returns an error if synchronous replication is requested without replicas
+func (features ClusterFeatures) AssertSynchronousNodeCount() error {
+	if features.Synchronous != nil && *features.Synchronous && features.NodeCount < 2 {
+		return fmt.Errorf("Broker: synchronous replication requires a node-count (%d) of at least 2", features.NodeCount)
+	}
+	return nil
+}
+
+func withoutKey(params map[string]interface{}, key string) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for k, v := range params {
+		if k != key {
+			copied[k] = v
+		}
+	}
+	return copied
+}
@@ -1,6 +1,10 @@
 package structs
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestStructs_ClusterFeaturesFromParameters_Defaults(t *testing.T) {
 	t.Parallel()
@@ -67,3 +71,183 @@ func TestFeatures_FromProvisionDetails_PrepopulateClone(t *testing.T) {
 		t.Fatalf("features.CloneFrom should be 'test-db'")
 	}
 }
+
+func TestFeatures_FromProvisionDetails_Synchronous(t *testing.T) {
+	t.Parallel()
+
+	features, err := ClusterFeaturesFromParameters(map[string]interface{}{"synchronous": true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.Synchronous == nil || !*features.Synchronous {
+		t.Fatalf("features.Synchronous should be true")
+	}
+
+	features, err = ClusterFeaturesFromParameters(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.Synchronous != nil {
+		t.Fatalf("features.Synchronous should be nil when not given, got %v", *features.Synchronous)
+	}
+
+	_, err = ClusterFeaturesFromParameters(map[string]interface{}{"synchronous": true, "node-count": 1})
+	if err == nil {
+		t.Fatalf("Expected Error for synchronous replication with a single node")
+	}
+}
+
+func TestFeatures_FromProvisionDetails_PostgresqlParameters(t *testing.T) {
+	t.Parallel()
+
+	features, err := ClusterFeaturesFromParameters(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.PostgresqlParameters != nil {
+		t.Fatalf("features.PostgresqlParameters should be nil when not requested")
+	}
+
+	params := map[string]interface{}{
+		"postgresql": map[string]interface{}{"max_connections": float64(200), "work_mem": "8MB"},
+	}
+	features, err = ClusterFeaturesFromParameters(params)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.PostgresqlParameters["max_connections"] != "200" || features.PostgresqlParameters["work_mem"] != "8MB" {
+		t.Fatalf("Unexpected features.PostgresqlParameters %v", features.PostgresqlParameters)
+	}
+
+	params = map[string]interface{}{
+		"postgresql": map[string]interface{}{"effective_cache_size": float64(1048576), "random_page_cost": 1.1},
+	}
+	features, err = ClusterFeaturesFromParameters(params)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.PostgresqlParameters["effective_cache_size"] != "1048576" || features.PostgresqlParameters["random_page_cost"] != "1.1" {
+		t.Fatalf("Unexpected features.PostgresqlParameters %v", features.PostgresqlParameters)
+	}
+
+	params = map[string]interface{}{
+		"postgresql": map[string]interface{}{"work_mem": []string{"8MB"}},
+	}
+	if _, err = ClusterFeaturesFromParameters(params); err == nil {
+		t.Fatalf("Expected Error for a non-scalar parameter value")
+	}
+}
+
+func TestStructs_PostgresqlParameterChanges(t *testing.T) {
+	t.Parallel()
+
+	current := map[string]string{"work_mem": "4MB", "max_connections": "100"}
+	requested := map[string]string{"work_mem": "8MB", "random_page_cost": "1.1"}
+	changes, restart := PostgresqlParameterChanges(current, requested)
+	if len(changes) != 3 || changes["work_mem"] != "8MB" || changes["random_page_cost"] != "1.1" || changes["max_connections"] != nil {
+		t.Fatalf("Unexpected changes %v", changes)
+	}
+	if !restart {
+		t.Fatalf("Resetting max_connections should require a restart")
+	}
+
+	_, restart = PostgresqlParameterChanges(requested, map[string]string{"work_mem": "16MB", "random_page_cost": "1.1"})
+	if restart {
+		t.Fatalf("Changing work_mem should not require a restart")
+	}
+}
+
+func TestFeatures_FromProvisionDetails_PostgresVersion(t *testing.T) {
+	t.Parallel()
+
+	features, err := ClusterFeaturesFromParameters(map[string]interface{}{"postgres-version": "9.6"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.PostgresVersion != "9.6" {
+		t.Fatalf("features.PostgresVersion should be 9.6, got %s", features.PostgresVersion)
+	}
+
+	features, err = ClusterFeaturesFromParameters(map[string]interface{}{"postgres-version": float64(9.6)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.PostgresVersion != "9.6" {
+		t.Fatalf("features.PostgresVersion should be 9.6 when given as a number, got %s", features.PostgresVersion)
+	}
+}
+
+func TestFeatures_FromProvisionDetails_RestoreTo(t *testing.T) {
+	t.Parallel()
+
+	features, err := ClusterFeaturesFromParameters(map[string]interface{}{"clone-from": "test-db", "restore-to": "2016-08-01T12:00:00+02:00"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.RestoreTarget == nil || !features.RestoreTarget.IsTime() || features.RestoreTarget.String() != "2016-08-01T10:00:00Z" {
+		t.Fatalf("features.RestoreTarget should be 2016-08-01T10:00:00Z, got %v", features.RestoreTarget)
+	}
+
+	features, err = ClusterFeaturesFromParameters(map[string]interface{}{"restore-to": "before_migration"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if features.RestoreTarget == nil || features.RestoreTarget.Name != "before_migration" {
+		t.Fatalf("features.RestoreTarget should be restore point before_migration, got %v", features.RestoreTarget)
+	}
+
+	if _, err = ClusterFeaturesFromParameters(map[string]interface{}{"restore-to": "yesterday at noon"}); err == nil {
+		t.Fatalf("Expected error for restore-to that is neither a timestamp nor a restore point name")
+	}
+}
+
+func TestFeatures_FromProvisionDetails_BackupNow(t *testing.T) {
+	t.Parallel()
+
+	features, err := ClusterFeaturesFromParameters(map[string]interface{}{"backup-now": true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !features.BackupNow {
+		t.Fatalf("features.BackupNow should be true")
+	}
+
+	if _, err = ClusterFeaturesFromParameters(map[string]interface{}{"backup-now": true, "node-count": 3}); err == nil {
+		t.Fatalf("Expected error combining backup-now with other parameters")
+	}
+}
+
+func TestFeatures_FromProvisionDetails_RotateCredentials(t *testing.T) {
+	t.Parallel()
+
+	for raw, expected := range map[string][]string{
+		`all`:       {"app", "admin"},
+		`admin`:     {"admin"},
+		`admin,app`: {"app", "admin"},
+	} {
+		var param interface{} = raw
+		if strings.Contains(raw, ",") {
+			list := []interface{}{}
+			for _, name := range strings.Split(raw, ",") {
+				list = append(list, name)
+			}
+			param = list
+		}
+		features, err := ClusterFeaturesFromParameters(map[string]interface{}{"rotate-credentials": param})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(features.RotateCredentials, expected) {
+			t.Fatalf("Expected %v to rotate %v, got %v", raw, expected, features.RotateCredentials)
+		}
+	}
+
+	for _, param := range []interface{}{"replication", "superuser", []interface{}{}, []interface{}{"app", 1}, true} {
+		if _, err := ClusterFeaturesFromParameters(map[string]interface{}{"rotate-credentials": param}); err == nil {
+			t.Fatalf("Expected error for rotate-credentials %v", param)
+		}
+	}
+	if _, err := ClusterFeaturesFromParameters(map[string]interface{}{"rotate-credentials": "all", "node-count": 3}); err == nil {
+		t.Fatalf("Expected error combining rotate-credentials with other parameters")
+	}
+}
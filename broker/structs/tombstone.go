@@ -0,0 +1,18 @@
+package structs
+
+import "time"
+
+// Tombstone keeps a deprovisioned service instance recoverable until RetainUntil.
+// Its public ports stay reserved, so that it can be restored with its original port.
+type Tombstone struct {
+	RecreationData ClusterRecreationData `json:"recreation_data"`
+	// BackupsURI is where the backups of the deprovisioned cluster are kept
+	BackupsURI  string    `json:"backups_uri,omitempty"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	RetainUntil time.Time `json:"retain_until"`
+}
+
+// InstanceID is the ID of the deprovisioned service instance
+func (t Tombstone) InstanceID() ClusterID {
+	return t.RecreationData.InstanceID
+}
@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/state"
+	"github.com/pivotal-golang/lager"
+)
+
+const tombstonesSweepInterval = 10 * time.Minute
+
+// tombstoneCluster keeps the recreation data of a deprovisioned service instance, and its
+// ports, until the tombstone retention period ends, so that it can be restored
+func (bkr *Broker) tombstoneCluster(clusterModel *state.ClusterModel, logger lager.Logger) error {
+	cluster := clusterModel.ClusterState()
+	deletedAt := time.Now().UTC()
+	tombstone := structs.Tombstone{
+		RecreationData: *cluster.RecreationData(),
+		DeletedAt:      deletedAt,
+		RetainUntil:    deletedAt.Add(time.Duration(bkr.config.TombstoneRetentionHours) * time.Hour),
+	}
+	if bkr.backupStore != nil {
+		tombstone.BackupsURI = bkr.backupStore.URI(string(clusterModel.PatroniScope()))
+	}
+	if err := bkr.state.SaveTombstone(tombstone); err != nil {
+		return err
+	}
+	logger.Info("tombstone", lager.Data{"retain-until": tombstone.RetainUntil})
+	return nil
+}
+
+// loadRecreationData returns the recreation data of a deprovisioned service instance,
+// from its tombstone if it has one, else from the clusterdata backups
+func (bkr *Broker) loadRecreationData(instanceID structs.ClusterID) (*structs.ClusterRecreationData, bool, error) {
+	if tombstone, err := bkr.state.LoadTombstone(instanceID); err == nil {
+		return &tombstone.RecreationData, true, nil
+	}
+	data, err := bkr.callbacks.RestoreRecreationData(instanceID)
+	return data, false, err
+}
+
+// removeExpiredTombstones periodically forgets deprovisioned service instances once their
+// retention period has ended, releasing their ports. Their backups are kept.
+func (bkr *Broker) removeExpiredTombstones() {
+	for range time.Tick(tombstonesSweepInterval) {
+		logger := bkr.logger.Session("remove-expired-tombstones")
+		tombstones, err := bkr.state.LoadAllTombstones()
+		if err != nil {
+			logger.Error("load-tombstones", err)
+			continue
+		}
+		for _, tombstone := range tombstones {
+			if time.Now().Before(tombstone.RetainUntil) {
+				continue
+			}
+			instanceID := tombstone.InstanceID()
+			logger.Info("remove", lager.Data{"instance-id": instanceID, "deleted-at": tombstone.DeletedAt})
+			exists, err := bkr.state.ClusterExists(instanceID)
+			if err != nil {
+				logger.Error("cluster-exists", err, lager.Data{"instance-id": instanceID})
+				continue
+			}
+			if exists {
+				// restored, or recreated, since; its ports are in use again
+				bkr.state.DeleteTombstone(instanceID)
+				continue
+			}
+			if err = bkr.router.RemoveClusterAssignment(instanceID); err != nil {
+				logger.Error("release-ports", err, lager.Data{"instance-id": instanceID})
+				continue
+			}
+			if err = bkr.state.DeleteTombstone(instanceID); err != nil {
+				logger.Error("delete-tombstone", err, lager.Data{"instance-id": instanceID})
+			}
+		}
+	}
+}
+
+// portHolders are the service instances whose port reservations are not orphaned:
+// those with running clusters, and those kept as tombstones
+type portHolders struct {
+	state interfaces.State
+}
+
+func (h portHolders) HoldsPorts(instanceID structs.ClusterID) (bool, error) {
+	exists, err := h.state.ClusterExists(instanceID)
+	if err != nil || exists {
+		return exists, err
+	}
+	tombstones, err := h.state.LoadAllTombstones()
+	if err != nil {
+		return false, err
+	}
+	for _, tombstone := range tombstones {
+		if tombstone.InstanceID() == instanceID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
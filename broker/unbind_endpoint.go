@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
 	"github.com/frodenas/brokerapi"
 	"github.com/pivotal-golang/lager"
 )
@@ -9,5 +10,14 @@ import (
 func (bkr *Broker) Unbind(instanceID string, bindingID string, details brokerapi.UnbindDetails) error {
 	logger := bkr.newLoggingSession("unbind", lager.Data{"instance-id": instanceID})
 	defer logger.Info("done")
+
+	cluster, err := bkr.state.LoadCluster(structs.ClusterID(instanceID))
+	if err != nil {
+		logger.Error("load-cluster.error", err)
+		cluster = structs.ClusterState{InstanceID: structs.ClusterID(instanceID)}
+	}
+	event := structs.NewLifecycleEvent(structs.EventUnbound, "unbind", cluster, nil)
+	event.BindingID = bindingID
+	bkr.fireEvent(event)
 	return nil
 }
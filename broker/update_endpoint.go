@@ -22,8 +22,17 @@ func (bkr *Broker) update(instanceID structs.ClusterID, updateDetails brokerapi.
 		logger.Error("cluster-features", err)
 		return false, err
 	}
+	if features.RestoreTarget != nil {
+		err = fmt.Errorf("Broker: restore-to can only be used when provisioning with clone-from, or recreating a deleted service instance")
+		logger.Error("cluster-features", err)
+		return false, err
+	}
 
-	if err := bkr.assertUpdatePrecondition(instanceID, features); err != nil {
+	exists, err := bkr.state.ClusterExists(instanceID)
+	if err == nil && exists == false {
+		err = fmt.Errorf("Service instance %s doesn't exist", instanceID)
+	}
+	if err != nil {
 		logger.Error("preconditions.error", err)
 		return false, err
 	}
@@ -35,19 +44,124 @@ func (bkr *Broker) update(instanceID structs.ClusterID, updateDetails brokerapi.
 	}
 	clusterModel := state.NewClusterModel(bkr.state, clusterState)
 
+	if features.BackupNow {
+		if err = bkr.backupNow(clusterModel, logger); err != nil {
+			logger.Error("backup-now.error", err)
+			return false, err
+		}
+		return true, nil
+	}
+	if len(features.RotateCredentials) > 0 {
+		if err = bkr.rotateCredentials(clusterModel, features.RotateCredentials, logger); err != nil {
+			logger.Error("rotate-credentials.error", err)
+			return false, err
+		}
+		return true, nil
+	}
+
+	planID := updateDetails.PlanID
+	if planID == "" {
+		planID = clusterState.PlanID
+	}
+
+	// Service instances provisioned before versions were recorded run the default version of their plan
+	currentVersion := clusterState.PostgresVersion
+	if currentVersion == "" {
+		currentVersion = bkr.postgresql.DefaultVersionForPlan(clusterState.PlanID)
+	}
+	if previous := clusterModel.PreviousCluster(); previous != nil && features.PostgresVersion == previous.PostgresVersion {
+		go bkr.rollbackUpgrade(clusterModel, logger)
+		return true, nil
+	}
+	upgrade := features.PostgresVersion != "" && features.PostgresVersion != currentVersion
+	if !upgrade {
+		features.PostgresVersion = currentVersion
+	}
+
+	// without a "synchronous" parameter the cluster keeps its replication mode
+	if features.Synchronous == nil {
+		synchronous := clusterModel.Synchronous()
+		features.Synchronous = &synchronous
+		if err = features.AssertSynchronousNodeCount(); err != nil {
+			logger.Error("cluster-features", err)
+			return false, err
+		}
+	}
+
+	if err = bkr.scheduler.VerifyClusterFeatures(features); err != nil {
+		logger.Error("preconditions.error", err)
+		return false, err
+	}
+	if err = bkr.assertPostgresqlParametersAllowed(planID, features.PostgresqlParameters); err != nil {
+		logger.Error("postgresql-parameters.error", err)
+		return false, err
+	}
+
+	if err = bkr.assertExtensionsAllowed(features.Extensions); err != nil {
+		logger.Error("extensions.error", err)
+		return false, err
+	}
+
+	// Service instances provisioned before replica ports existed are given one now
+	if clusterModel.AllocatedReplicaPort() == 0 {
+		// their primary port may predate the port ledger, and the replica port is reserved alongside it
+		if err = bkr.router.AssignPortToCluster(instanceID, clusterModel.AllocatedPort()); err != nil {
+			logger.Error("reserve-port", err)
+			return false, fmt.Errorf("Unable to reserve the public port of service instance %s: %s", instanceID, err)
+		}
+		replicaPort, err := bkr.router.AllocateReplicaPort(instanceID)
+		if err != nil {
+			logger.Error("allocate-replica-port", err)
+			return false, fmt.Errorf("Unable to allocate a public replica port for service instance %s: %s", instanceID, err)
+		}
+		if err = clusterModel.SetAllocatedReplicaPort(replicaPort); err != nil {
+			logger.Error("save-replica-port", err)
+			return false, err
+		}
+	}
+
+	planChanged := planID != clusterState.PlanID
+	if planChanged {
+		if err = clusterModel.SetPlanID(planID); err != nil {
+			logger.Error("save-plan", err)
+			return false, err
+		}
+	}
+	renewedTLS, err := bkr.renewClusterTLS(clusterModel)
+	if err != nil {
+		logger.Error("issue-tls-certificate", err)
+		return false, err
+	}
+	// nodes are given the certificate when they are created, so a new certificate replaces them
+	features.ReplaceNodes = renewedTLS
+	if (planChanged || renewedTLS) && bkr.callbacks.Configured() {
+		cluster := clusterModel.ClusterState()
+		if writeErr := bkr.callbacks.WriteRecreationData(cluster.RecreationData()); writeErr != nil {
+			logger.Error("write-recreation-data", writeErr)
+		}
+	}
+
+	if upgrade {
+		if err = bkr.upgradeCluster(clusterModel, currentVersion, features, logger); err != nil {
+			logger.Error("upgrade.error", err)
+			return false, err
+		}
+		return true, nil
+	}
+
 	go func() {
+		defer bkr.fireScheduledEvent(structs.EventUpdated, "update", clusterModel)
+
 		err = bkr.scheduler.RunCluster(clusterModel, features)
 		if err != nil {
 			logger.Error("run-cluster", err)
+			return
+		}
+
+		err = bkr.router.AssignReplicaPortToCluster(instanceID, clusterModel.AllocatedReplicaPort())
+		if err != nil {
+			logger.Error("assign-replica-port", err)
 		}
 	}()
 	return true, err
 }
-
-func (bkr *Broker) assertUpdatePrecondition(instanceID structs.ClusterID, features structs.ClusterFeatures) error {
-	if bkr.state.ClusterExists(instanceID) == false {
-		return fmt.Errorf("Service instance %s doesn't exist", instanceID)
-	}
-
-	return bkr.scheduler.VerifyClusterFeatures(features)
-}
@@ -0,0 +1,339 @@
+package broker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/backups"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/state"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	previousClustersSweepInterval = 10 * time.Minute
+	readOnlyParameter             = "default_transaction_read_only"
+)
+
+// upgradeCluster moves a service instance to a new PostgreSQL major version.
+// The current cluster is made read-only and backed up, a new cluster is created on the
+// new version, and the roles and databases are copied to it with pg_dumpall, as the data
+// files of one major version cannot be used by another. The routers are then switched
+// to the new cluster, and the old cluster is kept so that the upgrade can be rolled back
+// until the rollback window ends.
+func (bkr *Broker) upgradeCluster(clusterModel *state.ClusterModel, fromVersion string, features structs.ClusterFeatures, logger lager.Logger) (err error) {
+	instanceID := clusterModel.InstanceID()
+	if bkr.backupStore == nil {
+		return fmt.Errorf("Broker missing configuration backups.base_uri to support upgrading postgres-version")
+	}
+	if previous := clusterModel.PreviousCluster(); previous != nil {
+		return fmt.Errorf("Broker: Service instance %s keeps its PostgreSQL %s cluster for rollback until %s; upgrade again after that",
+			instanceID, previous.PostgresVersion, previous.RetainUntil.Format(time.RFC3339))
+	}
+	if !isNewerPostgresVersion(features.PostgresVersion, fromVersion) {
+		return fmt.Errorf("Broker: Cannot change postgres-version of service instance %s from %s to the older %s", instanceID, fromVersion, features.PostgresVersion)
+	}
+	if clusterModel.PostgresVersion() == "" {
+		// record the version of older service instances, so that rolling back can recognise it
+		if err = clusterModel.SetPostgresVersion(fromVersion); err != nil {
+			return err
+		}
+	}
+
+	go bkr.runUpgrade(clusterModel, features, logger.Session("upgrade", lager.Data{"from": fromVersion, "to": features.PostgresVersion}))
+	return nil
+}
+
+func (bkr *Broker) runUpgrade(clusterModel *state.ClusterModel, features structs.ClusterFeatures, logger lager.Logger) {
+	logger.Info("start")
+	defer logger.Info("done")
+	defer bkr.fireScheduledEvent(structs.EventUpdated, "upgrade", clusterModel)
+
+	instanceID := clusterModel.InstanceID()
+	fromScope := clusterModel.PatroniScope()
+	// each upgrade has a Patroni scope of its own, so that its backups are not mixed with those
+	// of an earlier upgrade to the same version
+	toScope := upgradeScope(instanceID, features.PostgresVersion, time.Now())
+
+	clusterModel.BeginScheduling(4)
+
+	clusterModel.SchedulingStepStarted("BackupForUpgrade")
+	if err := bkr.backupForUpgrade(clusterModel, logger); err != nil {
+		logger.Error("backup", err)
+		bkr.setReadOnly(clusterModel, fromScope, false, logger)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful backing up database for upgrade: %s", err))
+		return
+	}
+	clusterModel.SchedulingStepCompleted()
+
+	clusterModel.SchedulingStepStarted(fmt.Sprintf("ProvisionUpgradedCluster(%s)", features.PostgresVersion))
+	upgradedModel, err := bkr.provisionUpgradedCluster(clusterModel, toScope, features, logger)
+	if err != nil {
+		logger.Error("provision-upgraded-cluster", err)
+		bkr.setReadOnly(clusterModel, fromScope, false, logger)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful creating PostgreSQL %s cluster: %s", features.PostgresVersion, err))
+		return
+	}
+	clusterModel.SchedulingStepCompleted()
+
+	clusterModel.SchedulingStepStarted("CopyDatabases")
+	if err = bkr.copyDatabasesForUpgrade(clusterModel, upgradedModel); err != nil {
+		logger.Error("copy-databases", err)
+		bkr.discardCluster(upgradedModel, logger)
+		bkr.setReadOnly(clusterModel, fromScope, false, logger)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful copying databases to PostgreSQL %s cluster: %s", features.PostgresVersion, err))
+		return
+	}
+	clusterModel.SchedulingStepCompleted()
+
+	clusterModel.SchedulingStepStarted("SwapRouting")
+	if err = bkr.router.AssignScopeToCluster(instanceID, toScope); err != nil {
+		logger.Error("assign-scope", err)
+		bkr.discardCluster(upgradedModel, logger)
+		bkr.setReadOnly(clusterModel, fromScope, false, logger)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful mapping upgraded database to routing mesh. Please contact administrator: %s", err))
+		return
+	}
+	retainUntil := time.Now().Add(time.Duration(bkr.postgresql.UpgradeRollbackHours) * time.Hour)
+	_, err = clusterModel.ReplaceCluster(toScope, features.PostgresVersion, upgradedModel.Nodes(), retainUntil)
+	if err != nil {
+		logger.Error("replace-cluster", err)
+		// the service instance still records the current cluster, so route back to it
+		if routeErr := bkr.router.AssignScopeToCluster(instanceID, fromScope); routeErr != nil {
+			logger.Error("restore-scope", routeErr)
+			clusterModel.SchedulingError(fmt.Errorf("Unsuccessful recording upgraded database, and mapping the current database back to routing mesh. Please contact administrator: %s", err))
+			return
+		}
+		bkr.discardCluster(upgradedModel, logger)
+		bkr.setReadOnly(clusterModel, fromScope, false, logger)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful recording upgraded database: %s", err))
+		return
+	}
+	// the upgraded cluster is now recorded by the service instance
+	if err = bkr.state.DeleteClusterState(toScope); err != nil {
+		logger.Error("delete-upgraded-cluster-state", err)
+	}
+	if bkr.callbacks.Configured() {
+		cluster := clusterModel.ClusterState()
+		if err = bkr.callbacks.WriteRecreationData(cluster.RecreationData()); err != nil {
+			logger.Error("write-recreation-data", err)
+		}
+	}
+	clusterModel.SchedulingStepCompleted()
+}
+
+// backupForUpgrade stops writes to the cluster, so that the databases copied to the upgraded
+// cluster hold every committed transaction, and takes a base backup of it, so that it can be
+// restored as it was before the upgrade.
+func (bkr *Broker) backupForUpgrade(clusterModel *state.ClusterModel, logger lager.Logger) (err error) {
+	scope := clusterModel.PatroniScope()
+	if err = bkr.setReadOnly(clusterModel, scope, true, logger); err != nil {
+		return err
+	}
+	// the new base backup is recognised by sorting after the latest one
+	previous, _ := backups.LatestBaseBackup(bkr.backupStore, scope)
+	leaderID, err := bkr.patroni.ClusterLeader(scope)
+	if err != nil {
+		return err
+	}
+	if err = bkr.scheduler.BackupNode(clusterModel, leaderID); err != nil {
+		return err
+	}
+	backup, err := bkr.waitForBaseBackupAfter(scope, previous.Name, backupNowTimeout)
+	if err != nil {
+		return err
+	}
+	logger.Info("base-backup", lager.Data{"name": backup.Name, "size": backup.Size})
+
+	cluster := clusterModel.ClusterState()
+	if bkr.callbacks.Configured() {
+		return bkr.callbacks.WriteRecreationData(cluster.RecreationData())
+	}
+	return nil
+}
+
+// provisionUpgradedCluster creates a new cluster named toScope on the new PostgreSQL version,
+// with the credentials of the current cluster. It is stored as its own cluster until the
+// routers are switched over to it.
+func (bkr *Broker) provisionUpgradedCluster(clusterModel *state.ClusterModel, toScope structs.ClusterID, features structs.ClusterFeatures, logger lager.Logger) (*state.ClusterModel, error) {
+	current := clusterModel.ClusterState()
+	upgradedState := structs.ClusterState{
+		InstanceID:           toScope,
+		ServiceID:            current.ServiceID,
+		PlanID:               current.PlanID,
+		OrganizationGUID:     current.OrganizationGUID,
+		SpaceGUID:            current.SpaceGUID,
+		AdminCredentials:     current.AdminCredentials,
+		SuperuserCredentials: current.SuperuserCredentials,
+		AppCredentials:       current.AppCredentials,
+		TLS:                  current.TLS,
+		PostgresVersion:      features.PostgresVersion,
+		Synchronous:          current.Synchronous,
+		PostgresqlParameters: current.PostgresqlParameters,
+		Extensions:           current.Extensions,
+		ServiceInstanceName:  current.ServiceInstanceName,
+	}
+
+	upgradedModel := state.NewClusterModel(bkr.state, upgradedState)
+	upgradedModel.SchedulingMessage(fmt.Sprintf("Creating %s for upgrade", current.InstanceID))
+
+	if err := bkr.scheduler.RunCluster(upgradedModel, features); err != nil {
+		bkr.discardCluster(upgradedModel, logger)
+		return nil, err
+	}
+	return upgradedModel, nil
+}
+
+// copyDatabasesForUpgrade copies the roles and databases of the read-only current cluster
+// to the upgraded cluster, from leader to leader
+func (bkr *Broker) copyDatabasesForUpgrade(clusterModel *state.ClusterModel, upgradedModel *state.ClusterModel) error {
+	fromConnURL, err := bkr.patroni.LeaderConnURL(clusterModel.PatroniScope())
+	if err != nil {
+		return err
+	}
+	toConnURL, err := bkr.patroni.LeaderConnURL(upgradedModel.PatroniScope())
+	if err != nil {
+		return err
+	}
+	return bkr.psql.CopyDatabases(fromConnURL, toConnURL, clusterModel.ClusterState().SuperuserCredentials)
+}
+
+// rollbackUpgrade switches the routers back to the cluster kept from before an upgrade,
+// makes it writable again, and removes the upgraded cluster.
+func (bkr *Broker) rollbackUpgrade(clusterModel *state.ClusterModel, logger lager.Logger) {
+	logger = logger.Session("rollback-upgrade")
+	logger.Info("start")
+	defer logger.Info("done")
+	defer bkr.fireScheduledEvent(structs.EventUpdated, "rollback", clusterModel)
+
+	instanceID := clusterModel.InstanceID()
+	previous := clusterModel.PreviousCluster()
+
+	clusterModel.BeginScheduling(2)
+
+	clusterModel.SchedulingStepStarted("SwapRouting")
+	if err := bkr.router.AssignScopeToCluster(instanceID, previous.PatroniScope); err != nil {
+		logger.Error("assign-scope", err)
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful mapping previous database to routing mesh. Please contact administrator: %s", err))
+		return
+	}
+	upgraded, err := clusterModel.ReplaceCluster(previous.PatroniScope, previous.PostgresVersion, previous.Nodes, time.Time{})
+	if err != nil {
+		logger.Error("replace-cluster", err)
+		clusterModel.SchedulingError(err)
+		return
+	}
+	if err = bkr.setReadOnly(clusterModel, previous.PatroniScope, false, logger); err != nil {
+		clusterModel.SchedulingError(fmt.Errorf("Unsuccessful making previous database writable. Please contact administrator: %s", err))
+		return
+	}
+	if bkr.callbacks.Configured() {
+		cluster := clusterModel.ClusterState()
+		if err = bkr.callbacks.WriteRecreationData(cluster.RecreationData()); err != nil {
+			logger.Error("write-recreation-data", err)
+		}
+	}
+	clusterModel.SchedulingStepCompleted()
+
+	clusterModel.SchedulingStepStarted("RemoveUpgradedCluster")
+	bkr.removeReplacedCluster(clusterModel, upgraded, logger)
+	clusterModel.SchedulingStepCompleted()
+}
+
+// removeReplacedCluster deprovisions the nodes of a cluster that no longer serves a service instance
+func (bkr *Broker) removeReplacedCluster(clusterModel *state.ClusterModel, replaced structs.PreviousCluster, logger lager.Logger) {
+	instanceID := clusterModel.InstanceID()
+	// the replaced cluster is stored under its own key while its nodes are removed,
+	// as its scope may be the service instance's own ID
+	replacedState := structs.ClusterState{
+		InstanceID:           structs.ClusterID(fmt.Sprintf("%s-replaced", replaced.PatroniScope)),
+		PatroniScope:         replaced.PatroniScope,
+		AdminCredentials:     clusterModel.ClusterState().AdminCredentials,
+		SuperuserCredentials: clusterModel.ClusterState().SuperuserCredentials,
+		AppCredentials:       clusterModel.ClusterState().AppCredentials,
+		PostgresVersion:      replaced.PostgresVersion,
+		Nodes:                replaced.Nodes,
+	}
+	bkr.discardCluster(state.NewClusterModel(bkr.state, replacedState), logger)
+	if replaced.PatroniScope != instanceID {
+		// also remove the keys of the replaced Patroni cluster
+		bkr.state.DeleteCluster(replaced.PatroniScope)
+	}
+}
+
+// removeExpiredPreviousClusters periodically removes clusters kept for rolling back an upgrade
+// once their rollback window has ended
+func (bkr *Broker) removeExpiredPreviousClusters() {
+	for range time.Tick(previousClustersSweepInterval) {
+		logger := bkr.logger.Session("remove-expired-previous-clusters")
+		clusters, err := bkr.state.LoadAllRunningClusters()
+		if err != nil {
+			logger.Error("load-clusters", err)
+			continue
+		}
+		for _, cluster := range clusters {
+			previous := cluster.PreviousCluster
+			if previous == nil || time.Now().Before(previous.RetainUntil) {
+				continue
+			}
+			logger.Info("remove", lager.Data{"instance-id": cluster.InstanceID, "scope": previous.PatroniScope})
+			clusterModel := state.NewClusterModel(bkr.state, *cluster)
+			if err = clusterModel.ForgetPreviousCluster(); err != nil {
+				logger.Error("forget-previous-cluster", err)
+				continue
+			}
+			bkr.removeReplacedCluster(clusterModel, *previous, logger)
+		}
+	}
+}
+
+// discardCluster deprovisions the nodes of a cluster and deletes all of its keys
+func (bkr *Broker) discardCluster(clusterModel *state.ClusterModel, logger lager.Logger) {
+	if err := bkr.scheduler.StopCluster(clusterModel); err != nil {
+		logger.Error("discard-cluster.stop-cluster", err, lager.Data{"cluster-id": clusterModel.InstanceID()})
+	}
+	if err := bkr.state.DeleteCluster(clusterModel.InstanceID()); err != nil {
+		logger.Error("discard-cluster.delete-cluster", err, lager.Data{"cluster-id": clusterModel.InstanceID()})
+	}
+}
+
+// setReadOnly turns default_transaction_read_only on for a Patroni cluster, or back to the user's setting
+func (bkr *Broker) setReadOnly(clusterModel *state.ClusterModel, scope structs.ClusterID, readOnly bool, logger lager.Logger) error {
+	var value interface{}
+	if readOnly {
+		value = "on"
+	} else if userValue, ok := clusterModel.PostgresqlParameters()[readOnlyParameter]; ok {
+		value = userValue
+	}
+	err := bkr.patroni.PatchPostgresqlParameters(scope, map[string]interface{}{readOnlyParameter: value})
+	if err != nil {
+		logger.Error("set-read-only", err, lager.Data{"scope": scope, "read-only": readOnly})
+	}
+	return err
+}
+
+// upgradeScope names the Patroni cluster of a service instance upgraded to a PostgreSQL version at a time
+func upgradeScope(instanceID structs.ClusterID, postgresVersion string, at time.Time) structs.ClusterID {
+	return structs.ClusterID(fmt.Sprintf("%s-pg%s-%d", instanceID, strings.Replace(postgresVersion, ".", "", -1), at.Unix()))
+}
+
+// isNewerPostgresVersion compares versions such as "9.5", "9.6" and "10" numerically
+func isNewerPostgresVersion(version, than string) bool {
+	versionParts := strings.Split(version, ".")
+	thanParts := strings.Split(than, ".")
+	for i := 0; i < len(versionParts) || i < len(thanParts); i++ {
+		var a, b int
+		if i < len(versionParts) {
+			a, _ = strconv.Atoi(versionParts[i])
+		}
+		if i < len(thanParts) {
+			b, _ = strconv.Atoi(thanParts[i])
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpgradeCluster_isNewerPostgresVersion(t *testing.T) {
+	t.Parallel()
+
+	newer := [][]string{{"9.6", "9.5"}, {"10", "9.6"}, {"9.10", "9.6"}}
+	for _, versions := range newer {
+		if !isNewerPostgresVersion(versions[0], versions[1]) {
+			t.Fatalf("Expected %s to be newer than %s", versions[0], versions[1])
+		}
+	}
+	notNewer := [][]string{{"9.5", "9.6"}, {"9.6", "10"}, {"9.5", "9.5"}}
+	for _, versions := range notNewer {
+		if isNewerPostgresVersion(versions[0], versions[1]) {
+			t.Fatalf("Expected %s not to be newer than %s", versions[0], versions[1])
+		}
+	}
+}
+
+func TestUpgradeCluster_upgradeScope(t *testing.T) {
+	t.Parallel()
+
+	if scope := upgradeScope("f1", "9.6", time.Unix(1470052800, 0)); scope != "f1-pg96-1470052800" {
+		t.Fatalf("Expected scope f1-pg96-1470052800, got %s", scope)
+	}
+}
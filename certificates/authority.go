@@ -0,0 +1,120 @@
+package certificates
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+// clockSkew backdates certificates, so that they are valid on hosts whose clocks are behind
+const clockSkew = time.Hour
+
+// Authority issues the server certificates of clusters, signed by a configured CA
+type Authority struct {
+	caCert    *x509.Certificate
+	caCertPEM string
+	caKey     crypto.Signer
+	validity  time.Duration
+}
+
+// NewAuthority returns the authority for cfg, or nil if no CA is configured
+func NewAuthority(cfg config.PostgreSQLTLS) (*Authority, error) {
+	if cfg.CACert == "" && cfg.CAKey == "" {
+		if len(cfg.Plans) > 0 {
+			return nil, fmt.Errorf("Certificates: postgresql.tls.plans requires a ca_cert and ca_key")
+		}
+		return nil, nil
+	}
+	keyPair, err := tls.X509KeyPair([]byte(cfg.CACert), []byte(cfg.CAKey))
+	if err != nil {
+		return nil, fmt.Errorf("Certificates: invalid ca_cert or ca_key: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("Certificates: invalid ca_cert: %s", err)
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("Certificates: ca_cert %s is not a CA certificate", caCert.Subject.CommonName)
+	}
+	caKey, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Certificates: ca_key cannot sign certificates")
+	}
+	return &Authority{
+		caCert:    caCert,
+		caCertPEM: strings.TrimSpace(cfg.CACert) + "\n",
+		caKey:     caKey,
+		validity:  time.Duration(cfg.CertificateValidityDays) * 24 * time.Hour,
+	}, nil
+}
+
+// CACert is the PEM certificate of the CA, which clients verify servers with
+func (a *Authority) CACert() string {
+	return a.caCertPEM
+}
+
+// Expiry is when a PEM certificate stops being valid
+func Expiry(certificate string) (time.Time, error) {
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return time.Time{}, fmt.Errorf("Certificates: no PEM certificate found")
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Certificates: invalid certificate: %s", err)
+	}
+	return parsed.NotAfter, nil
+}
+
+// Issue returns a new PEM server certificate and private key for commonName,
+// valid for the hosts (names or IP addresses) that clients connect to
+func (a *Authority) Issue(commonName string, hosts []string) (certificate, privateKey string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(a.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.caCert, key.Public(), a.caKey)
+	if err != nil {
+		return "", "", fmt.Errorf("Certificates: could not issue certificate for %s: %s", commonName, err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	privateKey = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certificate, privateKey, nil
+}
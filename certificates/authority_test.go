@@ -0,0 +1,107 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+func newTestCA(t *testing.T, isCA bool) config.PostgreSQLTLS {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed %s", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return config.PostgreSQLTLS{
+		CACert:                  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CAKey:                   string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		CertificateValidityDays: 30,
+	}
+}
+
+func TestNewAuthority(t *testing.T) {
+	t.Parallel()
+
+	authority, err := NewAuthority(config.PostgreSQLTLS{})
+	if authority != nil || err != nil {
+		t.Fatalf("Expected no authority without a CA, got %v %v", authority, err)
+	}
+	if _, err = NewAuthority(config.PostgreSQLTLS{Plans: []string{"plan"}}); err == nil {
+		t.Fatalf("Expected error for TLS plans without a CA")
+	}
+	if _, err = NewAuthority(newTestCA(t, false)); err == nil {
+		t.Fatalf("Expected error for a ca_cert that is not a CA")
+	}
+	cfg := newTestCA(t, true)
+	cfg.CAKey = newTestCA(t, true).CAKey
+	if _, err = NewAuthority(cfg); err == nil {
+		t.Fatalf("Expected error for a ca_key that does not match the ca_cert")
+	}
+}
+
+func TestAuthority_Issue(t *testing.T) {
+	t.Parallel()
+
+	authority, err := NewAuthority(newTestCA(t, true))
+	if err != nil {
+		t.Fatalf("NewAuthority failed %s", err)
+	}
+	certificate, privateKey, err := authority.Issue("instance-id", []string{"10.244.0.34", "db.example.com"})
+	if err != nil {
+		t.Fatalf("Issue failed %s", err)
+	}
+	if block, _ := pem.Decode([]byte(privateKey)); block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Fatalf("Expected a PEM private key, got %s", privateKey)
+	}
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		t.Fatalf("Expected a PEM certificate, got %s", certificate)
+	}
+	issued, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed %s", err)
+	}
+	if issued.Subject.CommonName != "instance-id" {
+		t.Fatalf("Expected common name instance-id, got %s", issued.Subject.CommonName)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(authority.CACert()))
+	for _, host := range []string{"10.244.0.34", "db.example.com"} {
+		if _, err = issued.Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err != nil {
+			t.Fatalf("Expected certificate to be valid for %s, got %s", host, err)
+		}
+	}
+	if _, err = issued.Verify(x509.VerifyOptions{Roots: roots, DNSName: "other.example.com"}); err == nil {
+		t.Fatalf("Expected certificate not to be valid for other hosts")
+	}
+
+	expiry, err := Expiry(certificate)
+	if err != nil || !expiry.Equal(issued.NotAfter) {
+		t.Fatalf("Expected expiry %s, got %s %v", issued.NotAfter, expiry, err)
+	}
+	if _, err = Expiry(privateKey); err == nil {
+		t.Fatalf("Expected error for the expiry of a private key")
+	}
+}
@@ -0,0 +1,27 @@
+package clicmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/dingotiles/dingo-postgresql-broker/broker"
+)
+
+// ReEncrypt encrypts the stored credentials of all service instances with the active encryption key
+func ReEncrypt(c *cli.Context) {
+	cfg := loadConfig(c.String("config"))
+
+	broker, err := broker.NewBroker(cfg)
+	if err != nil {
+		fmt.Println("Could not start broker")
+		os.Exit(1)
+		return
+	}
+
+	if err = broker.ReEncryptCredentials(); err != nil {
+		fmt.Println("Could not re-encrypt credentials:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Credentials re-encrypted with key", cfg.Encryption.ActiveKey)
+}
@@ -0,0 +1,45 @@
+package clicmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/dingotiles/dingo-postgresql-broker/haproxy"
+	"github.com/dingotiles/dingo-postgresql-broker/routing"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+// RunHAProxy keeps an HAProxy configuration in sync with routing allocations and Patroni leaders
+func RunHAProxy(c *cli.Context) {
+	cfg := loadConfig(c.String("config"))
+	if c.String("output") != "" {
+		cfg.HAProxy.ConfigPath = c.String("output")
+	}
+	if c.String("reload-cmd") != "" {
+		cfg.HAProxy.ReloadCommand = c.String("reload-cmd")
+	}
+
+	logger := lager.NewLogger("dingo-postgresql-haproxy")
+	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
+
+	router, err := routing.NewRouter(cfg.Etcd, logger)
+	if err != nil {
+		fmt.Println("Could not connect to etcd")
+		os.Exit(1)
+		return
+	}
+
+	generator := haproxy.NewGenerator(cfg.HAProxy, router, logger)
+	if c.Bool("once") {
+		if _, err = generator.Generate(); err != nil {
+			fmt.Println("Could not generate HAProxy configuration:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger.Fatal("run", generator.Run(context.Background()))
+}
@@ -0,0 +1,149 @@
+package clusterdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dingotiles/dingo-postgresql-broker/backups"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+const dataSuffix = ".json"
+
+// Store archives the recreation data of service instances, one JSON object per
+// service instance, in a local directory or object store. The data of deleted
+// service instances is kept, so that they can be recreated.
+type Store struct {
+	objects backups.BackupStore
+}
+
+// NewStore returns the store for cfg.BaseURI, or nil if the archive is not configured
+func NewStore(cfg config.ClusterData) (*Store, error) {
+	objects, err := backups.NewBackupStore(config.Backups{BaseURI: cfg.BaseURI, S3: cfg.S3})
+	if err != nil {
+		return nil, fmt.Errorf("Clusterdata: %s", err)
+	}
+	if objects == nil {
+		return nil, nil
+	}
+	return NewStoreWithObjects(objects), nil
+}
+
+// NewStoreWithObjects creates a Store that keeps its data in objects
+func NewStoreWithObjects(objects backups.BackupStore) *Store {
+	return &Store{objects: objects}
+}
+
+// URI is the location of the archive
+func (s *Store) URI() string {
+	return s.objects.URI("")
+}
+
+// Write saves the recreation data of a service instance, replacing any it had
+func (s *Store) Write(data *structs.ClusterRecreationData) error {
+	if data.InstanceID == "" {
+		return fmt.Errorf("Clusterdata: recreation data has no instance_id")
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.objects.Put(dataKey(data.InstanceID), bytes.NewReader(encoded))
+}
+
+// Restore returns the recreation data of a service instance
+func (s *Store) Restore(instanceID structs.ClusterID) (*structs.ClusterRecreationData, error) {
+	key := dataKey(instanceID)
+	objects, err := s.objects.List(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		if object.Key == key {
+			return s.read(key)
+		}
+	}
+	return nil, fmt.Errorf("Clusterdata: no recreation data for service instance %s", instanceID)
+}
+
+// FindByName returns the recreation data of the service instance that most recently had a
+// name in a space, or nil if none had it
+func (s *Store) FindByName(spaceGUID, name string) (*structs.ClusterRecreationData, error) {
+	objects, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var found *structs.ClusterRecreationData
+	var foundObject backups.Object
+	for _, object := range objects {
+		data, err := s.read(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		if data.SpaceGUID != spaceGUID || data.ServiceInstanceName != name {
+			continue
+		}
+		if found == nil || object.LastModified.After(foundObject.LastModified) {
+			found, foundObject = data, object
+		}
+	}
+	return found, nil
+}
+
+// List returns the recreation data of every service instance, ordered by instance ID
+func (s *Store) List() ([]*structs.ClusterRecreationData, error) {
+	objects, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	all := make([]*structs.ClusterRecreationData, 0, len(objects))
+	for _, object := range objects {
+		data, err := s.read(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, data)
+	}
+	return all, nil
+}
+
+// list returns the objects holding recreation data; the archive may share a
+// directory or bucket with other files
+func (s *Store) list() ([]backups.Object, error) {
+	objects, err := s.objects.List("")
+	if err != nil {
+		return nil, err
+	}
+	dataObjects := []backups.Object{}
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, dataSuffix) && !strings.Contains(object.Key, "/") {
+			dataObjects = append(dataObjects, object)
+		}
+	}
+	return dataObjects, nil
+}
+
+func (s *Store) read(key string) (*structs.ClusterRecreationData, error) {
+	reader, err := s.objects.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	encoded, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	data := &structs.ClusterRecreationData{}
+	if err = json.Unmarshal(encoded, data); err != nil {
+		return nil, fmt.Errorf("Clusterdata: %s is not valid recreation data: %s", key, err)
+	}
+	return data, nil
+}
+
+func dataKey(instanceID structs.ClusterID) string {
+	return string(instanceID) + dataSuffix
+}
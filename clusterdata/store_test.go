@@ -0,0 +1,118 @@
+package clusterdata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/backups"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+func newTestStore(t *testing.T) (*Store, backups.BackupStore, func()) {
+	dir, err := ioutil.TempDir("", "clusterdata")
+	if err != nil {
+		t.Fatalf("Could not create temp dir %s", err)
+	}
+	objects := backups.NewLocalStore(filepath.Join(dir, "store"))
+	return NewStoreWithObjects(objects), objects, func() { os.RemoveAll(dir) }
+}
+
+func TestNewStore_NotConfigured(t *testing.T) {
+	t.Parallel()
+	store, err := NewStore(config.ClusterData{})
+	if store != nil || err != nil {
+		t.Fatalf("Expected no store without base_uri, got %v %v", store, err)
+	}
+}
+
+func TestStore_WriteRestore(t *testing.T) {
+	t.Parallel()
+	store, _, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if _, err := store.Restore("instance-id"); err == nil {
+		t.Fatalf("Expected error restoring unknown service instance")
+	}
+
+	data := &structs.ClusterRecreationData{
+		InstanceID: "instance-id",
+		SpaceGUID:  "space-guid",
+		AdminCredentials: structs.PostgresCredentials{
+			Username: "pgadmin",
+			Password: "pw",
+		},
+		AllocatedPort: 1234,
+	}
+	if err := store.Write(data); err != nil {
+		t.Fatalf("Write failed %s", err)
+	}
+	restored, err := store.Restore("instance-id")
+	if err != nil {
+		t.Fatalf("Restore failed %s", err)
+	}
+	if !reflect.DeepEqual(data, restored) {
+		t.Fatalf("Restored data %v should equal written data %v", restored, data)
+	}
+
+	if err = store.Write(&structs.ClusterRecreationData{}); err == nil {
+		t.Fatalf("Expected error writing data without an instance_id")
+	}
+}
+
+func TestStore_FindByNameAndList(t *testing.T) {
+	t.Parallel()
+	store, objects, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for _, data := range []*structs.ClusterRecreationData{
+		{InstanceID: "b-deleted", SpaceGUID: "space-guid", ServiceInstanceName: "test-db"},
+		{InstanceID: "a-other-space", SpaceGUID: "other-space-guid", ServiceInstanceName: "test-db"},
+		{InstanceID: "c-other-name", SpaceGUID: "space-guid", ServiceInstanceName: "other-db"},
+	} {
+		if err := store.Write(data); err != nil {
+			t.Fatalf("Write failed %s", err)
+		}
+	}
+	// the archive may share its directory with backups
+	if err := objects.Put("scope/wal_005/000000010000000000000001.lzo", strings.NewReader("wal")); err != nil {
+		t.Fatalf("Put failed %s", err)
+	}
+
+	found, err := store.FindByName("space-guid", "test-db")
+	if err != nil || found == nil || found.InstanceID != "b-deleted" {
+		t.Fatalf("Expected to find b-deleted, got %v %v", found, err)
+	}
+	found, err = store.FindByName("space-guid", "missing-db")
+	if err != nil || found != nil {
+		t.Fatalf("Expected to find nothing, got %v %v", found, err)
+	}
+
+	// the name is reused by a new service instance, which is found instead
+	time.Sleep(10 * time.Millisecond)
+	if err = store.Write(&structs.ClusterRecreationData{InstanceID: "d-new", SpaceGUID: "space-guid", ServiceInstanceName: "test-db"}); err != nil {
+		t.Fatalf("Write failed %s", err)
+	}
+	found, err = store.FindByName("space-guid", "test-db")
+	if err != nil || found == nil || found.InstanceID != "d-new" {
+		t.Fatalf("Expected to find d-new, got %v %v", found, err)
+	}
+
+	all, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed %s", err)
+	}
+	instanceIDs := []structs.ClusterID{}
+	for _, data := range all {
+		instanceIDs = append(instanceIDs, data.InstanceID)
+	}
+	expected := []structs.ClusterID{"a-other-space", "b-deleted", "c-other-name", "d-new"}
+	if !reflect.DeepEqual(expected, instanceIDs) {
+		t.Fatalf("Expected to list %v, got %v", expected, instanceIDs)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"regexp"
+	"strings"
 
 	"github.com/frodenas/brokerapi"
 
@@ -17,13 +18,18 @@ type Config struct {
 	Etcd         Etcd                    `yaml:"etcd"`
 	Callbacks    Callbacks               `yaml:"callbacks"`
 	Backups      Backups                 `yaml:"backups"`
+	ClusterData  ClusterData             `yaml:"clusterdata"`
+	Encryption   Encryption              `yaml:"encryption"`
 	Catalog      brokerapi.Catalog       `yaml:"catalog"`
 	Scheduler    Scheduler               `yaml:"scheduler"`
 	CloudFoundry CloudFoundryCredentials `yaml:"cf"`
+	HAProxy      HAProxy                 `yaml:"haproxy"`
+	Patroni      Patroni                 `yaml:"patroni"`
+	PostgreSQL   PostgreSQL              `yaml:"postgresql"`
 }
 
 func (cfg *Config) SupportsClusterDataBackup() bool {
-	return cfg.Callbacks.ClusterDataBackup != nil && cfg.Callbacks.ClusterDataRestore != nil
+	return (cfg.Callbacks.ClusterDataBackup != nil && cfg.Callbacks.ClusterDataRestore != nil) || cfg.ClusterData.BaseURI != ""
 }
 
 // Broker connection configuration
@@ -32,21 +38,40 @@ type Broker struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 	BindHost string `yaml:"bind_host"`
+	// TombstoneRetentionHours is how long a deprovisioned service instance can be restored;
+	// a negative number deletes service instances immediately
+	TombstoneRetentionHours int `yaml:"tombstone_retention_hours"`
+	// TLS is nil unless the broker serves HTTPS
+	TLS *BrokerTLS `yaml:"tls"`
+	// Admins may call the admin API; without any, the broker's own credentials may
+	Admins []*AdminUser `yaml:"admins"`
+}
+
+// AdminUser authenticates to the admin API with basic auth.
+// Role is "read-only", for viewing without credentials, or "operator", for everything.
+type AdminUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Role     string `yaml:"role"`
 }
 
 type Scheduler struct {
-	Cells []*Cell
-	Etcd  Etcd
+	Cells      []*Cell
+	Etcd       Etcd
+	PostgreSQL PostgreSQL
 }
 
 // Cell describes a configured set of cell brokers
 // TODO dynamicly load from KV store
 type Cell struct {
-	GUID             string `yaml:"guid"`
-	AvailabilityZone string `yaml:"availability_zone"`
-	URI              string `yaml:"uri"`
-	Username         string `yaml:"username"`
-	Password         string `yaml:"password"`
+	GUID             string   `yaml:"guid"`
+	AvailabilityZone string   `yaml:"availability_zone"`
+	URI              string   `yaml:"uri"`
+	Username         string   `yaml:"username"`
+	Password         string   `yaml:"password"`
+	PostgresVersions []string `yaml:"postgres_versions"`
+	// TLS configures requests to a cell whose URI is https://
+	TLS TLSClient `yaml:"tls"`
 }
 
 // KVStore describes the KV store used by all the components
@@ -63,8 +88,89 @@ type CloudFoundryCredentials struct {
 	SkipSslValidation bool   `yaml:"skip_ssl_validation"`
 }
 
+// HAProxy describes how the haproxy subcommand renders and reloads router configuration
+type HAProxy struct {
+	ConfigPath    string `yaml:"config_path"`
+	ReloadCommand string `yaml:"reload_cmd"`
+	BindAddress   string `yaml:"bind_address"`
+	MaxConn       int    `yaml:"maxconn"`
+}
+
+// Patroni describes how the broker waits upon Patroni clusters
+type Patroni struct {
+	// MaxReplicationLag is how many bytes of WAL a replica may be behind its leader
+	// before it is considered caught up. Zero disables waiting for replicas to catch up.
+	MaxReplicationLag int64 `yaml:"max_replication_lag"`
+}
+
+// PostgreSQL describes which PostgreSQL configuration parameters and extensions users may
+// request via the "postgresql" and "extensions" service parameters
+type PostgreSQL struct {
+	AllowedParameters     []string            `yaml:"allowed_parameters"`
+	PlanAllowedParameters map[string][]string `yaml:"plan_allowed_parameters"`
+	AllowedExtensions     []string            `yaml:"allowed_extensions"`
+	PsqlPath              string              `yaml:"psql_path"`
+	PgDumpallPath         string              `yaml:"pg_dumpall_path"`
+	DefaultVersion        string              `yaml:"default_version"`
+	PlanDefaultVersions   map[string]string   `yaml:"plan_default_versions"`
+	// UpgradeRollbackHours is how long the cluster replaced by a major version upgrade is kept
+	UpgradeRollbackHours int `yaml:"upgrade_rollback_hours"`
+	// CredentialRotationGraceHours is how long the app user replaced by a credential rotation can still log in
+	CredentialRotationGraceHours int `yaml:"credential_rotation_grace_hours"`
+	// TLS issues server certificates to the clusters of TLS plans
+	TLS PostgreSQLTLS `yaml:"tls"`
+}
+
+// DefaultVersionForPlan returns the PostgreSQL major version used when a service instance does not request one
+func (cfg PostgreSQL) DefaultVersionForPlan(planID string) string {
+	if version, ok := cfg.PlanDefaultVersions[planID]; ok {
+		return version
+	}
+	return cfg.DefaultVersion
+}
+
+// AllowedParametersForPlan returns the parameters allowed for a plan, which default to AllowedParameters
+func (cfg PostgreSQL) AllowedParametersForPlan(planID string) []string {
+	if allowed, ok := cfg.PlanAllowedParameters[planID]; ok {
+		return allowed
+	}
+	return cfg.AllowedParameters
+}
+
+// Backups describes where the continuous backups of each cluster are stored.
+// BaseURI is an s3://bucket/prefix URI or a local directory (file:///path).
 type Backups struct {
+	BaseURI string    `yaml:"base_uri"`
+	S3      BackupsS3 `yaml:"s3"`
+	// RetainBaseBackups is how many base backups of each cluster are kept, with the WAL
+	// needed to restore them. Zero keeps all backups.
+	RetainBaseBackups int `yaml:"retain_base_backups"`
+}
+
+// BackupsS3 are the credentials for an S3-compatible object store
+type BackupsS3 struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// ClusterData is where the broker archives the recreation data of service instances,
+// unless the clusterdata callbacks are configured to do so
+type ClusterData struct {
+	// BaseURI is a file:// or s3:// URI; it defaults to "clusterdata" within backups.base_uri
 	BaseURI string `yaml:"base_uri"`
+	// S3 defaults to the credentials of backups.s3
+	S3 BackupsS3 `yaml:"s3"`
+}
+
+// Encryption are the keys that credentials are encrypted with, in etcd and in clusterdata backups
+type Encryption struct {
+	// ActiveKey is the ID of the key that credentials are encrypted with
+	ActiveKey string `yaml:"active_key"`
+	// Keys are base64-encoded 256-bit AES keys, by ID. Keys that are no longer active
+	// decrypt credentials encrypted before the active key was changed.
+	Keys map[string]string `yaml:"keys"`
 }
 
 // Callbacks allows plug'n'play scripts to be run when events have completed
@@ -72,13 +178,35 @@ type Callbacks struct {
 	ClusterDataBackup     *CallbackCommand `yaml:"clusterdata_backup"`
 	ClusterDataRestore    *CallbackCommand `yaml:"clusterdata_restore"`
 	ClusterDataFindByName *CallbackCommand `yaml:"clusterdata_find_by_name"`
-	BackupsCopy           *CallbackCommand `yaml:"backups_copy"`
+	// Events are sent a JSON description of each lifecycle event, such as "provisioned"
+	Events map[string]*CallbackCommand `yaml:"events"`
+	// BackupsCopy is no longer run; it is only read so that configs that still set it are rejected
+	BackupsCopy *CallbackCommand `yaml:"backups_copy"`
 }
 
-// CallbackCommand describes a command that can be run via os/exec's Command
+// CallbackCommand describes a command that can be run via os/exec's Command,
+// or a web service that is sent the same JSON as the command would read on stdin
 type CallbackCommand struct {
-	Command   string   `yaml:"cmd"`
-	Arguments []string `yaml:"args"`
+	Command   string        `yaml:"cmd"`
+	Arguments []string      `yaml:"args"`
+	HTTP      *CallbackHTTP `yaml:"http"`
+	// TimeoutSeconds is how long each attempt may take before it is stopped
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+	// Retries is how many more times a callback is attempted after it fails
+	Retries int `yaml:"retries"`
+}
+
+// CallbackHTTP describes a web service that is called instead of running a command.
+// The response body is used as the command's stdout would be.
+type CallbackHTTP struct {
+	URL               string            `yaml:"url"`
+	Method            string            `yaml:"method"`
+	Headers           map[string]string `yaml:"headers"`
+	Username          string            `yaml:"username"`
+	Password          string            `yaml:"password"`
+	SkipSslValidation bool              `yaml:"skip_ssl_validation"`
+	// CACert is a PEM certificate that the web service's certificate must be signed by
+	CACert string `yaml:"ca_cert"`
 }
 
 // LoadConfig from a YAML file
@@ -92,6 +220,10 @@ func LoadConfig(path string) (cfg *Config, err error) {
 	if err != nil {
 		return
 	}
+	if cfg.Callbacks.BackupsCopy != nil {
+		err = fmt.Errorf("Config: callbacks.backups_copy is no longer supported; configure backups.base_uri so that the broker copies backups itself")
+		return
+	}
 
 	// defaults
 	if cfg.Broker.Username == "" {
@@ -103,17 +235,83 @@ func LoadConfig(path string) (cfg *Config, err error) {
 	if cfg.Broker.Port == 0 {
 		cfg.Broker.Port = 3000
 	}
+	if cfg.Broker.TombstoneRetentionHours == 0 {
+		cfg.Broker.TombstoneRetentionHours = 168
+	}
+
+	if cfg.HAProxy.ConfigPath == "" {
+		cfg.HAProxy.ConfigPath = "/etc/haproxy/haproxy.cfg"
+	}
+	if cfg.HAProxy.BindAddress == "" {
+		cfg.HAProxy.BindAddress = "*"
+	}
+	if cfg.HAProxy.MaxConn == 0 {
+		cfg.HAProxy.MaxConn = 1000
+	}
+
+	if cfg.PostgreSQL.AllowedParameters == nil {
+		cfg.PostgreSQL.AllowedParameters = []string{
+			"max_connections",
+			"shared_buffers",
+			"work_mem",
+			"maintenance_work_mem",
+			"effective_cache_size",
+			"random_page_cost",
+			"default_statistics_target",
+			"checkpoint_completion_target",
+			"log_min_duration_statement",
+			"statement_timeout",
+			"lock_timeout",
+			"temp_buffers",
+		}
+	}
+
+	if cfg.PostgreSQL.PsqlPath == "" {
+		cfg.PostgreSQL.PsqlPath = "psql"
+	}
+	if cfg.PostgreSQL.PgDumpallPath == "" {
+		cfg.PostgreSQL.PgDumpallPath = "pg_dumpall"
+	}
+	if cfg.PostgreSQL.DefaultVersion == "" {
+		cfg.PostgreSQL.DefaultVersion = "9.5"
+	}
+	if cfg.PostgreSQL.UpgradeRollbackHours == 0 {
+		cfg.PostgreSQL.UpgradeRollbackHours = 24
+	}
+	if cfg.PostgreSQL.CredentialRotationGraceHours == 0 {
+		cfg.PostgreSQL.CredentialRotationGraceHours = 24
+	}
+	if cfg.PostgreSQL.TLS.CertificateValidityDays == 0 {
+		cfg.PostgreSQL.TLS.CertificateValidityDays = 825
+	}
+
+	if cfg.ClusterData.BaseURI == "" && cfg.Backups.BaseURI != "" {
+		cfg.ClusterData.BaseURI = strings.TrimRight(cfg.Backups.BaseURI, "/") + "/clusterdata"
+	}
+	if cfg.ClusterData.S3 == (BackupsS3{}) {
+		cfg.ClusterData.S3 = cfg.Backups.S3
+	}
 
 	for _, cell := range cfg.Cells {
 		match, err := regexp.MatchString("^http", cell.URI)
 		if !match || err != nil {
-			cell.URI = fmt.Sprintf("http://%s", cell.URI)
+			// cells with TLS settings are called over HTTPS
+			scheme := "http"
+			if cell.TLS.Configured() {
+				scheme = "https"
+			}
+			cell.URI = fmt.Sprintf("%s://%s", scheme, cell.URI)
+		}
+		// cells that do not advertise their versions run the default version
+		if len(cell.PostgresVersions) == 0 {
+			cell.PostgresVersions = []string{cfg.PostgreSQL.DefaultVersion}
 		}
 	}
 
 	cfg.Scheduler = Scheduler{
-		Etcd:  cfg.Etcd,
-		Cells: cfg.Cells,
+		Etcd:       cfg.Etcd,
+		Cells:      cfg.Cells,
+		PostgreSQL: cfg.PostgreSQL,
 	}
 
 	return
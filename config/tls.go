@@ -0,0 +1,118 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// BrokerTLS serves the broker and admin APIs over HTTPS
+type BrokerTLS struct {
+	// Certificate and PrivateKey are PEM encoded; the certificate may be followed by its intermediates
+	Certificate string `yaml:"certificate"`
+	PrivateKey  string `yaml:"private_key"`
+	// MinVersion is the lowest TLS version accepted, such as "1.2" (the default)
+	MinVersion string `yaml:"min_version"`
+	// ClientCACert is a PEM bundle; if set, clients must present a certificate signed by it
+	ClientCACert string `yaml:"client_ca_cert"`
+}
+
+// Enabled is true if the broker is to serve HTTPS
+func (cfg *BrokerTLS) Enabled() bool {
+	return cfg != nil && (cfg.Certificate != "" || cfg.PrivateKey != "")
+}
+
+// ServerConfig returns the TLS configuration of the broker's listener
+func (cfg *BrokerTLS) ServerConfig() (*tls.Config, error) {
+	certificate, err := tls.X509KeyPair([]byte(cfg.Certificate), []byte(cfg.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("Broker tls has an invalid certificate or private_key: %s", err)
+	}
+	minVersion, err := tlsVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Broker tls %s", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   minVersion,
+	}
+	if cfg.ClientCACert != "" {
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM([]byte(cfg.ClientCACert)) {
+			return nil, fmt.Errorf("Broker tls has an invalid client_ca_cert")
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// TLSClient describes how to verify, and authenticate to, a server called over HTTPS
+type TLSClient struct {
+	// CACert is a PEM bundle that the server's certificate must be signed by, instead of the system's CAs
+	CACert string `yaml:"ca_cert"`
+	// ClientCert and ClientKey are a PEM certificate and key presented to the server
+	ClientCert        string `yaml:"client_cert"`
+	ClientKey         string `yaml:"client_key"`
+	SkipSslValidation bool   `yaml:"skip_ssl_validation"`
+}
+
+// Configured is true if any TLS settings are given
+func (cfg TLSClient) Configured() bool {
+	return cfg != TLSClient{}
+}
+
+// ClientConfig returns the TLS configuration for requests to the server
+func (cfg TLSClient) ClientConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.SkipSslValidation}
+	if cfg.CACert != "" {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(cfg.CACert)) {
+			return nil, fmt.Errorf("invalid ca_cert")
+		}
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(cfg.ClientCert), []byte(cfg.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client_cert or client_key: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+func tlsVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	if tlsVersion, ok := tlsVersions[version]; ok {
+		return tlsVersion, nil
+	}
+	return 0, fmt.Errorf("min_version '%s' must be one of 1.0, 1.1 or 1.2", version)
+}
+
+// PostgreSQLTLS issues server certificates, signed by a CA, to the clusters of TLS plans
+type PostgreSQLTLS struct {
+	// Plans are the IDs of the plans whose service instances accept TLS connections
+	Plans []string `yaml:"plans"`
+	// CACert and CAKey are the PEM certificate and private key of the CA that issues certificates
+	CACert string `yaml:"ca_cert"`
+	CAKey  string `yaml:"ca_key"`
+	// CertificateValidityDays is how long each issued certificate is valid
+	CertificateValidityDays int `yaml:"certificate_validity_days"`
+}
+
+// EnabledForPlan is true if the service instances of a plan accept TLS connections
+func (cfg PostgreSQLTLS) EnabledForPlan(planID string) bool {
+	for _, plan := range cfg.Plans {
+		if plan == planID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package encryption
+
+import "github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+
+// EncryptCredentials returns a copy of credentials with the password encrypted
+func (k *Keyring) EncryptCredentials(credentials structs.PostgresCredentials) (structs.PostgresCredentials, error) {
+	var err error
+	credentials.Password, err = k.Encrypt(credentials.Password)
+	return credentials, err
+}
+
+// DecryptCredentials returns a copy of credentials with the password decrypted
+func (k *Keyring) DecryptCredentials(credentials structs.PostgresCredentials) (structs.PostgresCredentials, error) {
+	var err error
+	credentials.Password, err = k.Decrypt(credentials.Password)
+	return credentials, err
+}
+
+// EncryptClusterState returns a copy of a cluster's state with its credentials, and TLS private key, encrypted
+func (k *Keyring) EncryptClusterState(cluster structs.ClusterState) (structs.ClusterState, error) {
+	var err error
+	cluster.AdminCredentials, cluster.SuperuserCredentials, cluster.AppCredentials, err =
+		k.transformAll(k.EncryptCredentials, cluster.AdminCredentials, cluster.SuperuserCredentials, cluster.AppCredentials)
+	if err != nil {
+		return cluster, err
+	}
+	cluster.TLS, err = k.transformTLS(k.Encrypt, cluster.TLS)
+	return cluster, err
+}
+
+// DecryptClusterState returns a copy of a cluster's state with its credentials, and TLS private key, decrypted
+func (k *Keyring) DecryptClusterState(cluster structs.ClusterState) (structs.ClusterState, error) {
+	var err error
+	cluster.AdminCredentials, cluster.SuperuserCredentials, cluster.AppCredentials, err =
+		k.transformAll(k.DecryptCredentials, cluster.AdminCredentials, cluster.SuperuserCredentials, cluster.AppCredentials)
+	if err != nil {
+		return cluster, err
+	}
+	cluster.TLS, err = k.transformTLS(k.Decrypt, cluster.TLS)
+	return cluster, err
+}
+
+// EncryptRecreationData returns a copy of recreation data with its credentials, and TLS private key, encrypted
+func (k *Keyring) EncryptRecreationData(data structs.ClusterRecreationData) (structs.ClusterRecreationData, error) {
+	var err error
+	data.AdminCredentials, data.SuperuserCredentials, data.AppCredentials, err =
+		k.transformAll(k.EncryptCredentials, data.AdminCredentials, data.SuperuserCredentials, data.AppCredentials)
+	if err != nil {
+		return data, err
+	}
+	data.TLS, err = k.transformTLS(k.Encrypt, data.TLS)
+	return data, err
+}
+
+// DecryptRecreationData returns a copy of recreation data with its credentials, and TLS private key, decrypted
+func (k *Keyring) DecryptRecreationData(data structs.ClusterRecreationData) (structs.ClusterRecreationData, error) {
+	var err error
+	data.AdminCredentials, data.SuperuserCredentials, data.AppCredentials, err =
+		k.transformAll(k.DecryptCredentials, data.AdminCredentials, data.SuperuserCredentials, data.AppCredentials)
+	if err != nil {
+		return data, err
+	}
+	data.TLS, err = k.transformTLS(k.Decrypt, data.TLS)
+	return data, err
+}
+
+// transformTLS returns a copy of clusterTLS with its private key transformed, leaving the original unchanged
+func (k *Keyring) transformTLS(transform func(string) (string, error), clusterTLS *structs.ClusterTLS) (*structs.ClusterTLS, error) {
+	if clusterTLS == nil {
+		return nil, nil
+	}
+	copied := *clusterTLS
+	var err error
+	copied.PrivateKey, err = transform(copied.PrivateKey)
+	return &copied, err
+}
+
+func (k *Keyring) transformAll(transform func(structs.PostgresCredentials) (structs.PostgresCredentials, error),
+	admin, superuser, app structs.PostgresCredentials) (structs.PostgresCredentials, structs.PostgresCredentials, structs.PostgresCredentials, error) {
+	var err error
+	if admin, err = transform(admin); err != nil {
+		return admin, superuser, app, err
+	}
+	if superuser, err = transform(superuser); err != nil {
+		return admin, superuser, app, err
+	}
+	app, err = transform(app)
+	return admin, superuser, app, err
+}
@@ -0,0 +1,167 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+// encryptedPrefix marks values encrypted by a Keyring; other values are plaintext
+const encryptedPrefix = "enc:v1:"
+
+const keySize = 32
+
+// Keyring encrypts values with envelope encryption. Each value is encrypted with its own
+// random data key, and the data key is encrypted with the active key. The ID of the active
+// key is kept with the value, so that values encrypted with older keys can still be decrypted.
+//
+// A nil Keyring leaves values as plaintext.
+type Keyring struct {
+	activeKeyID string
+	keys        map[string]cipher.AEAD
+}
+
+// NewKeyring returns the keyring for cfg, or nil if no keys are configured
+func NewKeyring(cfg config.Encryption) (*Keyring, error) {
+	if cfg.ActiveKey == "" && len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	if _, ok := cfg.Keys[cfg.ActiveKey]; !ok {
+		return nil, fmt.Errorf("Encryption: active_key '%s' is not one of the keys", cfg.ActiveKey)
+	}
+	keyring := &Keyring{activeKeyID: cfg.ActiveKey, keys: map[string]cipher.AEAD{}}
+	for keyID, encodedKey := range cfg.Keys {
+		if keyID == "" || strings.Contains(keyID, ":") {
+			return nil, fmt.Errorf("Encryption: key ID '%s' must not be empty or contain ':'", keyID)
+		}
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("Encryption: key '%s' must be %d random bytes, base64 encoded", keyID, keySize)
+		}
+		keyring.keys[keyID], err = newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+// ActiveKeyID is the ID of the key that values are encrypted with
+func (k *Keyring) ActiveKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.activeKeyID
+}
+
+// Encrypt returns plaintext encrypted with the active key, as
+// "enc:v1:<key-id>:<encrypted data key>:<ciphertext>"
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if k == nil || plaintext == "" || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(k.keys[k.activeKeyID], dataKey, []byte(k.activeKeyID))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s:%s:%s", encryptedPrefix, k.activeKeyID,
+		base64.StdEncoding.EncodeToString(wrappedKey),
+		base64.StdEncoding.EncodeToString(ciphertext)), nil
+}
+
+// Decrypt returns the plaintext of a value returned by Encrypt, with whichever key it was
+// encrypted with. Plaintext values, stored before encryption was configured, are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("Encryption: value is not in the format %s<key-id>:<data-key>:<ciphertext>", encryptedPrefix)
+	}
+	keyID := parts[0]
+	if k == nil {
+		return "", fmt.Errorf("Encryption: value was encrypted with key '%s', but no encryption keys are configured", keyID)
+	}
+	keyAEAD, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("Encryption: value was encrypted with unknown key '%s'", keyID)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("Encryption: invalid data key: %s", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("Encryption: invalid ciphertext: %s", err)
+	}
+	dataKey, err := open(keyAEAD, wrappedKey, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("Encryption: could not decrypt data key with key '%s': %s", keyID, err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("Encryption: could not decrypt value: %s", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted is true for values returned by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// KeyID returns the ID of the key a value was encrypted with, or "" for plaintext
+func KeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	return strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)[0]
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext, prefixed by its random nonce
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,147 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+)
+
+var (
+	testKey1 = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testKey2 = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func newTestKeyring(t *testing.T, activeKey string, keys map[string]string) *Keyring {
+	keyring, err := NewKeyring(config.Encryption{ActiveKey: activeKey, Keys: keys})
+	if err != nil {
+		t.Fatalf("NewKeyring failed %s", err)
+	}
+	return keyring
+}
+
+func TestNewKeyring(t *testing.T) {
+	t.Parallel()
+
+	keyring, err := NewKeyring(config.Encryption{})
+	if keyring != nil || err != nil {
+		t.Fatalf("Expected no keyring without keys, got %v %v", keyring, err)
+	}
+	for _, cfg := range []config.Encryption{
+		{ActiveKey: "missing", Keys: map[string]string{"k1": testKey1}},
+		{ActiveKey: "k1", Keys: map[string]string{"k1": "too-short"}},
+		{ActiveKey: "k:1", Keys: map[string]string{"k:1": testKey1}},
+	} {
+		if _, err = NewKeyring(cfg); err == nil {
+			t.Fatalf("Expected invalid configuration %v to fail", cfg)
+		}
+	}
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	t.Parallel()
+	keyring := newTestKeyring(t, "k1", map[string]string{"k1": testKey1})
+
+	encrypted, err := keyring.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt failed %s", err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:v1:k1:") || strings.Contains(encrypted, "secret") {
+		t.Fatalf("Expected value encrypted with k1, got %s", encrypted)
+	}
+	if KeyID(encrypted) != "k1" {
+		t.Fatalf("Expected key ID k1, got %s", KeyID(encrypted))
+	}
+	again, _ := keyring.Encrypt("secret")
+	if again == encrypted {
+		t.Fatalf("Expected each encryption to use a new data key and nonce")
+	}
+	if twice, _ := keyring.Encrypt(encrypted); twice != encrypted {
+		t.Fatalf("Expected encrypted value not to be encrypted again")
+	}
+
+	decrypted, err := keyring.Decrypt(encrypted)
+	if err != nil || decrypted != "secret" {
+		t.Fatalf("Expected to decrypt 'secret', got '%s' %v", decrypted, err)
+	}
+	if plaintext, err := keyring.Decrypt("plaintext"); err != nil || plaintext != "plaintext" {
+		t.Fatalf("Expected plaintext to be returned unchanged, got '%s' %v", plaintext, err)
+	}
+
+	tampered := encrypted[:len(encrypted)-4] + "AAA="
+	if _, err = keyring.Decrypt(tampered); err == nil {
+		t.Fatalf("Expected tampered value not to decrypt")
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	t.Parallel()
+	oldKeyring := newTestKeyring(t, "k1", map[string]string{"k1": testKey1})
+	encrypted, err := oldKeyring.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt failed %s", err)
+	}
+
+	keyring := newTestKeyring(t, "k2", map[string]string{"k1": testKey1, "k2": testKey2})
+	decrypted, err := keyring.Decrypt(encrypted)
+	if err != nil || decrypted != "secret" {
+		t.Fatalf("Expected previous key to decrypt 'secret', got '%s' %v", decrypted, err)
+	}
+	reencrypted, _ := keyring.Encrypt(decrypted)
+	if KeyID(reencrypted) != "k2" {
+		t.Fatalf("Expected value encrypted with active key k2, got %s", reencrypted)
+	}
+
+	withoutOldKey := newTestKeyring(t, "k2", map[string]string{"k2": testKey2})
+	if _, err = withoutOldKey.Decrypt(encrypted); err == nil || !strings.Contains(err.Error(), "unknown key 'k1'") {
+		t.Fatalf("Expected unknown key error, got %v", err)
+	}
+	var noKeyring *Keyring
+	if _, err = noKeyring.Decrypt(encrypted); err == nil {
+		t.Fatalf("Expected encrypted value not to decrypt without keys")
+	}
+	if plaintext, _ := noKeyring.Encrypt("secret"); plaintext != "secret" {
+		t.Fatalf("Expected no keyring to leave values as plaintext")
+	}
+}
+
+func TestKeyring_ClusterState(t *testing.T) {
+	t.Parallel()
+	keyring := newTestKeyring(t, "k1", map[string]string{"k1": testKey1})
+
+	cluster := structs.ClusterState{
+		InstanceID:           "instance-id",
+		AdminCredentials:     structs.PostgresCredentials{Username: "pgadmin", Password: "adminpw"},
+		SuperuserCredentials: structs.PostgresCredentials{Username: "postgres", Password: "superuserpw"},
+		AppCredentials:       structs.PostgresCredentials{Username: "appuser", Password: "apppw"},
+		TLS:                  &structs.ClusterTLS{Certificate: "cert", PrivateKey: "key", CACert: "ca"},
+	}
+	encrypted, err := keyring.EncryptClusterState(cluster)
+	if err != nil {
+		t.Fatalf("EncryptClusterState failed %s", err)
+	}
+	for _, credentials := range []structs.PostgresCredentials{encrypted.AdminCredentials, encrypted.SuperuserCredentials, encrypted.AppCredentials} {
+		if !IsEncrypted(credentials.Password) {
+			t.Fatalf("Expected password of %s to be encrypted", credentials.Username)
+		}
+	}
+	if !IsEncrypted(encrypted.TLS.PrivateKey) || encrypted.TLS.Certificate != "cert" {
+		t.Fatalf("Expected only the TLS private key to be encrypted, got %v", encrypted.TLS)
+	}
+	if cluster.AdminCredentials.Password != "adminpw" || cluster.TLS.PrivateKey != "key" {
+		t.Fatalf("Expected original cluster state to be unchanged")
+	}
+
+	decrypted, err := keyring.DecryptClusterState(encrypted)
+	if err != nil {
+		t.Fatalf("DecryptClusterState failed %s", err)
+	}
+	if decrypted.AdminCredentials != cluster.AdminCredentials ||
+		decrypted.SuperuserCredentials != cluster.SuperuserCredentials ||
+		decrypted.AppCredentials != cluster.AppCredentials ||
+		*decrypted.TLS != *cluster.TLS {
+		t.Fatalf("Expected decrypted credentials to equal the originals, got %v", decrypted)
+	}
+}
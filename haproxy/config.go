@@ -0,0 +1,187 @@
+package haproxy
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/template"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/routing"
+)
+
+// configTemplate renders a complete HAProxy configuration.
+// Each service instance gets a frontend on its allocated port. Its backend lists the
+// leader and every running replica; when Patroni's REST API is reachable, HAProxy asks
+// each member's GET /master so that only the current leader receives connections and
+// a failover is followed without waiting for the configuration to be regenerated.
+// Service instances with a replica port get a second frontend, load-balanced across
+// replicas that answer GET /replica.
+var configTemplate = template.Must(template.New("haproxy.cfg").Parse(`# Generated by dingo-postgresql-broker; changes will be overwritten
+global
+    maxconn {{.MaxConn}}
+
+defaults
+    mode tcp
+    retries 2
+    timeout client 30m
+    timeout connect 4s
+    timeout server 30m
+    timeout check 5s
+{{range .Services}}
+frontend frontend_{{.Name}}
+    bind {{$.BindAddress}}:{{.Port}}
+    default_backend backend_{{.Name}}
+
+backend backend_{{.Name}}
+{{- if .HealthCheck}}
+    option httpchk GET /master
+    http-check expect status 200
+    default-server inter 3s fall 3 rise 2 on-marked-down shutdown-sessions
+{{- end}}
+{{- range .Servers}}
+    server {{.Name}} {{.Host}}:{{.Port}} maxconn {{$.MaxConn}} check{{if .CheckPort}} addr {{.CheckHost}} port {{.CheckPort}}{{end}}
+{{- end}}
+{{if .ReplicaPort}}
+frontend frontend_{{.Name}}_replicas
+    bind {{$.BindAddress}}:{{.ReplicaPort}}
+    default_backend backend_{{.Name}}_replicas
+
+backend backend_{{.Name}}_replicas
+    balance roundrobin
+{{- if .HealthCheck}}
+    option httpchk GET /replica
+    http-check expect status 200
+    default-server inter 3s fall 3 rise 2 on-marked-down shutdown-sessions
+{{- end}}
+{{- range .ReplicaServers}}
+    server {{.Name}} {{.Host}}:{{.Port}} maxconn {{$.MaxConn}} check{{if .CheckPort}} addr {{.CheckHost}} port {{.CheckPort}}{{end}}
+{{- end}}
+{{end -}}
+{{end}}`))
+
+type templateData struct {
+	BindAddress string
+	MaxConn     int
+	Services    []service
+}
+
+type service struct {
+	Name           string
+	Port           int
+	ReplicaPort    int
+	HealthCheck    bool
+	Servers        []server
+	ReplicaServers []server
+}
+
+type server struct {
+	Name      string
+	Host      string
+	Port      int
+	CheckHost string
+	CheckPort int
+}
+
+// RenderConfig renders the HAProxy configuration for a routing table
+func RenderConfig(cfg config.HAProxy, table map[structs.ClusterID]routing.RoutingTableEntry) ([]byte, error) {
+	data := templateData{
+		BindAddress: cfg.BindAddress,
+		MaxConn:     cfg.MaxConn,
+		Services:    []service{},
+	}
+
+	for clusterID, entry := range table {
+		data.Services = append(data.Services, newService(clusterID, entry))
+	}
+	sort.Sort(servicesByPort(data.Services))
+
+	buffer := &bytes.Buffer{}
+	if err := configTemplate.Execute(buffer, data); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func newService(clusterID structs.ClusterID, entry routing.RoutingTableEntry) service {
+	svc := service{
+		Name:           sanitizeName(string(clusterID)),
+		Port:           entry.Port,
+		ReplicaPort:    entry.ReplicaPort,
+		Servers:        []server{},
+		ReplicaServers: []server{},
+	}
+	if !entry.HasLeader() {
+		return svc
+	}
+
+	leaderCheckHost, leaderCheckPort := apiAddress(entry.LeaderAPIURL)
+	svc.HealthCheck = leaderCheckPort != 0
+	svc.Servers = append(svc.Servers, server{
+		Name:      "leader",
+		Host:      entry.LeaderHost,
+		Port:      entry.LeaderPort,
+		CheckHost: leaderCheckHost,
+		CheckPort: leaderCheckPort,
+	})
+
+	for _, replica := range entry.Replicas {
+		checkHost, checkPort := apiAddress(replica.APIURL)
+		replicaServer := server{
+			Name:      sanitizeName(replica.MemberID),
+			Host:      replica.Host,
+			Port:      replica.Port,
+			CheckHost: checkHost,
+			CheckPort: checkPort,
+		}
+		// Without Patroni health checks HAProxy cannot tell a replica from the leader,
+		// so replicas only join the leader backend when they can be checked.
+		if svc.HealthCheck && checkPort != 0 {
+			svc.Servers = append(svc.Servers, replicaServer)
+		}
+		if !svc.HealthCheck || checkPort != 0 {
+			svc.ReplicaServers = append(svc.ReplicaServers, replicaServer)
+		}
+	}
+	return svc
+}
+
+// apiAddress returns the host and port of a Patroni REST API URL,
+// or a zero port if it is missing or only reachable from inside its container.
+func apiAddress(apiURL string) (string, int) {
+	if apiURL == "" {
+		return "", 0
+	}
+	parsed, err := url.Parse(apiURL)
+	if err != nil {
+		return "", 0
+	}
+	host, portStr, err := net.SplitHostPort(parsed.Host)
+	if err != nil {
+		return "", 0
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return "", 0
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0
+	}
+	return host, port
+}
+
+var invalidNameChars = regexp.MustCompile("[^A-Za-z0-9_.:-]")
+
+func sanitizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+type servicesByPort []service
+
+func (s servicesByPort) Len() int           { return len(s) }
+func (s servicesByPort) Less(i, j int) bool { return s[i].Port < s[j].Port }
+func (s servicesByPort) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
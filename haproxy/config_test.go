@@ -0,0 +1,212 @@
+package haproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/routing"
+	"github.com/dingotiles/dingo-postgresql-broker/testutil"
+	"golang.org/x/net/context"
+)
+
+func TestHAProxy_RenderConfig_HealthChecks(t *testing.T) {
+	t.Parallel()
+
+	table := map[structs.ClusterID]routing.RoutingTableEntry{
+		"f1": routing.RoutingTableEntry{
+			Port:         30001,
+			LeaderHost:   "10.244.21.8",
+			LeaderPort:   32768,
+			LeaderAPIURL: "http://10.244.21.8:32769/patroni",
+			Replicas: []routing.RoutingBackend{
+				{MemberID: "replica-1", Host: "10.244.22.2", Port: 32770, APIURL: "http://10.244.22.2:32771/patroni"},
+			},
+		},
+	}
+	data, err := RenderConfig(config.HAProxy{BindAddress: "*", MaxConn: 100}, table)
+	if err != nil {
+		t.Fatalf("RenderConfig failed %s", err)
+	}
+	rendered := string(data)
+
+	expectedLines := []string{
+		"frontend frontend_f1",
+		"    bind *:30001",
+		"    default_backend backend_f1",
+		"    option httpchk GET /master",
+		"    server leader 10.244.21.8:32768 maxconn 100 check addr 10.244.21.8 port 32769",
+		"    server replica-1 10.244.22.2:32770 maxconn 100 check addr 10.244.22.2 port 32771",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(rendered, line+"\n") {
+			t.Fatalf("Expected rendered config to contain %q:\n%s", line, rendered)
+		}
+	}
+}
+
+func TestHAProxy_RenderConfig_LoopbackAPIURL(t *testing.T) {
+	t.Parallel()
+
+	table := map[structs.ClusterID]routing.RoutingTableEntry{
+		"f1": routing.RoutingTableEntry{
+			Port:         30001,
+			LeaderHost:   "10.244.21.8",
+			LeaderPort:   32768,
+			LeaderAPIURL: "http://127.0.0.1:8008/patroni",
+			Replicas: []routing.RoutingBackend{
+				{MemberID: "replica-1", Host: "10.244.22.2", Port: 32770, APIURL: "http://127.0.0.1:8008/patroni"},
+			},
+		},
+	}
+	data, err := RenderConfig(config.HAProxy{BindAddress: "*", MaxConn: 100}, table)
+	if err != nil {
+		t.Fatalf("RenderConfig failed %s", err)
+	}
+	rendered := string(data)
+
+	if strings.Contains(rendered, "httpchk") {
+		t.Fatalf("Expected no Patroni health checks for loopback API URLs:\n%s", rendered)
+	}
+	if !strings.Contains(rendered, "    server leader 10.244.21.8:32768 maxconn 100 check\n") {
+		t.Fatalf("Expected leader to be the only server:\n%s", rendered)
+	}
+	if strings.Contains(rendered, "replica-1") {
+		t.Fatalf("Expected replicas to be excluded without health checks:\n%s", rendered)
+	}
+}
+
+func TestHAProxy_RenderConfig_ReplicaPort(t *testing.T) {
+	t.Parallel()
+
+	table := map[structs.ClusterID]routing.RoutingTableEntry{
+		"f1": routing.RoutingTableEntry{
+			Port:         30001,
+			ReplicaPort:  30002,
+			LeaderHost:   "10.244.21.8",
+			LeaderPort:   32768,
+			LeaderAPIURL: "http://10.244.21.8:32769/patroni",
+			Replicas: []routing.RoutingBackend{
+				{MemberID: "replica-1", Host: "10.244.22.2", Port: 32770, APIURL: "http://10.244.22.2:32771/patroni"},
+			},
+		},
+	}
+	data, err := RenderConfig(config.HAProxy{BindAddress: "*", MaxConn: 100}, table)
+	if err != nil {
+		t.Fatalf("RenderConfig failed %s", err)
+	}
+	rendered := string(data)
+
+	replicaSection := rendered[strings.Index(rendered, "frontend frontend_f1_replicas"):]
+	expectedLines := []string{
+		"    bind *:30002",
+		"    balance roundrobin",
+		"    option httpchk GET /replica",
+		"    server replica-1 10.244.22.2:32770 maxconn 100 check addr 10.244.22.2 port 32771",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(replicaSection, line+"\n") {
+			t.Fatalf("Expected replica section to contain %q:\n%s", line, rendered)
+		}
+	}
+}
+
+func TestHAProxy_RenderConfig_SortedByPort(t *testing.T) {
+	t.Parallel()
+
+	table := map[structs.ClusterID]routing.RoutingTableEntry{
+		"second": routing.RoutingTableEntry{Port: 30002},
+		"first":  routing.RoutingTableEntry{Port: 30001},
+	}
+	data, err := RenderConfig(config.HAProxy{BindAddress: "0.0.0.0", MaxConn: 100}, table)
+	if err != nil {
+		t.Fatalf("RenderConfig failed %s", err)
+	}
+	rendered := string(data)
+
+	first := strings.Index(rendered, "bind 0.0.0.0:30001")
+	second := strings.Index(rendered, "bind 0.0.0.0:30002")
+	if first < 0 || second < 0 || first > second {
+		t.Fatalf("Expected frontends ordered by port:\n%s", rendered)
+	}
+}
+
+func TestHAProxy_WriteFileAtomically(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "TestHAProxy_WriteFileAtomically")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	path := filepath.Join(testDir, "haproxy.cfg")
+	if err = WriteFileAtomically(path, []byte("first")); err != nil {
+		t.Fatalf("WriteFileAtomically failed %s", err)
+	}
+	if err = WriteFileAtomically(path, []byte("second")); err != nil {
+		t.Fatalf("WriteFileAtomically failed %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "second" {
+		t.Fatalf("Expected file to contain 'second', got %q (%v)", data, err)
+	}
+	files, _ := ioutil.ReadDir(testDir)
+	if len(files) != 1 {
+		t.Fatalf("Expected temporary files to be renamed away, found %d files", len(files))
+	}
+}
+
+type fakeRoutingTable struct {
+	table map[structs.ClusterID]routing.RoutingTableEntry
+}
+
+func (f *fakeRoutingTable) RoutingTable() (map[structs.ClusterID]routing.RoutingTableEntry, uint64, error) {
+	return f.table, 1, nil
+}
+
+func (f *fakeRoutingTable) WaitForRoutingChange(ctx context.Context, afterIndex uint64) (uint64, error) {
+	<-ctx.Done()
+	return afterIndex, ctx.Err()
+}
+
+func TestHAProxy_Generate_RetriesFailedReload(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "TestHAProxy_Generate_RetriesFailedReload")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// the reload command fails the first time it is run, and records each run
+	runs := filepath.Join(testDir, "runs")
+	cfg := config.HAProxy{
+		ConfigPath:    filepath.Join(testDir, "haproxy.cfg"),
+		ReloadCommand: fmt.Sprintf("echo run >> %s; test $(wc -l < %s) -gt 1", runs, runs),
+	}
+	table := &fakeRoutingTable{table: map[structs.ClusterID]routing.RoutingTableEntry{
+		"f1": routing.RoutingTableEntry{Port: 30001},
+	}}
+	generator := NewGenerator(cfg, table, testutil.NewTestLogger("TestHAProxy_Generate_RetriesFailedReload", t))
+
+	if _, err = generator.Generate(); err == nil {
+		t.Fatalf("Expected the first reload to fail")
+	}
+	if _, err = generator.Generate(); err != nil {
+		t.Fatalf("Expected the unchanged configuration to be reloaded again, got %s", err)
+	}
+	if _, err = generator.Generate(); err != nil {
+		t.Fatalf("Generate failed %s", err)
+	}
+	data, _ := ioutil.ReadFile(runs)
+	if count := strings.Count(string(data), "run"); count != 2 {
+		t.Fatalf("Expected 2 reloads, got %d", count)
+	}
+}
@@ -0,0 +1,154 @@
+package haproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/routing"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+const (
+	resyncInterval   = 60 * time.Second
+	regenerateDelay  = 1 * time.Second
+	retryAfterFailed = 5 * time.Second
+)
+
+// RoutingTable is the source of routing records, normally a *routing.Router
+type RoutingTable interface {
+	RoutingTable() (map[structs.ClusterID]routing.RoutingTableEntry, uint64, error)
+	WaitForRoutingChange(ctx context.Context, afterIndex uint64) (uint64, error)
+}
+
+// Generator keeps an HAProxy configuration file in sync with the routing table
+type Generator struct {
+	cfg    config.HAProxy
+	table  RoutingTable
+	logger lager.Logger
+	// reloaded is the last configuration that HAProxy was successfully reloaded with
+	reloaded []byte
+}
+
+// NewGenerator creates a Generator
+func NewGenerator(cfg config.HAProxy, table RoutingTable, logger lager.Logger) *Generator {
+	return &Generator{
+		cfg:    cfg,
+		table:  table,
+		logger: logger,
+	}
+}
+
+// Run regenerates the configuration whenever port allocations or Patroni leaders change.
+// It blocks until ctx is done.
+func (g *Generator) Run(ctx context.Context) error {
+	for {
+		index, err := g.Generate()
+		if err != nil {
+			g.logger.Error("run.generate", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryAfterFailed):
+				continue
+			}
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, resyncInterval)
+		_, err = g.table.WaitForRoutingChange(waitCtx, index)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			// let related changes (leader key and member keys) settle before rendering
+			time.Sleep(regenerateDelay)
+		}
+	}
+}
+
+// Generate renders the configuration, replaces the file atomically if it differs from
+// the file on disk, and runs the reload command unless HAProxy was already successfully
+// reloaded with it. A failed reload is retried by the next Generate.
+// It returns the etcd index of the routing table that was rendered.
+func (g *Generator) Generate() (index uint64, err error) {
+	table, index, err := g.table.RoutingTable()
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := RenderConfig(g.cfg, table)
+	if err != nil {
+		return 0, err
+	}
+
+	if g.reloaded != nil && bytes.Equal(g.reloaded, data) {
+		return index, nil
+	}
+
+	existing, err := ioutil.ReadFile(g.cfg.ConfigPath)
+	if err != nil || !bytes.Equal(existing, data) {
+		if err = WriteFileAtomically(g.cfg.ConfigPath, data); err != nil {
+			g.logger.Error("generate.write", err, lager.Data{"path": g.cfg.ConfigPath})
+			return 0, err
+		}
+		g.logger.Info("generate.written", lager.Data{"path": g.cfg.ConfigPath, "services": len(table)})
+	}
+
+	if err = g.reload(); err != nil {
+		return 0, err
+	}
+	g.reloaded = data
+	return index, nil
+}
+
+func (g *Generator) reload() error {
+	if g.cfg.ReloadCommand == "" {
+		return nil
+	}
+	output, err := exec.Command("sh", "-c", g.cfg.ReloadCommand).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("Reload command failed: %s: %s", err, string(output))
+		g.logger.Error("reload", err, lager.Data{"cmd": g.cfg.ReloadCommand})
+		return err
+	}
+	g.logger.Info("reload", lager.Data{"cmd": g.cfg.ReloadCommand, "output": string(output)})
+	return nil
+}
+
+// WriteFileAtomically writes data to a temporary file next to path and renames it into
+// place, so that readers see either the old or the new file and never a partial one.
+func WriteFileAtomically(path string, data []byte) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
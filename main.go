@@ -28,6 +28,42 @@ func main() {
 			},
 			Action: clicmd.RunBroker,
 		},
+		{
+			Name:  "haproxy",
+			Usage: "generate HAProxy configuration for routers",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Value: "config.yml",
+					Usage: "path to YAML config file",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "path to write HAProxy configuration (overrides haproxy.config_path)",
+				},
+				cli.StringFlag{
+					Name:  "reload-cmd",
+					Usage: "command to run after configuration changes (overrides haproxy.reload_cmd)",
+				},
+				cli.BoolFlag{
+					Name:  "once",
+					Usage: "generate configuration once and exit",
+				},
+			},
+			Action: clicmd.RunHAProxy,
+		},
+		{
+			Name:  "reencrypt",
+			Usage: "encrypt the stored credentials of all service instances with encryption.active_key",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Value: "config.yml",
+					Usage: "path to YAML config file",
+				},
+			},
+			Action: clicmd.ReEncrypt,
+		},
 	}
 	app.Run(os.Args)
 }
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,8 +23,9 @@ import (
 )
 
 type Patroni struct {
-	etcd   etcd.KeysAPI
-	logger lager.Logger
+	etcd              etcd.KeysAPI
+	maxReplicationLag int64
+	logger            lager.Logger
 }
 
 const (
@@ -49,15 +53,16 @@ type ClusterMember struct {
 	RootAPIURL   string
 }
 
-func NewPatroni(etcdConf config.Etcd, logger lager.Logger) (*Patroni, error) {
+func NewPatroni(etcdConf config.Etcd, patroniConf config.Patroni, logger lager.Logger) (*Patroni, error) {
 	etcd, err := setupEtcd(etcdConf)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Patroni{
-		etcd:   etcd,
-		logger: logger,
+		etcd:              etcd,
+		maxReplicationLag: patroniConf.MaxReplicationLag,
+		logger:            logger,
 	}, nil
 }
 
@@ -72,6 +77,22 @@ func (p *Patroni) ClusterLeader(instanceID structs.ClusterID) (string, error) {
 	return resp.Node.Value, nil
 }
 
+// LeaderConnURL is the PostgreSQL connection URL of the cluster's current leader
+func (p *Patroni) LeaderConnURL(instanceID structs.ClusterID) (string, error) {
+	leaderID, err := p.ClusterLeader(instanceID)
+	if err != nil {
+		return "", err
+	}
+	leader, err := p.loadMember(instanceID, leaderID)
+	if err != nil {
+		return "", err
+	}
+	if leader.ConnURL == "" {
+		return "", fmt.Errorf("Patroni: leader %s of %s has no conn_url", leaderID, instanceID)
+	}
+	return leader.ConnURL, nil
+}
+
 // WaitForLeader blocks until leader is elected and active
 func (p *Patroni) WaitForLeader(instanceID structs.ClusterID) error {
 	timeout := time.After(waitForLeaderTimeout)
@@ -79,31 +100,30 @@ func (p *Patroni) WaitForLeader(instanceID structs.ClusterID) error {
 	for {
 		select {
 		case <-timeout:
-			return fmt.Errorf("Timed out waiting for leader of %d", instanceID)
+			return fmt.Errorf("Timed out waiting for leader of %s", instanceID)
 		case <-c:
 			if p.leaderRunning(instanceID) {
 				return nil
 			}
 		}
 	}
-	return nil
 }
 
-// WaitForAllMembers waits until expected number of nodes are running (not too many, not too few, and all running)
+// WaitForAllMembers waits until expected number of nodes are running (not too many, not too few, and all running).
+// If a maximum replication lag is configured, it also waits until every replica has caught up to within it.
 func (p *Patroni) WaitForAllMembers(instanceID structs.ClusterID, expectedNodeCount int) error {
 	timeout := time.After(waitTilMemberRunningTimeout)
 	c := time.Tick(1 * time.Second)
 	for {
 		select {
 		case <-timeout:
-			return fmt.Errorf("Timed out waiting for cluster %d members to achieve state 'running'", instanceID)
+			return fmt.Errorf("Timed out waiting for cluster %s members to achieve state 'running'", instanceID)
 		case <-c:
 			if p.checkClusterMembersRunning(instanceID, expectedNodeCount) {
 				return nil
 			}
 		}
 	}
-	return nil
 }
 
 func (p *Patroni) WaitForMember(instanceID structs.ClusterID, memberID string) error {
@@ -138,7 +158,6 @@ func (p *Patroni) WaitForMember(instanceID structs.ClusterID, memberID string) e
 			}
 		}
 	}
-	return nil
 }
 
 func (p *Patroni) FailoverFrom(instanceID structs.ClusterID, memberID string) error {
@@ -151,14 +170,20 @@ func (p *Patroni) FailoverFrom(instanceID structs.ClusterID, memberID string) er
 
 	url := fmt.Sprintf("%s/failover", member.RootAPIURL)
 
-	requestData := fmt.Sprintf("{\"leader\": \"%s\"}", memberID)
 	timeout := time.After(failoverFromTimeout)
 	tick := time.Tick(5 * time.Second)
 	for {
 		select {
 		case <-timeout:
-			return fmt.Errorf("Timed out failing over %s from", instanceID, memberID)
+			return fmt.Errorf("Timed out failing over %s from %s", instanceID, memberID)
 		case <-tick:
+			// Prefer the replica that has replayed the most WAL, so the least data is lost or waited upon
+			requestData := fmt.Sprintf("{\"leader\": \"%s\"}", memberID)
+			if candidateID, err := p.leastLaggedReplica(instanceID, memberID); err == nil && candidateID != "" {
+				p.logger.Info("patroni.failover-from.candidate", lager.Data{"instance-id": instanceID, "member-id": memberID, "candidate": candidateID})
+				requestData = fmt.Sprintf("{\"leader\": \"%s\", \"candidate\": \"%s\"}", memberID, candidateID)
+			}
+
 			req, err := http.NewRequest("POST", url, bytes.NewBufferString(requestData))
 			if err != nil {
 				p.logger.Error("patroni.failover-from.failover-req", err)
@@ -252,6 +277,19 @@ func (p *Patroni) checkClusterMembersRunning(instanceID structs.ClusterID, expec
 			return false
 		}
 	}
+
+	if p.maxReplicationLag > 0 {
+		lags, err := p.ReplicationLag(instanceID)
+		if err != nil {
+			return false
+		}
+		for memberID, lag := range lags {
+			if lag > p.maxReplicationLag {
+				p.logger.Info("members-data.replication-lag", lager.Data{"instance-id": instanceID, "member": memberID, "lag": lag})
+				return false
+			}
+		}
+	}
 	return true
 }
 
@@ -283,6 +321,11 @@ func (p *Patroni) loadMember(instanceID structs.ClusterID, memberID string) (mem
 }
 
 func (p *Patroni) deserializeMember(jsonValue string) (member ClusterMember, err error) {
+	return ParseMember(jsonValue)
+}
+
+// ParseMember decodes the JSON that a patroni node stores at /service/<id>/members/<member>
+func ParseMember(jsonValue string) (member ClusterMember, err error) {
 	dec := json.NewDecoder(strings.NewReader(jsonValue))
 	if err = dec.Decode(&member); err == io.EOF {
 		return
@@ -294,3 +337,20 @@ func (p *Patroni) deserializeMember(jsonValue string) (member ClusterMember, err
 
 	return
 }
+
+// HostPort is the host and port at which the member's PostgreSQL accepts connections
+func (member ClusterMember) HostPort() (host string, port int, err error) {
+	connURL, err := url.Parse(member.ConnURL)
+	if err != nil {
+		return "", 0, err
+	}
+	host, portStr, err := net.SplitHostPort(connURL.Host)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, port, nil
+}
@@ -0,0 +1,67 @@
+package patroni
+
+import (
+	"fmt"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+// ReplicationLag returns how many bytes of WAL each member is behind the leader,
+// from the xlog_location that each member publishes. The leader's own lag is zero.
+func (p *Patroni) ReplicationLag(instanceID structs.ClusterID) (map[string]int64, error) {
+	leaderID, err := p.ClusterLeader(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := p.loadMembers(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeReplicationLag(leaderID, members)
+}
+
+// ComputeReplicationLag returns each member's lag in bytes behind the leader's xlog location
+func ComputeReplicationLag(leaderID string, members map[string]ClusterMember) (map[string]int64, error) {
+	leader, ok := members[leaderID]
+	if !ok {
+		return nil, fmt.Errorf("Patroni: leader %s is not a member", leaderID)
+	}
+
+	lags := map[string]int64{}
+	for memberID, member := range members {
+		lag := leader.XlogLocation - member.XlogLocation
+		if lag < 0 {
+			// the replica saw WAL after the leader last published its location
+			lag = 0
+		}
+		lags[memberID] = lag
+	}
+	return lags, nil
+}
+
+// leastLaggedReplica returns the running replica with the least replication lag,
+// or an empty string if the leader has no running replicas.
+func (p *Patroni) leastLaggedReplica(instanceID structs.ClusterID, leaderID string) (string, error) {
+	members, err := p.loadMembers(instanceID)
+	if err != nil {
+		return "", err
+	}
+	lags, err := ComputeReplicationLag(leaderID, members)
+	if err != nil {
+		return "", err
+	}
+	return selectLeastLagged(leaderID, members, lags), nil
+}
+
+func selectLeastLagged(leaderID string, members map[string]ClusterMember, lags map[string]int64) (candidateID string) {
+	for memberID, member := range members {
+		if memberID == leaderID || member.State != RunningState {
+			continue
+		}
+		if candidateID == "" || lags[memberID] < lags[candidateID] ||
+			(lags[memberID] == lags[candidateID] && memberID < candidateID) {
+			candidateID = memberID
+		}
+	}
+	return candidateID
+}
@@ -0,0 +1,30 @@
+package patroni
+
+import "testing"
+
+func TestPatroni_ComputeReplicationLag(t *testing.T) {
+	t.Parallel()
+
+	members := map[string]ClusterMember{
+		"leader": {Role: LeaderRole, State: RunningState, XlogLocation: 5000},
+		"a":      {Role: ReplicaRole, State: RunningState, XlogLocation: 4000},
+		"b":      {Role: ReplicaRole, State: RunningState, XlogLocation: 4900},
+		"c":      {Role: ReplicaRole, State: "starting", XlogLocation: 5000},
+	}
+	lags, err := ComputeReplicationLag("leader", members)
+	if err != nil {
+		t.Fatalf("ComputeReplicationLag failed %s", err)
+	}
+	if lags["leader"] != 0 || lags["a"] != 1000 || lags["b"] != 100 || lags["c"] != 0 {
+		t.Fatalf("Unexpected lags %v", lags)
+	}
+
+	candidateID := selectLeastLagged("leader", members, lags)
+	if candidateID != "b" {
+		t.Fatalf("Expected least lagged running replica 'b', got '%s'", candidateID)
+	}
+
+	if _, err = ComputeReplicationLag("missing", members); err == nil {
+		t.Fatalf("Expected error when leader is not a member")
+	}
+}
@@ -0,0 +1,225 @@
+package patroni
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	restAPITimeout = 30 * time.Second
+)
+
+// Switchover asks the leader to hand over to candidateID (any healthy replica if empty).
+// A non-zero scheduledAt asks Patroni to perform the switchover at that time instead of now.
+func (p *Patroni) Switchover(instanceID structs.ClusterID, candidateID string, scheduledAt time.Time) error {
+	logger := p.logger.Session("switchover", lager.Data{"instance-id": instanceID, "candidate": candidateID})
+
+	leaderID, err := p.ClusterLeader(instanceID)
+	if err != nil {
+		logger.Error("cluster-leader", err)
+		return err
+	}
+	if leaderID == candidateID {
+		return fmt.Errorf("Patroni: member %s is already the leader of %s", candidateID, instanceID)
+	}
+	leader, err := p.loadMember(instanceID, leaderID)
+	if err != nil {
+		return err
+	}
+
+	request := map[string]string{"leader": leaderID}
+	if candidateID != "" {
+		request["candidate"] = candidateID
+	}
+	if !scheduledAt.IsZero() {
+		request["scheduled_at"] = scheduledAt.Format(time.RFC3339)
+	}
+	_, err = p.apiRequest(leader, "POST", "/switchover", request)
+	if err != nil {
+		logger.Error("request", err)
+		return err
+	}
+	logger.Info("requested", lager.Data{"leader": leaderID, "scheduled-at": scheduledAt})
+	return nil
+}
+
+// Restart restarts PostgreSQL on a member, without Patroni giving up its leader lock
+func (p *Patroni) Restart(instanceID structs.ClusterID, memberID string) error {
+	return p.memberAction(instanceID, memberID, "POST", "/restart", map[string]interface{}{})
+}
+
+// Reinitialize wipes a replica's data directory and rebuilds it from the leader.
+// Patroni refuses to reinitialize the leader.
+func (p *Patroni) Reinitialize(instanceID structs.ClusterID, memberID string) error {
+	return p.memberAction(instanceID, memberID, "POST", "/reinitialize", map[string]interface{}{})
+}
+
+// Pause puts the cluster into maintenance mode: Patroni stops managing PostgreSQL and will not fail over
+func (p *Patroni) Pause(instanceID structs.ClusterID) error {
+	return p.setPause(instanceID, true)
+}
+
+// Resume takes the cluster out of maintenance mode
+func (p *Patroni) Resume(instanceID structs.ClusterID) error {
+	return p.setPause(instanceID, false)
+}
+
+func (p *Patroni) setPause(instanceID structs.ClusterID, pause bool) error {
+	return p.patchConfig(instanceID, map[string]interface{}{"pause": pause})
+}
+
+// SetSynchronousMode turns Patroni's synchronous replication mode on or off. When on, a commit is only
+// acknowledged once a replica has it, and Patroni only fails over to that synchronous replica.
+func (p *Patroni) SetSynchronousMode(instanceID structs.ClusterID, enabled bool) error {
+	return p.patchConfig(instanceID, map[string]interface{}{"synchronous_mode": enabled})
+}
+
+// PatchPostgresqlParameters changes PostgreSQL parameters in the cluster-wide dynamic configuration.
+// A nil value resets a parameter. Patroni reloads every member; parameters that need a restart
+// are reported by members as pending_restart until they are restarted.
+func (p *Patroni) PatchPostgresqlParameters(instanceID structs.ClusterID, changes map[string]interface{}) error {
+	return p.patchConfig(instanceID, map[string]interface{}{
+		"postgresql": map[string]interface{}{"parameters": changes},
+	})
+}
+
+// patchConfig changes the cluster-wide dynamic configuration stored by Patroni
+func (p *Patroni) patchConfig(instanceID structs.ClusterID, changes map[string]interface{}) error {
+	leaderID, err := p.ClusterLeader(instanceID)
+	if err != nil {
+		return err
+	}
+	return p.memberAction(instanceID, leaderID, "PATCH", "/config", changes)
+}
+
+// ClusterStatus asks each member of the cluster for its own GET /patroni status.
+// Members that cannot be reached are included with their error.
+func (p *Patroni) ClusterStatus(instanceID structs.ClusterID) ([]structs.PatroniMemberStatus, error) {
+	members, err := p.loadMembers(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []structs.PatroniMemberStatus{}
+	for memberID, member := range members {
+		status := structs.PatroniMemberStatus{}
+		body, err := p.apiRequest(member, "GET", "/patroni", nil)
+		if err == nil {
+			err = json.Unmarshal(body, &status)
+		}
+		if err != nil {
+			p.logger.Error("cluster-status.member", err, lager.Data{"instance-id": instanceID, "member": memberID})
+			status.Error = err.Error()
+		}
+		status.MemberID = memberID
+		status.APIURL = member.APIURL
+		statuses = append(statuses, status)
+	}
+	sort.Sort(statusesByMemberID(statuses))
+	return statuses, nil
+}
+
+func (p *Patroni) memberAction(instanceID structs.ClusterID, memberID, method, path string, request interface{}) error {
+	logger := p.logger.Session("member-action", lager.Data{"instance-id": instanceID, "member": memberID, "action": method + " " + path})
+
+	member, err := p.loadMember(instanceID, memberID)
+	if err != nil {
+		return err
+	}
+	_, err = p.apiRequest(member, method, path, request)
+	if err != nil {
+		logger.Error("request", err)
+		return err
+	}
+	logger.Info("done")
+	return nil
+}
+
+// apiRequest calls the Patroni REST API of a member and returns the response body of a successful (2xx) response
+func (p *Patroni) apiRequest(member ClusterMember, method, path string, request interface{}) ([]byte, error) {
+	if member.APIURL == "" {
+		return nil, fmt.Errorf("Patroni: member has no api_url")
+	}
+	url := strings.TrimSuffix(member.RootAPIURL, "/") + path
+
+	var body *bytes.Buffer
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(data)
+	} else {
+		body = &bytes.Buffer{}
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: restAPITimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseText, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// GET /patroni answers 503 for members that are not running but still describes them
+	if resp.StatusCode/100 != 2 && !(method == "GET" && path == "/patroni" && resp.StatusCode == http.StatusServiceUnavailable) {
+		return nil, fmt.Errorf("Patroni: %s %s returned %d: %s", method, url, resp.StatusCode, strings.TrimSpace(string(responseText)))
+	}
+	return responseText, nil
+}
+
+func (p *Patroni) loadMembers(instanceID structs.ClusterID) (map[string]ClusterMember, error) {
+	ctx := context.Background()
+	key := fmt.Sprintf("service/%s/members", instanceID)
+	resp, err := p.etcd.Get(ctx, key, &etcd.GetOptions{Quorum: true, Recursive: true})
+	if err != nil {
+		p.logger.Error("load-members.etcd-get", err, lager.Data{"instance-id": instanceID})
+		return nil, err
+	}
+
+	memberIDRegExp := regexp.MustCompile("/members/([^/]+)$")
+	members := map[string]ClusterMember{}
+	for _, node := range resp.Node.Nodes {
+		match := memberIDRegExp.FindStringSubmatch(node.Key)
+		if match == nil {
+			continue
+		}
+		member, err := p.deserializeMember(node.Value)
+		if err != nil {
+			p.logger.Error("load-members.decode", err, lager.Data{"member": match[1]})
+			continue
+		}
+		members[match[1]] = member
+	}
+	return members, nil
+}
+
+type statusesByMemberID []structs.PatroniMemberStatus
+
+func (s statusesByMemberID) Len() int           { return len(s) }
+func (s statusesByMemberID) Less(i, j int) bool { return s[i].MemberID < s[j].MemberID }
+func (s statusesByMemberID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,47 @@
+package patroni
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dingotiles/dingo-postgresql-broker/testutil"
+)
+
+func TestPatroni_apiRequest(t *testing.T) {
+	t.Parallel()
+
+	var method, path string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		method, path = req.Method, req.URL.Path
+		data, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(data, &body)
+		if req.URL.Path == "/reinitialize" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("I am the leader, can not reinitialize"))
+		}
+	}))
+	defer server.Close()
+
+	p := &Patroni{logger: testutil.NewTestLogger("apiRequest", t)}
+	member, err := ParseMember(`{"api_url": "` + server.URL + `/patroni"}`)
+	if err != nil {
+		t.Fatalf("ParseMember failed %s", err)
+	}
+
+	_, err = p.apiRequest(member, "PATCH", "/config", map[string]interface{}{"pause": true})
+	if err != nil {
+		t.Fatalf("apiRequest failed %s", err)
+	}
+	if method != "PATCH" || path != "/config" || body["pause"] != true {
+		t.Fatalf("Unexpected request %s %s %v", method, path, body)
+	}
+
+	_, err = p.apiRequest(member, "POST", "/reinitialize", map[string]interface{}{})
+	if err == nil {
+		t.Fatalf("Expected error for a 503 response")
+	}
+}
@@ -0,0 +1,250 @@
+package postgresql
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	defaultDatabase = "postgres"
+	connectTimeout  = "10"
+)
+
+// Psql runs SQL against a cluster's PostgreSQL with the psql client,
+// and copies databases between clusters with pg_dumpall and psql
+type Psql struct {
+	path        string
+	dumpallPath string
+	logger      lager.Logger
+}
+
+// NewPsql creates a Psql
+func NewPsql(cfg config.PostgreSQL, logger lager.Logger) *Psql {
+	return &Psql{
+		path:        cfg.PsqlPath,
+		dumpallPath: cfg.PgDumpallPath,
+		logger:      logger,
+	}
+}
+
+// CreateExtensions runs CREATE EXTENSION for each extension that is not yet created.
+// connURL is the conn_url of a Patroni member, normally the leader.
+func (p *Psql) CreateExtensions(connURL string, credentials structs.PostgresCredentials, extensions []string) error {
+	statements := []string{}
+	for _, extension := range extensions {
+		if strings.Contains(extension, `"`) {
+			return fmt.Errorf("Postgresql: invalid extension name %s", extension)
+		}
+		statements = append(statements, fmt.Sprintf(`CREATE EXTENSION IF NOT EXISTS "%s";`, extension))
+	}
+	return p.run(connURL, credentials, strings.Join(statements, "\n"))
+}
+
+// CopyDatabases dumps the roles and databases of one cluster with pg_dumpall, and restores
+// them into another cluster with psql. It works across PostgreSQL major versions, as long as
+// pg_dumpall is from the newer version. Roles, databases and extensions that the new cluster
+// was created with already exist, so only other errors fail the copy.
+func (p *Psql) CopyDatabases(fromConnURL, toConnURL string, credentials structs.PostgresCredentials) error {
+	fromHost, fromPort, err := hostPort(fromConnURL)
+	if err != nil {
+		return err
+	}
+	toHost, toPort, err := hostPort(toConnURL)
+	if err != nil {
+		return err
+	}
+	dumpArgs := []string{
+		"--host", fromHost,
+		"--port", fromPort,
+		"--username", credentials.Username,
+		"--database", defaultDatabase,
+	}
+	restoreArgs := []string{
+		"--no-psqlrc", "--quiet",
+		"--host", toHost,
+		"--port", toPort,
+		"--username", credentials.Username,
+		"--dbname", defaultDatabase,
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	dumpOutput := &bytes.Buffer{}
+	dump := exec.Command(p.dumpallPath, dumpArgs...)
+	dump.Env = p.env(credentials)
+	dump.Stdout = writer
+	dump.Stderr = dumpOutput
+	restoreOutput := &bytes.Buffer{}
+	restore := exec.Command(p.path, restoreArgs...)
+	restore.Env = p.env(credentials)
+	restore.Stdin = reader
+	restore.Stdout = ioutil.Discard
+	restore.Stderr = restoreOutput
+
+	dumpErr := dump.Start()
+	restoreErr := restore.Start()
+	// the commands hold their own ends of the pipe; closing ours lets each see the other exit
+	writer.Close()
+	reader.Close()
+	if dumpErr == nil {
+		dumpErr = dump.Wait()
+	}
+	if restoreErr == nil {
+		restoreErr = restore.Wait()
+	}
+
+	data := lager.Data{"from": fromHost + ":" + fromPort, "to": toHost + ":" + toPort}
+	if dumpErr != nil {
+		err = fmt.Errorf("Postgresql: pg_dumpall failed: %s: %s", dumpErr, strings.TrimSpace(dumpOutput.String()))
+		p.logger.Error("psql.copy-databases", err, data)
+		return err
+	}
+	if restoreErr != nil {
+		err = fmt.Errorf("Postgresql: psql failed: %s: %s", restoreErr, strings.TrimSpace(restoreOutput.String()))
+		p.logger.Error("psql.copy-databases", err, data)
+		return err
+	}
+	if errors := restoreErrors(restoreOutput.String()); len(errors) > 0 {
+		err = fmt.Errorf("Postgresql: restoring databases failed: %s", strings.Join(errors, "; "))
+		p.logger.Error("psql.copy-databases", err, data)
+		return err
+	}
+	p.logger.Info("psql.copy-databases", data)
+	return nil
+}
+
+// restoreErrors are the errors that psql reported while restoring a dump, other than
+// for objects that already exist
+func restoreErrors(output string) []string {
+	errors := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "ERROR:") && !strings.Contains(line, "already exists") {
+			errors = append(errors, strings.TrimSpace(line))
+		}
+	}
+	return errors
+}
+
+// SetPassword changes the password of a role
+func (p *Psql) SetPassword(connURL string, credentials structs.PostgresCredentials, role, password string) error {
+	return p.runSecret(connURL, credentials, setPasswordSQL(role, password))
+}
+
+// CreateAppUser creates a user that logs in as a member of the owner role, and acts as it,
+// so that it can use the owner's objects and the objects it creates are owned by the owner
+func (p *Psql) CreateAppUser(connURL string, credentials structs.PostgresCredentials, username, password, owner string) error {
+	return p.runSecret(connURL, credentials, createAppUserSQL(username, password, owner))
+}
+
+// RetireUser stops a user from logging in; its existing sessions are not ended.
+// The role is kept, as it may own objects.
+func (p *Psql) RetireUser(connURL string, credentials structs.PostgresCredentials, username string) error {
+	return p.run(connURL, credentials, retireUserSQL(username))
+}
+
+func setPasswordSQL(role, password string) string {
+	return fmt.Sprintf("ALTER ROLE %s WITH PASSWORD %s;", quoteIdentifier(role), quoteLiteral(password))
+}
+
+func createAppUserSQL(username, password, owner string) string {
+	return fmt.Sprintf("CREATE ROLE %s WITH LOGIN PASSWORD %s IN ROLE %s;\nALTER ROLE %s SET role TO %s;",
+		quoteIdentifier(username), quoteLiteral(password), quoteIdentifier(owner),
+		quoteIdentifier(username), quoteLiteral(owner))
+}
+
+func retireUserSQL(username string) string {
+	return fmt.Sprintf("DO $$BEGIN IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = %s) THEN ALTER ROLE %s WITH NOLOGIN PASSWORD NULL; END IF; END$$;",
+		quoteLiteral(username), quoteIdentifier(username))
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+func (p *Psql) run(connURL string, credentials structs.PostgresCredentials, sql string) error {
+	args, err := psqlArgs(connURL, credentials.Username, sql)
+	if err != nil {
+		return err
+	}
+	return p.exec(args, credentials, nil)
+}
+
+// runSecret runs SQL that holds a password, which is given on stdin rather than as
+// an argument so that it is kept out of the process list and the logs
+func (p *Psql) runSecret(connURL string, credentials structs.PostgresCredentials, sql string) error {
+	args, err := psqlArgs(connURL, credentials.Username, "")
+	if err != nil {
+		return err
+	}
+	return p.exec(args, credentials, strings.NewReader(sql))
+}
+
+func (p *Psql) exec(args []string, credentials structs.PostgresCredentials, stdin io.Reader) error {
+	cmd := exec.Command(p.path, args...)
+	cmd.Env = p.env(credentials)
+	cmd.Stdin = stdin
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("Postgresql: psql failed: %s: %s", err, strings.TrimSpace(output.String()))
+		p.logger.Error("psql.run", err, lager.Data{"args": args})
+		return err
+	}
+	p.logger.Info("psql.run", lager.Data{"args": args})
+	return nil
+}
+
+// env keeps the password out of the process list
+func (p *Psql) env(credentials structs.PostgresCredentials) []string {
+	return append(os.Environ(),
+		"PGPASSWORD="+credentials.Password,
+		"PGCONNECT_TIMEOUT="+connectTimeout,
+	)
+}
+
+func hostPort(connURL string) (host, port string, err error) {
+	parsed, err := url.Parse(connURL)
+	if err != nil {
+		return "", "", err
+	}
+	return net.SplitHostPort(parsed.Host)
+}
+
+func psqlArgs(connURL, username, sql string) ([]string, error) {
+	host, port, err := hostPort(connURL)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"--no-psqlrc", "--quiet",
+		"--set", "ON_ERROR_STOP=1",
+		"--host", host,
+		"--port", port,
+		"--username", username,
+		"--dbname", defaultDatabase,
+	}
+	// without a command, psql reads the SQL from stdin
+	if sql != "" {
+		args = append(args, "--command", sql)
+	}
+	return args, nil
+}
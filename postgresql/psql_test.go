@@ -0,0 +1,77 @@
+package postgresql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPsql_psqlArgs(t *testing.T) {
+	t.Parallel()
+
+	args, err := psqlArgs("postgres://10.244.21.8:32768/postgres", "postgres", "SELECT 1;")
+	if err != nil {
+		t.Fatalf("psqlArgs failed %s", err)
+	}
+	expectedArgs := []string{
+		"--no-psqlrc", "--quiet",
+		"--set", "ON_ERROR_STOP=1",
+		"--host", "10.244.21.8",
+		"--port", "32768",
+		"--username", "postgres",
+		"--dbname", "postgres",
+		"--command", "SELECT 1;",
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Fatalf("Expected args %v, got %v", expectedArgs, args)
+	}
+
+	if _, err = psqlArgs("postgres://10.244.21.8/postgres", "postgres", "SELECT 1;"); err == nil {
+		t.Fatalf("Expected error for conn_url without port")
+	}
+}
+
+func TestPsql_restoreErrors(t *testing.T) {
+	t.Parallel()
+
+	output := `psql:<stdin>:14: ERROR:  role "postgres" already exists
+psql:<stdin>:30: ERROR:  database "appdb" already exists
+psql:<stdin>:52: ERROR:  could not open extension control file "/usr/share/postgresql/9.6/extension/hstore.control"
+psql:<stdin>:60: NOTICE:  table "t" does not exist, skipping`
+	expected := []string{`psql:<stdin>:52: ERROR:  could not open extension control file "/usr/share/postgresql/9.6/extension/hstore.control"`}
+	if errors := restoreErrors(output); !reflect.DeepEqual(errors, expected) {
+		t.Fatalf("Expected errors %v, got %v", expected, errors)
+	}
+	if errors := restoreErrors(""); len(errors) != 0 {
+		t.Fatalf("Expected no errors, got %v", errors)
+	}
+}
+
+func TestPsql_psqlArgs_Stdin(t *testing.T) {
+	t.Parallel()
+
+	args, err := psqlArgs("postgres://10.244.21.8:32768/postgres", "postgres", "")
+	if err != nil {
+		t.Fatalf("psqlArgs failed %s", err)
+	}
+	for _, arg := range args {
+		if arg == "--command" {
+			t.Fatalf("Expected SQL to be read from stdin, got args %v", args)
+		}
+	}
+}
+
+func TestPsql_CredentialSQL(t *testing.T) {
+	t.Parallel()
+
+	if sql := setPasswordSQL("pgadmin", "it's"); sql != `ALTER ROLE "pgadmin" WITH PASSWORD 'it''s';` {
+		t.Fatalf("Unexpected SQL %s", sql)
+	}
+	expected := "CREATE ROLE \"appuser_1\" WITH LOGIN PASSWORD 'pw' IN ROLE \"appuser\";\nALTER ROLE \"appuser_1\" SET role TO 'appuser';"
+	if sql := createAppUserSQL("appuser_1", "pw", "appuser"); sql != expected {
+		t.Fatalf("Expected SQL %s, got %s", expected, sql)
+	}
+	if sql := retireUserSQL(`app"user`); !strings.Contains(sql, `ALTER ROLE "app""user" WITH NOLOGIN PASSWORD NULL;`) || !strings.Contains(sql, `rolname = 'app"user'`) {
+		t.Fatalf("Unexpected SQL %s", sql)
+	}
+}
@@ -0,0 +1,185 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+// PortReservation records which service instance owns allocated public ports.
+// ReplicaPort is the optional second port used for load-balanced read-only access to replicas.
+// Published is set once the ports have been assigned to the routers, and so may be known to users.
+type PortReservation struct {
+	Port        int       `json:"port"`
+	ReplicaPort int       `json:"replica_port,omitempty"`
+	ReservedAt  time.Time `json:"reserved_at"`
+	Published   bool      `json:"published,omitempty"`
+}
+
+// portLedger is the single etcd value used to allocate public ports.
+// Allocating a port and reserving it for a service instance is one
+// compare-and-swap of this value, so a port can never be handed out
+// without also being recorded against the instance that asked for it.
+// Published ports are never handed out again once released: a public port is the
+// contract with the users of one service instance. Ports released before they were
+// published, such as by a failed provision, are free to be reused.
+type portLedger struct {
+	NextPort     int                                   `json:"next_port"`
+	Reservations map[structs.ClusterID]PortReservation `json:"reservations"`
+	FreePorts    []int                                 `json:"free_ports"`
+	// AllocationsImported is set once the allocations of earlier broker versions are reserved
+	AllocationsImported bool `json:"allocations_imported,omitempty"`
+}
+
+func newPortLedger(nextPort int) *portLedger {
+	return &portLedger{
+		NextPort:     nextPort,
+		Reservations: map[structs.ClusterID]PortReservation{},
+		FreePorts:    []int{},
+	}
+}
+
+func decodePortLedger(value string) (*portLedger, error) {
+	ledger := newPortLedger(0)
+	if err := json.Unmarshal([]byte(value), ledger); err != nil {
+		return nil, err
+	}
+	if ledger.Reservations == nil {
+		ledger.Reservations = map[structs.ClusterID]PortReservation{}
+	}
+	return ledger, nil
+}
+
+func (l *portLedger) encode() (string, error) {
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// reserve allocates a port for clusterID, preferring ports that were never published.
+// If clusterID already holds a reservation, that port is returned unchanged.
+func (l *portLedger) reserve(clusterID structs.ClusterID, now time.Time) (port int, changed bool) {
+	if reservation, ok := l.Reservations[clusterID]; ok {
+		return reservation.Port, false
+	}
+	port = l.takePort()
+	l.Reservations[clusterID] = PortReservation{Port: port, ReservedAt: now}
+	return port, true
+}
+
+// reserveReplica allocates the replica port for clusterID, which must already hold a reservation.
+// If a replica port was already reserved, it is returned unchanged.
+func (l *portLedger) reserveReplica(clusterID structs.ClusterID) (port int, changed bool, err error) {
+	reservation, ok := l.Reservations[clusterID]
+	if !ok {
+		return 0, false, fmt.Errorf("Service instance %s has no port reservation", clusterID)
+	}
+	if reservation.ReplicaPort != 0 {
+		return reservation.ReplicaPort, false, nil
+	}
+	reservation.ReplicaPort = l.takePort()
+	l.Reservations[clusterID] = reservation
+	return reservation.ReplicaPort, true, nil
+}
+
+// takePort removes the lowest free port from the pool, else the next unused port
+func (l *portLedger) takePort() (port int) {
+	if len(l.FreePorts) > 0 {
+		sort.Ints(l.FreePorts)
+		port = l.FreePorts[0]
+		l.FreePorts = l.FreePorts[1:]
+		return
+	}
+	port = l.NextPort
+	l.NextPort++
+	return
+}
+
+// claimPort takes an explicitly requested port, unless it is reserved by another instance
+func (l *portLedger) claimPort(clusterID structs.ClusterID, port int) error {
+	for otherID, reservation := range l.Reservations {
+		if otherID != clusterID && (reservation.Port == port || reservation.ReplicaPort == port) {
+			return fmt.Errorf("Port %d is already reserved by service instance %s", port, otherID)
+		}
+	}
+	for i, freePort := range l.FreePorts {
+		if freePort == port {
+			l.FreePorts = append(l.FreePorts[:i], l.FreePorts[i+1:]...)
+			break
+		}
+	}
+	if port >= l.NextPort {
+		l.NextPort = port + 1
+	}
+	return nil
+}
+
+// reservePort records an explicit port for clusterID, such as when recreating a
+// service instance with its original public port.
+func (l *portLedger) reservePort(clusterID structs.ClusterID, port int, now time.Time) (changed bool, err error) {
+	if reservation, ok := l.Reservations[clusterID]; ok {
+		if reservation.Port == port {
+			return false, nil
+		}
+		return false, fmt.Errorf("Service instance %s already reserved port %d", clusterID, reservation.Port)
+	}
+	if err = l.claimPort(clusterID, port); err != nil {
+		return false, err
+	}
+	l.Reservations[clusterID] = PortReservation{Port: port, ReservedAt: now}
+	return true, nil
+}
+
+// reserveReplicaPort records an explicit replica port for clusterID, which must already hold a reservation
+func (l *portLedger) reserveReplicaPort(clusterID structs.ClusterID, port int) (changed bool, err error) {
+	reservation, ok := l.Reservations[clusterID]
+	if !ok {
+		return false, fmt.Errorf("Service instance %s has no port reservation", clusterID)
+	}
+	if reservation.ReplicaPort == port {
+		return false, nil
+	}
+	if reservation.ReplicaPort != 0 {
+		return false, fmt.Errorf("Service instance %s already reserved replica port %d", clusterID, reservation.ReplicaPort)
+	}
+	if reservation.Port == port {
+		return false, fmt.Errorf("Replica port %d cannot be the same as the primary port", port)
+	}
+	if err = l.claimPort(clusterID, port); err != nil {
+		return false, err
+	}
+	reservation.ReplicaPort = port
+	l.Reservations[clusterID] = reservation
+	return true, nil
+}
+
+// publish records that the ports reserved by clusterID have been assigned to the routers
+func (l *portLedger) publish(clusterID structs.ClusterID) (changed bool) {
+	reservation, ok := l.Reservations[clusterID]
+	if !ok || reservation.Published {
+		return false
+	}
+	reservation.Published = true
+	l.Reservations[clusterID] = reservation
+	return true
+}
+
+// release forgets the reservation of clusterID. Ports that were never published return
+// to the pool of free ports; published ports are not handed out again.
+func (l *portLedger) release(clusterID structs.ClusterID) (port int, changed bool) {
+	reservation, ok := l.Reservations[clusterID]
+	if !ok {
+		return 0, false
+	}
+	delete(l.Reservations, clusterID)
+	if !reservation.Published {
+		l.FreePorts = append(l.FreePorts, reservation.Port)
+		if reservation.ReplicaPort != 0 {
+			l.FreePorts = append(l.FreePorts, reservation.ReplicaPort)
+		}
+		sort.Ints(l.FreePorts)
+	}
+	return reservation.Port, true
+}
@@ -0,0 +1,97 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+)
+
+func TestPortLedger_Reserve(t *testing.T) {
+	t.Parallel()
+
+	ledger := newPortLedger(initialPort)
+	port, changed := ledger.reserve(structs.ClusterID("a"), time.Now())
+	if !changed || port != initialPort {
+		t.Fatalf("Expected first reservation to be port %d, got %d", initialPort, port)
+	}
+	port, changed = ledger.reserve(structs.ClusterID("a"), time.Now())
+	if changed || port != initialPort {
+		t.Fatalf("Expected repeated reservation to return port %d unchanged, got %d", initialPort, port)
+	}
+	port, _ = ledger.reserve(structs.ClusterID("b"), time.Now())
+	if port != initialPort+1 {
+		t.Fatalf("Expected second reservation to be port %d, got %d", initialPort+1, port)
+	}
+}
+
+func TestPortLedger_ReleaseThenReserve(t *testing.T) {
+	t.Parallel()
+
+	ledger := newPortLedger(initialPort)
+	ledger.reserve(structs.ClusterID("a"), time.Now())
+	ledger.reserve(structs.ClusterID("b"), time.Now())
+
+	port, changed := ledger.release(structs.ClusterID("a"))
+	if !changed || port != initialPort {
+		t.Fatalf("Expected port %d to be released, got %d", initialPort, port)
+	}
+	port, _ = ledger.reserve(structs.ClusterID("c"), time.Now())
+	if port != initialPort {
+		t.Fatalf("Expected unpublished port %d to be reused, got %d", initialPort, port)
+	}
+
+	ledger.publish(structs.ClusterID("c"))
+	ledger.release(structs.ClusterID("c"))
+	port, _ = ledger.reserve(structs.ClusterID("d"), time.Now())
+	if port != initialPort+2 {
+		t.Fatalf("Expected published port %d not to be reused, got %d", initialPort, port)
+	}
+}
+
+func TestPortLedger_ReservePort(t *testing.T) {
+	t.Parallel()
+
+	ledger := newPortLedger(initialPort)
+	ledger.reserve(structs.ClusterID("a"), time.Now())
+
+	if _, err := ledger.reservePort(structs.ClusterID("b"), initialPort, time.Now()); err == nil {
+		t.Fatalf("Expected error reserving port already held by another instance")
+	}
+	changed, err := ledger.reservePort(structs.ClusterID("b"), initialPort+5, time.Now())
+	if err != nil || !changed {
+		t.Fatalf("Expected port %d to be reserved: %v", initialPort+5, err)
+	}
+	if ledger.NextPort != initialPort+6 {
+		t.Fatalf("Expected next port to move past explicit reservation, got %d", ledger.NextPort)
+	}
+}
+
+func TestPortLedger_ReserveReplica(t *testing.T) {
+	t.Parallel()
+
+	ledger := newPortLedger(initialPort)
+	if _, _, err := ledger.reserveReplica(structs.ClusterID("a")); err == nil {
+		t.Fatalf("Expected error reserving replica port without a reservation")
+	}
+
+	ledger.reserve(structs.ClusterID("a"), time.Now())
+	replicaPort, changed, err := ledger.reserveReplica(structs.ClusterID("a"))
+	if err != nil || !changed || replicaPort != initialPort+1 {
+		t.Fatalf("Expected replica port %d, got %d (%v)", initialPort+1, replicaPort, err)
+	}
+	again, changed, _ := ledger.reserveReplica(structs.ClusterID("a"))
+	if changed || again != replicaPort {
+		t.Fatalf("Expected repeated replica reservation to return port %d unchanged, got %d", replicaPort, again)
+	}
+
+	if _, err = ledger.reservePort(structs.ClusterID("b"), replicaPort, time.Now()); err == nil {
+		t.Fatalf("Expected error reserving port already held as a replica port")
+	}
+
+	ledger.publish(structs.ClusterID("a"))
+	ledger.release(structs.ClusterID("a"))
+	if port, _ := ledger.reserve(structs.ClusterID("b"), time.Now()); port != replicaPort+1 {
+		t.Fatalf("Expected published ports not to be reused, got %d", port)
+	}
+}
@@ -2,10 +2,12 @@ package routing
 
 import (
 	"fmt"
-	"regexp"
+	"path"
 	"strconv"
+	"time"
 
 	etcd "github.com/coreos/etcd/client"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
 	"github.com/dingotiles/dingo-postgresql-broker/config"
 	"github.com/pivotal-golang/lager"
@@ -15,7 +17,12 @@ import (
 const (
 	maxNumberOfRetries = 5
 	initialPort        = 30000
-	nextPortKey        = "routing/nextport"
+	portLedgerKey      = "routing/ports"
+
+	// nextPortKey was used to allocate ports before the port ledger was introduced
+	nextPortKey = "routing/nextport"
+
+	orphanedReservationGracePeriod = 10 * time.Minute
 )
 
 type Router struct {
@@ -40,72 +47,280 @@ func NewRouterWithPrefix(etcdConfig config.Etcd, prefix string, logger lager.Log
 		return nil, err
 	}
 
-	err = router.initializePort()
+	return router, nil
+}
+
+// AllocatePort reserves a public port for a service instance.
+// The port is taken from released ports that were never published, else the next unused port.
+// Allocation and reservation are a single compare-and-swap of the port ledger;
+// calling AllocatePort again for the same instance returns the same port.
+func (r *Router) AllocatePort(clusterID structs.ClusterID) (port int, err error) {
+	r.logger.Info("allocate-port", lager.Data{"clusterID": clusterID})
+
+	err = r.updateLedger(func(ledger *portLedger) (bool, error) {
+		var changed bool
+		port, changed = ledger.reserve(clusterID, time.Now())
+		return changed, nil
+	})
 	if err != nil {
-		return nil, err
+		r.logger.Error("allocate-port.update-ledger", err)
+		return 0, err
 	}
 
-	return router, nil
+	return port, nil
 }
 
-func (r *Router) AllocatePort() (int, error) {
-	r.logger.Info("allocate-port")
-
-	ctx := context.Background()
-	key := fmt.Sprintf("%s/%s", r.prefix, nextPortKey)
+// AssignPortToCluster publishes the port allocation of a cluster to the routers.
+// If the cluster does not yet hold a reservation for port (such as when it is
+// being recreated with its original port) then the reservation is made first.
+func (r *Router) AssignPortToCluster(clusterID structs.ClusterID, port int) error {
+	r.logger.Info("assign-port-to-cluster", lager.Data{
+		"clusterID": clusterID,
+		"port":      port,
+	})
 
-	var err error
-	for i := 0; i < maxNumberOfRetries; i++ {
-		nextPort, err := r.getNextPort(ctx, key)
+	err := r.updateLedger(func(ledger *portLedger) (bool, error) {
+		reserved, err := ledger.reservePort(clusterID, port, time.Now())
 		if err != nil {
-			r.logger.Error("allocate-port.get", err)
-			continue
+			return false, err
 		}
+		published := ledger.publish(clusterID)
+		return reserved || published, nil
+	})
+	if err != nil {
+		r.logger.Error("assign-port-to-cluster.reserve", err)
+		return err
+	}
 
-		err = r.increaseNextPort(ctx, key, nextPort)
+	ctx := context.Background()
+	key := fmt.Sprintf("%s/routing/allocation/%s", r.prefix, clusterID)
+	_, err = r.etcd.Set(ctx, key, fmt.Sprintf("%d", port), &etcd.SetOptions{})
+	if err != nil {
+		r.logger.Error("assign-port-to-cluster.set", err)
+		return err
+	}
+
+	r.PublishRoutingTableEntry(clusterID)
+
+	return nil
+}
+
+// AllocateReplicaPort reserves a second public port for read-only access to the replicas
+// of a service instance. The instance must already have been allocated its primary port.
+func (r *Router) AllocateReplicaPort(clusterID structs.ClusterID) (port int, err error) {
+	r.logger.Info("allocate-replica-port", lager.Data{"clusterID": clusterID})
+
+	err = r.updateLedger(func(ledger *portLedger) (bool, error) {
+		var changed bool
+		var err error
+		port, changed, err = ledger.reserveReplica(clusterID)
+		return changed, err
+	})
+	if err != nil {
+		r.logger.Error("allocate-replica-port.update-ledger", err)
+		return 0, err
+	}
+
+	return port, nil
+}
+
+// AssignReplicaPortToCluster publishes the replica port allocation of a cluster to the routers
+// at /routing/replica_allocation/<id>, next to its primary allocation.
+func (r *Router) AssignReplicaPortToCluster(clusterID structs.ClusterID, port int) error {
+	r.logger.Info("assign-replica-port-to-cluster", lager.Data{
+		"clusterID": clusterID,
+		"port":      port,
+	})
+
+	err := r.updateLedger(func(ledger *portLedger) (bool, error) {
+		reserved, err := ledger.reserveReplicaPort(clusterID, port)
 		if err != nil {
-			r.logger.Error("allocate-port.increase", err)
-			continue
+			return false, err
 		}
+		published := ledger.publish(clusterID)
+		return reserved || published, nil
+	})
+	if err != nil {
+		r.logger.Error("assign-replica-port-to-cluster.reserve", err)
+		return err
+	}
 
-		return nextPort, nil
+	ctx := context.Background()
+	key := fmt.Sprintf("%s/routing/replica_allocation/%s", r.prefix, clusterID)
+	_, err = r.etcd.Set(ctx, key, fmt.Sprintf("%d", port), &etcd.SetOptions{})
+	if err != nil {
+		r.logger.Error("assign-replica-port-to-cluster.set", err)
+		return err
 	}
 
-	return 0, err
+	r.PublishRoutingTableEntry(clusterID)
+
+	return nil
 }
 
-func (r *Router) AssignPortToCluster(clusterID structs.ClusterID, port int) error {
-	r.logger.Info("assign-port-to-cluster", lager.Data{
+// AssignScopeToCluster routes the public ports of a service instance to the Patroni cluster scope.
+// Routers switch over with this single etcd write, such as at the end of a major version upgrade.
+func (r *Router) AssignScopeToCluster(clusterID structs.ClusterID, scope structs.ClusterID) (err error) {
+	r.logger.Info("assign-scope-to-cluster", lager.Data{
 		"clusterID": clusterID,
-		"port":      port,
+		"scope":     scope,
 	})
 
 	ctx := context.Background()
-	key := fmt.Sprintf("%s/routing/allocation/%s", r.prefix, clusterID)
-	_, err := r.etcd.Set(ctx, key, fmt.Sprintf("%d", port), &etcd.SetOptions{})
+	key := fmt.Sprintf("%s/routing/scope/%s", r.prefix, clusterID)
+	if scope == "" || scope == clusterID {
+		_, err = r.etcd.Delete(ctx, key, &etcd.DeleteOptions{})
+		if isKeyNotFound(err) {
+			err = nil
+		}
+	} else {
+		_, err = r.etcd.Set(ctx, key, string(scope), &etcd.SetOptions{})
+	}
 	if err != nil {
-		r.logger.Error("assign-port-to-cluster.set", err)
+		r.logger.Error("assign-scope-to-cluster.set", err)
 		return err
 	}
 
+	r.PublishRoutingTableEntry(clusterID)
+
 	return nil
 }
 
+// RemoveClusterAssignment stops routing to a cluster and releases its port reservation
 func (r *Router) RemoveClusterAssignment(clusterID structs.ClusterID) error {
 	r.logger.Info("remove-cluster-assignment", lager.Data{
 		"clusterID": clusterID,
 	})
 
+	if err := r.UnassignCluster(clusterID); err != nil {
+		return err
+	}
+
+	err := r.updateLedger(func(ledger *portLedger) (bool, error) {
+		_, changed := ledger.release(clusterID)
+		return changed, nil
+	})
+	if err != nil {
+		r.logger.Error("remove-cluster-assignment.release", err)
+		return err
+	}
+
+	return nil
+}
+
+// UnassignCluster stops routing to a cluster, but keeps its port reservation so that
+// it can be assigned the same ports again, such as when a deprovisioned service
+// instance is restored
+func (r *Router) UnassignCluster(clusterID structs.ClusterID) error {
+	r.logger.Info("unassign-cluster", lager.Data{
+		"clusterID": clusterID,
+	})
+
 	ctx := context.Background()
 	key := fmt.Sprintf("%s/routing/allocation/%s", r.prefix, clusterID)
 
 	_, err := r.etcd.Delete(ctx, key, &etcd.DeleteOptions{})
-	if err != nil {
-		r.logger.Error("remove-cluster-assignment.delete", err)
+	if err != nil && !isKeyNotFound(err) {
+		r.logger.Error("unassign-cluster.delete", err)
 		return err
 	}
 
-	return nil
+	replicaKey := fmt.Sprintf("%s/routing/replica_allocation/%s", r.prefix, clusterID)
+	_, err = r.etcd.Delete(ctx, replicaKey, &etcd.DeleteOptions{})
+	if err != nil && !isKeyNotFound(err) {
+		r.logger.Error("unassign-cluster.delete-replica", err)
+		return err
+	}
+
+	scopeKey := fmt.Sprintf("%s/routing/scope/%s", r.prefix, clusterID)
+	_, err = r.etcd.Delete(ctx, scopeKey, &etcd.DeleteOptions{})
+	if err != nil && !isKeyNotFound(err) {
+		r.logger.Error("unassign-cluster.delete-scope", err)
+		return err
+	}
+
+	return r.removeRoutingTableEntry(clusterID)
+}
+
+// Reservations returns the current port reservation for each service instance
+func (r *Router) Reservations() (map[structs.ClusterID]PortReservation, error) {
+	ledger, _, err := r.loadLedger(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return ledger.Reservations, nil
+}
+
+// SweepOrphanedReservations forgets reservations whose service instance no longer
+// holds its ports, such as after a provision that failed before it was saved.
+// Reservations younger than orphanedReservationGracePeriod are left alone so that
+// in-flight provisions are not swept. The sweep stops at the first reservation
+// whose holder cannot be determined, rather than risk reclaiming a live instance's ports.
+func (r *Router) SweepOrphanedReservations(portHolders interfaces.PortHolders) (reclaimed []structs.ClusterID, err error) {
+	ledger, _, err := r.loadLedger(context.Background())
+	if err != nil {
+		r.logger.Error("sweep-orphaned-reservations.load-ledger", err)
+		return nil, err
+	}
+	cutoff := time.Now().Add(-orphanedReservationGracePeriod)
+	orphaned := map[structs.ClusterID]time.Time{}
+	for clusterID, reservation := range ledger.Reservations {
+		if reservation.ReservedAt.After(cutoff) {
+			continue
+		}
+		held, err := portHolders.HoldsPorts(clusterID)
+		if err != nil {
+			r.logger.Error("sweep-orphaned-reservations.holds-ports", err, lager.Data{"clusterID": clusterID})
+			return nil, err
+		}
+		if !held {
+			orphaned[clusterID] = reservation.ReservedAt
+		}
+	}
+
+	reclaimed = []structs.ClusterID{}
+	if len(orphaned) == 0 {
+		return reclaimed, nil
+	}
+	err = r.updateLedger(func(ledger *portLedger) (bool, error) {
+		reclaimed = []structs.ClusterID{}
+		for clusterID, reservedAt := range orphaned {
+			// a reservation made again since it was checked is not orphaned
+			if reservation, ok := ledger.Reservations[clusterID]; !ok || !reservation.ReservedAt.Equal(reservedAt) {
+				continue
+			}
+			ledger.release(clusterID)
+			reclaimed = append(reclaimed, clusterID)
+		}
+		return len(reclaimed) > 0, nil
+	})
+	if err != nil {
+		r.logger.Error("sweep-orphaned-reservations.update-ledger", err)
+		return nil, err
+	}
+
+	ctx := context.Background()
+	for _, clusterID := range reclaimed {
+		r.logger.Info("sweep-orphaned-reservations.reclaimed", lager.Data{"clusterID": clusterID})
+		key := fmt.Sprintf("%s/routing/allocation/%s", r.prefix, clusterID)
+		_, err := r.etcd.Delete(ctx, key, &etcd.DeleteOptions{})
+		if err != nil && !isKeyNotFound(err) {
+			r.logger.Error("sweep-orphaned-reservations.delete-allocation", err)
+		}
+		replicaKey := fmt.Sprintf("%s/routing/replica_allocation/%s", r.prefix, clusterID)
+		_, err = r.etcd.Delete(ctx, replicaKey, &etcd.DeleteOptions{})
+		if err != nil && !isKeyNotFound(err) {
+			r.logger.Error("sweep-orphaned-reservations.delete-replica-allocation", err)
+		}
+		scopeKey := fmt.Sprintf("%s/routing/scope/%s", r.prefix, clusterID)
+		_, err = r.etcd.Delete(ctx, scopeKey, &etcd.DeleteOptions{})
+		if err != nil && !isKeyNotFound(err) {
+			r.logger.Error("sweep-orphaned-reservations.delete-scope", err)
+		}
+		r.removeRoutingTableEntry(clusterID)
+	}
+
+	return reclaimed, nil
 }
 
 func (r *Router) setupEtcd(cfg config.Etcd) (etcd.KeysAPI, error) {
@@ -119,34 +334,168 @@ func (r *Router) setupEtcd(cfg config.Etcd) (etcd.KeysAPI, error) {
 	return api, nil
 }
 
-func (r *Router) initializePort() error {
+// InitializePorts creates the port ledger if it does not yet exist, and is called by the
+// broker before allocating ports; routers that only read the routing table do not call it.
+// An existing routing/nextport value from earlier broker versions is carried over,
+// and the ports assigned by earlier broker versions are reserved in the ledger once.
+func (r *Router) InitializePorts() error {
 	ctx := context.Background()
-	key := fmt.Sprintf("%s/%s", r.prefix, nextPortKey)
+	key := fmt.Sprintf("%s/%s", r.prefix, portLedgerKey)
+
+	r.logger.Info("initialize-port", lager.Data{"key": portLedgerKey})
+
+	ledger, _, err := r.loadLedger(ctx)
+	if err == nil {
+		if ledger.AllocationsImported {
+			return nil
+		}
+		return r.importAllocations(ctx)
+	}
+	if !isKeyNotFound(err) {
+		r.logger.Error("initialize-port.get", err)
+		return err
+	}
+
+	// if the key wasn't found etcd is available
+	// but routing hasn't been initialized
+	nextPort, err := r.getNextPort(ctx, fmt.Sprintf("%s/%s", r.prefix, nextPortKey))
+	if err != nil {
+		if !isKeyNotFound(err) {
+			r.logger.Error("initialize-port.get-next-port", err)
+			return err
+		}
+		nextPort = initialPort
+	}
 
-	r.logger.Info("initialize-port", lager.Data{"key": nextPortKey})
+	value, err := newPortLedger(nextPort).encode()
+	if err != nil {
+		return err
+	}
+	_, err = r.etcd.Set(ctx, key, value, &etcd.SetOptions{
+		PrevExist: etcd.PrevNoExist,
+	})
+	if err != nil && !isNodeExist(err) {
+		r.logger.Error("initialize-port.set-value", err)
+		return err
+	}
 
-	_, err := r.getNextPort(ctx, key)
+	return r.importAllocations(ctx)
+}
 
+// importAllocations reserves, as published, the ports at /routing/allocation and
+// /routing/replica_allocation, such as those assigned before the ledger existed,
+// so that they are never handed out to another service instance
+func (r *Router) importAllocations(ctx context.Context) error {
+	ports, err := r.loadAllocations(ctx, "routing/allocation")
+	if err != nil {
+		r.logger.Error("import-allocations.load", err)
+		return err
+	}
+	replicaPorts, err := r.loadAllocations(ctx, "routing/replica_allocation")
 	if err != nil {
-		// if the key wasn't found etcd is available
-		// but routing hasn't been initialized
-		notFoundRegExp, _ := regexp.Compile("Key not found")
-		if notFoundRegExp.FindString(err.Error()) == "Key not found" {
+		r.logger.Error("import-allocations.load-replicas", err)
+		return err
+	}
 
-			_, err := r.etcd.Set(ctx, key, fmt.Sprintf("%d", initialPort), &etcd.SetOptions{
-				PrevExist: etcd.PrevNoExist,
-			})
-			if err != nil {
-				r.logger.Error("initialize-port.set-value", err)
-				return err
+	err = r.updateLedger(func(ledger *portLedger) (bool, error) {
+		if ledger.AllocationsImported {
+			return false, nil
+		}
+		now := time.Now()
+		for clusterID, port := range ports {
+			if _, err := ledger.reservePort(clusterID, port, now); err != nil {
+				r.logger.Error("import-allocations.reserve", err, lager.Data{"clusterID": clusterID, "port": port})
+				continue
+			}
+			ledger.publish(clusterID)
+		}
+		for clusterID, port := range replicaPorts {
+			if _, err := ledger.reserveReplicaPort(clusterID, port); err != nil {
+				r.logger.Error("import-allocations.reserve-replica", err, lager.Data{"clusterID": clusterID, "port": port})
 			}
-		} else {
-			r.logger.Error("initialize-port.get", err)
+		}
+		ledger.AllocationsImported = true
+		return true, nil
+	})
+	if err != nil {
+		r.logger.Error("import-allocations.update-ledger", err)
+	}
+	return err
+}
+
+// loadAllocations returns the port of each service instance under the key directory
+func (r *Router) loadAllocations(ctx context.Context, directory string) (map[structs.ClusterID]int, error) {
+	allocations := map[structs.ClusterID]int{}
+	key := fmt.Sprintf("%s/%s", r.prefix, directory)
+	resp, err := r.etcd.Get(ctx, key, &etcd.GetOptions{Quorum: true})
+	if err != nil {
+		if isKeyNotFound(err) {
+			return allocations, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		port, err := strconv.Atoi(node.Value)
+		if err != nil {
+			r.logger.Error("load-allocations.port", err, lager.Data{"key": node.Key})
+			continue
+		}
+		allocations[structs.ClusterID(path.Base(node.Key))] = port
+	}
+	return allocations, nil
+}
+
+func (r *Router) loadLedger(ctx context.Context) (*portLedger, uint64, error) {
+	key := fmt.Sprintf("%s/%s", r.prefix, portLedgerKey)
+	resp, err := r.etcd.Get(ctx, key, &etcd.GetOptions{Quorum: true})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ledger, err := decodePortLedger(resp.Node.Value)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ledger, resp.Node.ModifiedIndex, nil
+}
+
+// updateLedger applies update to the latest port ledger and stores the result
+// with compare-and-swap, retrying if another broker changed the ledger meanwhile.
+func (r *Router) updateLedger(update func(*portLedger) (bool, error)) (err error) {
+	ctx := context.Background()
+	key := fmt.Sprintf("%s/%s", r.prefix, portLedgerKey)
+
+	for i := 0; i < maxNumberOfRetries; i++ {
+		ledger, index, err := r.loadLedger(ctx)
+		if err != nil {
+			return err
+		}
+
+		changed, err := update(ledger)
+		if err != nil || !changed {
+			return err
+		}
+
+		value, err := ledger.encode()
+		if err != nil {
+			return err
+		}
+
+		_, err = r.etcd.Set(ctx, key, value, &etcd.SetOptions{
+			PrevIndex: index,
+			PrevExist: etcd.PrevExist,
+		})
+		if err == nil {
+			return nil
+		}
+		if !isTestFailed(err) {
 			return err
 		}
+		r.logger.Info("update-ledger.retry", lager.Data{"attempt": i + 1})
 	}
 
-	return nil
+	return fmt.Errorf("Router: could not update %s after %d attempts", portLedgerKey, maxNumberOfRetries)
 }
 
 func (r *Router) getNextPort(ctx context.Context, key string) (int, error) {
@@ -163,11 +512,17 @@ func (r *Router) getNextPort(ctx context.Context, key string) (int, error) {
 	return port, nil
 }
 
-func (r *Router) increaseNextPort(ctx context.Context, key string, current int) error {
-	_, err := r.etcd.Set(ctx, key, fmt.Sprintf("%d", current+1), &etcd.SetOptions{
-		PrevValue: fmt.Sprintf("%d", current),
-		PrevExist: etcd.PrevExist,
-	})
+func isKeyNotFound(err error) bool {
+	etcdErr, ok := err.(etcd.Error)
+	return ok && etcdErr.Code == etcd.ErrorCodeKeyNotFound
+}
 
-	return err
+func isNodeExist(err error) bool {
+	etcdErr, ok := err.(etcd.Error)
+	return ok && etcdErr.Code == etcd.ErrorCodeNodeExist
+}
+
+func isTestFailed(err error) bool {
+	etcdErr, ok := err.(etcd.Error)
+	return ok && etcdErr.Code == etcd.ErrorCodeTestFailed
 }
@@ -2,9 +2,11 @@ package routing
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 	"strconv"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -46,14 +48,17 @@ func TestRouter_InitialPort(t *testing.T) {
 	if err != nil {
 		t.Fatal("Could not create a new router", err)
 	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
 
-	nextPort, err := router.AllocatePort()
+	nextPort, err := router.AllocatePort(structs.ClusterID("clusterID"))
 	if err != nil {
 		t.Fatal("Could not allocate port", err)
 	}
 
 	if nextPort != initialPort {
-		t.Errorf("%s was not initialized in etcd", nextPortKey)
+		t.Errorf("%s was not initialized in etcd", portLedgerKey)
 	}
 }
 
@@ -68,8 +73,11 @@ func TestRouter_WithoutPrefix(t *testing.T) {
 	if err != nil {
 		t.Fatal("Could not create a new router", err)
 	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
 
-	nextPort, err := router.AllocatePort()
+	nextPort, err := router.AllocatePort(structs.ClusterID("clusterID"))
 	if err != nil {
 		t.Fatal("Could not allocate port", err)
 	}
@@ -90,11 +98,15 @@ func TestRouter_ConcurrentPortAllocation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not create a new router", err)
 	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
 
 	portChan := make(chan int)
 	for i := 0; i < 5; i++ {
+		clusterID := structs.ClusterID(fmt.Sprintf("clusterID-%d", i))
 		go func() {
-			nextPort, err := router.AllocatePort()
+			nextPort, err := router.AllocatePort(clusterID)
 			if err != nil {
 				portChan <- 0
 				t.Error("Could not allocate port", err)
@@ -127,6 +139,9 @@ func TestRouter_AssignPortToCluster(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not create a new router", err)
 	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
 
 	clusterID := structs.ClusterID("clusterID")
 	port := 30000
@@ -164,6 +179,9 @@ func TestRouter_RemoveClusterAssignement(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not create a new router", err)
 	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
 
 	err = router.RemoveClusterAssignment(clusterID)
 	if err != nil {
@@ -175,3 +193,239 @@ func TestRouter_RemoveClusterAssignement(t *testing.T) {
 		t.Fatalf("port wasn't deleted %s", err)
 	}
 }
+
+func TestRouter_AllocatePort_SameCluster(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestRouter_AllocatePort_SameCluster"
+	resetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	router, err := NewRouterWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create a new router %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+
+	clusterID := structs.ClusterID("clusterID")
+	firstPort, err := router.AllocatePort(clusterID)
+	if err != nil {
+		t.Fatalf("Could not allocate port %s", err)
+	}
+	secondPort, err := router.AllocatePort(clusterID)
+	if err != nil {
+		t.Fatalf("Could not allocate port %s", err)
+	}
+	if firstPort != secondPort {
+		t.Fatalf("Expected repeated allocation to return port %d, got %d", firstPort, secondPort)
+	}
+
+	reservations, err := router.Reservations()
+	if err != nil {
+		t.Fatalf("Could not load reservations %s", err)
+	}
+	if want, got := firstPort, reservations[clusterID].Port; want != got {
+		t.Fatalf("Reservation was not recorded. Expected %d, got %d", want, got)
+	}
+}
+
+func TestRouter_RemoveClusterAssignment_ReusesUnpublishedPort(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestRouter_RemoveClusterAssignment_ReusesUnpublishedPort"
+	resetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	router, err := NewRouterWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create a new router %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+
+	// a failed provision never published its port
+	port, err := router.AllocatePort(structs.ClusterID("failed"))
+	if err != nil {
+		t.Fatalf("Could not allocate port %s", err)
+	}
+	if err = router.RemoveClusterAssignment(structs.ClusterID("failed")); err != nil {
+		t.Fatalf("Could not remove the assignment %s", err)
+	}
+	reusedPort, err := router.AllocatePort(structs.ClusterID("second"))
+	if err != nil {
+		t.Fatalf("Could not allocate port %s", err)
+	}
+	if port != reusedPort {
+		t.Fatalf("Expected unpublished port %d to be reused, got %d", port, reusedPort)
+	}
+
+	if err = router.AssignPortToCluster(structs.ClusterID("second"), reusedPort); err != nil {
+		t.Fatalf("Could not assign port %s", err)
+	}
+	if err = router.RemoveClusterAssignment(structs.ClusterID("second")); err != nil {
+		t.Fatalf("Could not remove the assignment %s", err)
+	}
+	otherPort, err := router.AllocatePort(structs.ClusterID("third"))
+	if err != nil {
+		t.Fatalf("Could not allocate port %s", err)
+	}
+	if otherPort == reusedPort {
+		t.Fatalf("Expected published port %d not to be reused", reusedPort)
+	}
+}
+
+func TestRouter_UnassignCluster_KeepsPort(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestRouter_UnassignCluster_KeepsPort"
+	resetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	router, err := NewRouterWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create a new router %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+
+	port, err := router.AllocatePort(structs.ClusterID("first"))
+	if err != nil {
+		t.Fatalf("Could not allocate port %s", err)
+	}
+	if err = router.AssignPortToCluster(structs.ClusterID("first"), port); err != nil {
+		t.Fatalf("Could not assign port %s", err)
+	}
+	if err = router.UnassignCluster(structs.ClusterID("first")); err != nil {
+		t.Fatalf("Could not unassign the cluster %s", err)
+	}
+
+	otherPort, err := router.AllocatePort(structs.ClusterID("second"))
+	if err != nil {
+		t.Fatalf("Could not allocate port %s", err)
+	}
+	if port == otherPort {
+		t.Fatalf("Expected port %d to stay reserved by the unassigned cluster", port)
+	}
+	if err = router.AssignPortToCluster(structs.ClusterID("first"), port); err != nil {
+		t.Fatalf("Could not assign the reserved port again %s", err)
+	}
+}
+
+type fakePortHolders struct {
+	holders map[structs.ClusterID]bool
+	err     error
+}
+
+func (f *fakePortHolders) HoldsPorts(clusterID structs.ClusterID) (bool, error) {
+	return f.holders[clusterID], f.err
+}
+
+func TestRouter_SweepOrphanedReservations(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestRouter_SweepOrphanedReservations"
+	etcdApi := resetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	ledger := newPortLedger(30002)
+	expired := time.Now().Add(-2 * orphanedReservationGracePeriod)
+	ledger.Reservations["running"] = PortReservation{Port: 30000, ReservedAt: expired}
+	ledger.Reservations["orphaned"] = PortReservation{Port: 30001, ReservedAt: expired}
+	ledger.Reservations["provisioning"] = PortReservation{Port: 30002, ReservedAt: time.Now()}
+	value, _ := ledger.encode()
+	key := fmt.Sprintf("%s/%s", testPrefix, portLedgerKey)
+	if _, err := etcdApi.Set(context.Background(), key, value, &etcd.SetOptions{}); err != nil {
+		t.Fatalf("Could not store port ledger %s", err)
+	}
+
+	router, err := NewRouterWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create a new router %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+
+	portHolders := &fakePortHolders{err: fmt.Errorf("etcd unavailable")}
+	if _, err = router.SweepOrphanedReservations(portHolders); err == nil {
+		t.Fatalf("Expected the sweep to stop when port holders cannot be determined")
+	}
+	reservations, err := router.Reservations()
+	if err != nil || len(reservations) != 3 {
+		t.Fatalf("Expected no reservations to be swept after an error, got %v (%v)", reservations, err)
+	}
+
+	portHolders = &fakePortHolders{holders: map[structs.ClusterID]bool{"running": true}}
+	reclaimed, err := router.SweepOrphanedReservations(portHolders)
+	if err != nil {
+		t.Fatalf("Could not sweep reservations %s", err)
+	}
+	if !reflect.DeepEqual(reclaimed, []structs.ClusterID{"orphaned"}) {
+		t.Fatalf("Expected only 'orphaned' to be reclaimed, got %v", reclaimed)
+	}
+
+	reservations, err = router.Reservations()
+	if err != nil {
+		t.Fatalf("Could not load reservations %s", err)
+	}
+	if _, ok := reservations["orphaned"]; ok {
+		t.Fatalf("Reservation for 'orphaned' should have been removed")
+	}
+	if len(reservations) != 2 {
+		t.Fatalf("Expected 2 remaining reservations, got %v", reservations)
+	}
+}
+
+func TestRouter_ImportsAllocations(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestRouter_ImportsAllocations"
+	etcdApi := resetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	// assigned by a broker that predates the port ledger
+	allocations := map[string]string{
+		"routing/nextport":               "30002",
+		"routing/allocation/old":         "30000",
+		"routing/replica_allocation/old": "30001",
+	}
+	for key, value := range allocations {
+		if _, err := etcdApi.Set(context.Background(), fmt.Sprintf("%s/%s", testPrefix, key), value, &etcd.SetOptions{}); err != nil {
+			t.Fatalf("Could not store %s %s", key, err)
+		}
+	}
+
+	router, err := NewRouterWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create a new router %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+	reservations, err := router.Reservations()
+	if err != nil {
+		t.Fatalf("Could not load reservations %s", err)
+	}
+	if reservation := reservations["old"]; reservation.Port != 30000 || reservation.ReplicaPort != 30001 || !reservation.Published {
+		t.Fatalf("Expected the existing allocations to be reserved as published, got %v", reservations)
+	}
+
+	// allocations are only imported once
+	if _, err = etcdApi.Set(context.Background(), fmt.Sprintf("%s/routing/allocation/later", testPrefix), "30100", &etcd.SetOptions{}); err != nil {
+		t.Fatalf("Could not store allocation %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+	if reservations, _ = router.Reservations(); reservations["later"].Port != 0 {
+		t.Fatalf("Expected allocations not to be imported again, got %v", reservations)
+	}
+	replicaPort, err := router.AllocateReplicaPort(structs.ClusterID("old"))
+	if err != nil || replicaPort != 30001 {
+		t.Fatalf("Expected the imported replica port 30001, got %d (%v)", replicaPort, err)
+	}
+}
@@ -0,0 +1,352 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/patroni"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+const (
+	routingTableResyncInterval = 60 * time.Second
+)
+
+var (
+	memberIDRegExp            = regexp.MustCompile("/members/([^/]+)$")
+	routingChangeRegExp       = regexp.MustCompile("(/service/[^/]+/(leader|members)|/routing/((replica_)?allocation|scope))(/|$)")
+	serviceChangeRegExp       = regexp.MustCompile("/service/([^/]+)/(leader|members)(/|$)")
+	allocationClusterIDRegExp = regexp.MustCompile("/routing/allocation/([^/]+)$")
+	scopeClusterIDRegExp      = regexp.MustCompile("/routing/scope/([^/]+)$")
+)
+
+// RoutingTableEntry is the record published at /routing/table/<id> for TCP routers.
+// It describes the public port of a service instance and where its leader and replicas run.
+type RoutingTableEntry struct {
+	Port         int              `json:"port"`
+	ReplicaPort  int              `json:"replica_port,omitempty"`
+	LeaderHost   string           `json:"leader_host"`
+	LeaderPort   int              `json:"leader_port"`
+	LeaderAPIURL string           `json:"leader_api_url,omitempty"`
+	Replicas     []RoutingBackend `json:"replicas"`
+}
+
+// RoutingBackend is a running PostgreSQL server that routers can forward connections to
+type RoutingBackend struct {
+	MemberID string `json:"member_id"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	APIURL   string `json:"api_url,omitempty"`
+}
+
+// HasLeader is true if a running leader was found for the service instance
+func (entry RoutingTableEntry) HasLeader() bool {
+	return entry.LeaderHost != "" && entry.LeaderPort != 0
+}
+
+// RoutingTableEntry computes the routing record for a service instance from its
+// port allocation and the conn_url of each member of its Patroni cluster.
+// It is computed on every member heartbeat, so it reads from the local etcd member
+// rather than with quorum reads.
+func (r *Router) RoutingTableEntry(clusterID structs.ClusterID) (entry RoutingTableEntry, err error) {
+	ctx := context.Background()
+	entry.Replicas = []RoutingBackend{}
+
+	entry.Port, err = r.allocatedPort(ctx, "routing/allocation", clusterID)
+	if err != nil {
+		return
+	}
+
+	entry.ReplicaPort, err = r.allocatedPort(ctx, "routing/replica_allocation", clusterID)
+	if err != nil && !isKeyNotFound(err) {
+		return
+	}
+
+	scope, err := r.clusterScope(ctx, clusterID)
+	if err != nil {
+		return
+	}
+
+	leaderID := ""
+	leaderKey := fmt.Sprintf("%s/service/%s/leader", r.prefix, scope)
+	resp, err := r.etcd.Get(ctx, leaderKey, &etcd.GetOptions{})
+	if err == nil {
+		leaderID = resp.Node.Value
+	} else if !isKeyNotFound(err) {
+		return
+	}
+
+	membersKey := fmt.Sprintf("%s/service/%s/members", r.prefix, scope)
+	resp, err = r.etcd.Get(ctx, membersKey, &etcd.GetOptions{Recursive: true})
+	if err != nil {
+		if isKeyNotFound(err) {
+			return entry, nil
+		}
+		return
+	}
+
+	for _, node := range resp.Node.Nodes {
+		match := memberIDRegExp.FindStringSubmatch(node.Key)
+		if match == nil {
+			continue
+		}
+		memberID := match[1]
+		member, err := patroni.ParseMember(node.Value)
+		if err != nil {
+			r.logger.Error("routing-table.decode-member", err, lager.Data{"member": memberID})
+			continue
+		}
+		host, port, err := member.HostPort()
+		if err != nil {
+			r.logger.Error("routing-table.member-conn-url", err, lager.Data{"member": memberID})
+			continue
+		}
+		if memberID == leaderID {
+			entry.LeaderHost = host
+			entry.LeaderPort = port
+			entry.LeaderAPIURL = member.APIURL
+		} else if member.State == patroni.RunningState {
+			entry.Replicas = append(entry.Replicas, RoutingBackend{
+				MemberID: memberID,
+				Host:     host,
+				Port:     port,
+				APIURL:   member.APIURL,
+			})
+		}
+	}
+	sort.Sort(backendsByMemberID(entry.Replicas))
+
+	return entry, nil
+}
+
+// RoutingTable computes the routing record of every service instance with an allocated port
+func (r *Router) RoutingTable() (table map[structs.ClusterID]RoutingTableEntry, index uint64, err error) {
+	ctx := context.Background()
+	table = map[structs.ClusterID]RoutingTableEntry{}
+
+	clusterIDs, index, err := r.allocatedClusterIDs(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, clusterID := range clusterIDs {
+		entry, err := r.RoutingTableEntry(clusterID)
+		if err != nil {
+			if isKeyNotFound(err) {
+				continue
+			}
+			return nil, 0, err
+		}
+		table[clusterID] = entry
+	}
+	return table, index, nil
+}
+
+// WaitForRoutingChange blocks until a port allocation, Patroni scope, Patroni leader or Patroni member
+// changes after etcd index afterIndex, and returns the index of that change.
+func (r *Router) WaitForRoutingChange(ctx context.Context, afterIndex uint64) (uint64, error) {
+	watcher := r.etcd.Watcher(fmt.Sprintf("%s/", r.prefix), &etcd.WatcherOptions{AfterIndex: afterIndex, Recursive: true})
+	for {
+		resp, err := watcher.Next(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if routingChangeRegExp.MatchString(resp.Node.Key) {
+			return resp.Node.ModifiedIndex, nil
+		}
+	}
+}
+
+// PublishRoutingTableEntry writes the latest routing record for a service instance,
+// or removes it if the service instance no longer has a port allocation.
+func (r *Router) PublishRoutingTableEntry(clusterID structs.ClusterID) error {
+	ctx := context.Background()
+	key := fmt.Sprintf("%s/routing/table/%s", r.prefix, clusterID)
+
+	entry, err := r.RoutingTableEntry(clusterID)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return r.removeRoutingTableEntry(clusterID)
+		}
+		r.logger.Error("publish-routing-table-entry.compute", err, lager.Data{"clusterID": clusterID})
+		return err
+	}
+
+	resp, err := r.etcd.Get(ctx, key, &etcd.GetOptions{})
+	if err == nil {
+		var current RoutingTableEntry
+		if json.Unmarshal([]byte(resp.Node.Value), &current) == nil && reflect.DeepEqual(current, entry) {
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = r.etcd.Set(ctx, key, string(data), &etcd.SetOptions{})
+	if err != nil {
+		r.logger.Error("publish-routing-table-entry.set", err, lager.Data{"clusterID": clusterID})
+		return err
+	}
+	r.logger.Info("publish-routing-table-entry", lager.Data{"clusterID": clusterID, "entry": entry})
+	return nil
+}
+
+// PublishRoutingTables publishes the routing record of every allocated service instance,
+// then keeps them up to date as Patroni members and leaders change.
+// It blocks until ctx is done or watching etcd fails.
+func (r *Router) PublishRoutingTables(ctx context.Context) error {
+	servicesKey := fmt.Sprintf("%s/service", r.prefix)
+
+	for {
+		index, err := r.publishAllRoutingTableEntries(ctx)
+		if err != nil {
+			return err
+		}
+
+		watcher := r.etcd.Watcher(servicesKey, &etcd.WatcherOptions{AfterIndex: index, Recursive: true})
+		resync := time.After(routingTableResyncInterval)
+	watch:
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-resync:
+				break watch
+			default:
+			}
+
+			watchCtx, cancel := context.WithTimeout(ctx, routingTableResyncInterval)
+			resp, err := watcher.Next(watchCtx)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// watch timed out or etcd history was compacted; start again from a full sync
+				break watch
+			}
+
+			match := serviceChangeRegExp.FindStringSubmatch(resp.Node.Key)
+			if match == nil {
+				continue
+			}
+			for _, clusterID := range r.clusterIDsServedBy(ctx, structs.ClusterID(match[1])) {
+				r.PublishRoutingTableEntry(clusterID)
+			}
+		}
+	}
+}
+
+// publishAllRoutingTableEntries returns the etcd index from which to watch for further changes
+func (r *Router) publishAllRoutingTableEntries(ctx context.Context) (uint64, error) {
+	clusterIDs, index, err := r.allocatedClusterIDs(ctx)
+	if err != nil {
+		r.logger.Error("publish-routing-tables.allocations", err)
+		return 0, err
+	}
+
+	for _, clusterID := range clusterIDs {
+		r.PublishRoutingTableEntry(clusterID)
+	}
+	return index, nil
+}
+
+// allocatedClusterIDs lists service instances with a port allocation, and the etcd index of the listing
+func (r *Router) allocatedClusterIDs(ctx context.Context) (clusterIDs []structs.ClusterID, index uint64, err error) {
+	allocationsKey := fmt.Sprintf("%s/routing/allocation", r.prefix)
+	resp, err := r.etcd.Get(ctx, allocationsKey, &etcd.GetOptions{Quorum: true})
+	if err != nil {
+		if isKeyNotFound(err) {
+			return []structs.ClusterID{}, errorIndex(err), nil
+		}
+		return nil, 0, err
+	}
+
+	for _, node := range resp.Node.Nodes {
+		match := allocationClusterIDRegExp.FindStringSubmatch(node.Key)
+		if match == nil {
+			continue
+		}
+		clusterIDs = append(clusterIDs, structs.ClusterID(match[1]))
+	}
+	return clusterIDs, resp.Index, nil
+}
+
+// allocatedPort is the port recorded for a service instance under the key directory.
+// It only reads the allocation; ports are reserved and assigned through the port ledger.
+func (r *Router) allocatedPort(ctx context.Context, directory string, clusterID structs.ClusterID) (int, error) {
+	key := fmt.Sprintf("%s/%s/%s", r.prefix, directory, clusterID)
+	resp, err := r.etcd.Get(ctx, key, &etcd.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(resp.Node.Value)
+}
+
+// clusterScope is the Patroni cluster that serves a service instance; the service instance's own ID
+// unless a major version upgrade replaced its cluster.
+func (r *Router) clusterScope(ctx context.Context, clusterID structs.ClusterID) (structs.ClusterID, error) {
+	key := fmt.Sprintf("%s/routing/scope/%s", r.prefix, clusterID)
+	resp, err := r.etcd.Get(ctx, key, &etcd.GetOptions{})
+	if err != nil {
+		if isKeyNotFound(err) {
+			return clusterID, nil
+		}
+		return "", err
+	}
+	return structs.ClusterID(resp.Node.Value), nil
+}
+
+// clusterIDsServedBy lists the service instances routed to the Patroni cluster scope
+func (r *Router) clusterIDsServedBy(ctx context.Context, scope structs.ClusterID) []structs.ClusterID {
+	clusterIDs := []structs.ClusterID{scope}
+
+	scopesKey := fmt.Sprintf("%s/routing/scope", r.prefix)
+	resp, err := r.etcd.Get(ctx, scopesKey, &etcd.GetOptions{})
+	if err != nil {
+		if !isKeyNotFound(err) {
+			r.logger.Error("routing-table.scopes", err)
+		}
+		return clusterIDs
+	}
+	for _, node := range resp.Node.Nodes {
+		match := scopeClusterIDRegExp.FindStringSubmatch(node.Key)
+		if match != nil && structs.ClusterID(node.Value) == scope {
+			clusterIDs = append(clusterIDs, structs.ClusterID(match[1]))
+		}
+	}
+	return clusterIDs
+}
+
+// errorIndex is the etcd index at which a failed request was evaluated
+func errorIndex(err error) uint64 {
+	if etcdErr, ok := err.(etcd.Error); ok {
+		return etcdErr.Index
+	}
+	return 0
+}
+
+func (r *Router) removeRoutingTableEntry(clusterID structs.ClusterID) error {
+	key := fmt.Sprintf("%s/routing/table/%s", r.prefix, clusterID)
+	_, err := r.etcd.Delete(context.Background(), key, &etcd.DeleteOptions{})
+	if err != nil && !isKeyNotFound(err) {
+		r.logger.Error("remove-routing-table-entry", err, lager.Data{"clusterID": clusterID})
+		return err
+	}
+	return nil
+}
+
+type backendsByMemberID []RoutingBackend
+
+func (b backendsByMemberID) Len() int           { return len(b) }
+func (b backendsByMemberID) Less(i, j int) bool { return b[i].MemberID < b[j].MemberID }
+func (b backendsByMemberID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
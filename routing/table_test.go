@@ -0,0 +1,127 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/testutil"
+	"golang.org/x/net/context"
+)
+
+func TestRouter_PublishRoutingTableEntry(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestRouter_PublishRoutingTableEntry"
+	etcdApi := resetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	router, err := NewRouterWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create a new router %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+
+	clusterID := structs.ClusterID("clusterID")
+	ctx := context.Background()
+	members := map[string]string{
+		"leader":   `{"role": "master", "state": "running", "conn_url": "postgres://u:p@10.244.21.8:32768/postgres"}`,
+		"replica":  `{"role": "replica", "state": "running", "conn_url": "postgres://u:p@10.244.22.2:32770/postgres"}`,
+		"starting": `{"role": "replica", "state": "starting", "conn_url": "postgres://u:p@10.244.22.3:32771/postgres"}`,
+	}
+	for memberID, value := range members {
+		key := fmt.Sprintf("%s/service/%s/members/%s", testPrefix, clusterID, memberID)
+		etcdApi.Set(ctx, key, value, &etcd.SetOptions{})
+	}
+	etcdApi.Set(ctx, fmt.Sprintf("%s/service/%s/leader", testPrefix, clusterID), "leader", &etcd.SetOptions{})
+
+	if err = router.AssignPortToCluster(clusterID, 30005); err != nil {
+		t.Fatalf("Assigning port failed %s", err)
+	}
+
+	resp, err := etcdApi.Get(ctx, fmt.Sprintf("%s/routing/table/%s", testPrefix, clusterID), &etcd.GetOptions{})
+	if err != nil {
+		t.Fatalf("Routing table entry was not published %s", err)
+	}
+	var entry RoutingTableEntry
+	json.Unmarshal([]byte(resp.Node.Value), &entry)
+
+	expected := RoutingTableEntry{
+		Port:       30005,
+		LeaderHost: "10.244.21.8",
+		LeaderPort: 32768,
+		Replicas: []RoutingBackend{
+			{MemberID: "replica", Host: "10.244.22.2", Port: 32770},
+		},
+	}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Fatalf("Expected routing table entry %v, got %v", expected, entry)
+	}
+
+	if err = router.RemoveClusterAssignment(clusterID); err != nil {
+		t.Fatalf("Could not remove the assignment %s", err)
+	}
+	_, err = etcdApi.Get(ctx, fmt.Sprintf("%s/routing/table/%s", testPrefix, clusterID), &etcd.GetOptions{})
+	if err == nil {
+		t.Fatalf("Routing table entry should have been removed")
+	}
+}
+
+func TestRouter_AssignScopeToCluster(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestRouter_AssignScopeToCluster"
+	etcdApi := resetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	router, err := NewRouterWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create a new router %s", err)
+	}
+	if err = router.InitializePorts(); err != nil {
+		t.Fatalf("Could not initialize ports %s", err)
+	}
+
+	clusterID := structs.ClusterID("clusterID")
+	upgradedScope := structs.ClusterID("clusterID-pg96")
+	ctx := context.Background()
+	leaders := map[structs.ClusterID]string{
+		clusterID:     `{"role": "master", "state": "running", "conn_url": "postgres://u:p@10.244.21.8:32768/postgres"}`,
+		upgradedScope: `{"role": "master", "state": "running", "conn_url": "postgres://u:p@10.244.22.2:32770/postgres"}`,
+	}
+	for scope, value := range leaders {
+		etcdApi.Set(ctx, fmt.Sprintf("%s/service/%s/members/leader", testPrefix, scope), value, &etcd.SetOptions{})
+		etcdApi.Set(ctx, fmt.Sprintf("%s/service/%s/leader", testPrefix, scope), "leader", &etcd.SetOptions{})
+	}
+
+	if err = router.AssignPortToCluster(clusterID, 30005); err != nil {
+		t.Fatalf("Assigning port failed %s", err)
+	}
+	if err = router.AssignScopeToCluster(clusterID, upgradedScope); err != nil {
+		t.Fatalf("Assigning scope failed %s", err)
+	}
+
+	entry, err := router.RoutingTableEntry(clusterID)
+	if err != nil {
+		t.Fatalf("Could not compute routing table entry %s", err)
+	}
+	if entry.Port != 30005 || entry.LeaderHost != "10.244.22.2" {
+		t.Fatalf("Expected port 30005 to route to the upgraded cluster, got %v", entry)
+	}
+
+	if err = router.AssignScopeToCluster(clusterID, clusterID); err != nil {
+		t.Fatalf("Assigning scope failed %s", err)
+	}
+	entry, err = router.RoutingTableEntry(clusterID)
+	if err != nil {
+		t.Fatalf("Could not compute routing table entry %s", err)
+	}
+	if entry.LeaderHost != "10.244.21.8" {
+		t.Fatalf("Expected port 30005 to route back to the original cluster, got %v", entry)
+	}
+}
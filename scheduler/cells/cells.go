@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
 	"github.com/dingotiles/dingo-postgresql-broker/config"
@@ -19,27 +20,41 @@ type Cell struct {
 	URI              string
 	Config           *config.Cell
 	AvailabilityZone string
+	PostgresVersions []string
 	clusterLoader    ClusterLoader
+	client           *http.Client
 }
 
 type Cells []*Cell
 
-func NewCells(configs []*config.Cell, clusterLoader ClusterLoader) Cells {
+func NewCells(configs []*config.Cell, clusterLoader ClusterLoader) (Cells, error) {
 	var cells []*Cell
 	for _, cfg := range configs {
-		cells = append(cells, newCell(cfg, clusterLoader))
+		cell, err := newCell(cfg, clusterLoader)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, cell)
 	}
-	return cells
+	return cells, nil
 }
 
-func newCell(config *config.Cell, clusterLoader ClusterLoader) *Cell {
+func newCell(config *config.Cell, clusterLoader ClusterLoader) (*Cell, error) {
+	tlsConfig, err := config.TLS.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Cell %s has %s", config.GUID, err)
+	}
 	return &Cell{
 		GUID:             config.GUID,
 		Config:           config,
 		AvailabilityZone: config.AvailabilityZone,
 		URI:              config.URI,
+		PostgresVersions: config.PostgresVersions,
 		clusterLoader:    clusterLoader,
-	}
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
 }
 
 func (cells Cells) String() string {
@@ -90,6 +105,31 @@ func (cells Cells) ContainsCell(cellID string) bool {
 	return false
 }
 
+// SupportingPostgresVersion returns the cells that can run the PostgreSQL version;
+// all cells if version is empty.
+func (cells Cells) SupportingPostgresVersion(version string) Cells {
+	if version == "" {
+		return cells
+	}
+	supporting := Cells{}
+	for _, cell := range cells {
+		if cell.SupportsPostgresVersion(version) {
+			supporting = append(supporting, cell)
+		}
+	}
+	return supporting
+}
+
+// SupportsPostgresVersion is true if the cell advertises that it can run the PostgreSQL version
+func (cell *Cell) SupportsPostgresVersion(version string) bool {
+	for _, supported := range cell.PostgresVersions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
+
 func (cell *Cell) ProvisionNode(clusterState structs.ClusterState, logger lager.Logger) (node structs.Node, err error) {
 	node = structs.Node{ID: uuid.New(), CellGUID: cell.GUID}
 	provisionDetails := brokerapi.ProvisionDetails{
@@ -98,7 +138,7 @@ func (cell *Cell) ProvisionNode(clusterState structs.ClusterState, logger lager.
 		ServiceID:        clusterState.ServiceID,
 		SpaceGUID:        clusterState.SpaceGUID,
 		Parameters: map[string]interface{}{
-			"PATRONI_SCOPE":      clusterState.InstanceID,
+			"PATRONI_SCOPE":      clusterState.Scope(),
 			"NODE_ID":            node.ID,
 			"ADMIN_USERNAME":     clusterState.AdminCredentials.Username,
 			"ADMIN_PASSWORD":     clusterState.AdminCredentials.Password,
@@ -108,9 +148,24 @@ func (cell *Cell) ProvisionNode(clusterState structs.ClusterState, logger lager.
 			"APPUSER_PASSWORD":   clusterState.AppCredentials.Password,
 		},
 	}
+	if clusterState.PostgresVersion != "" {
+		provisionDetails.Parameters["POSTGRES_VERSION"] = clusterState.PostgresVersion
+	}
+	if clusterState.TLS != nil {
+		provisionDetails.Parameters["SSL_CERTIFICATE"] = clusterState.TLS.Certificate
+		provisionDetails.Parameters["SSL_PRIVATE_KEY"] = clusterState.TLS.PrivateKey
+		provisionDetails.Parameters["SSL_CA_CERTIFICATE"] = clusterState.TLS.CACert
+	}
+	if target := clusterState.RestoreTarget; target != nil {
+		if target.IsTime() {
+			provisionDetails.Parameters["RECOVERY_TARGET_TIME"] = target.Time.Format(time.RFC3339)
+		} else {
+			provisionDetails.Parameters["RECOVERY_TARGET_NAME"] = target.Name
+		}
+		provisionDetails.Parameters["RECOVERY_BASE_BACKUP"] = target.BaseBackup
+	}
 
 	url := fmt.Sprintf("%s/v2/service_instances/%s", cell.Config.URI, node.ID)
-	client := &http.Client{}
 	buffer := &bytes.Buffer{}
 
 	if err = json.NewEncoder(buffer).Encode(provisionDetails); err != nil {
@@ -125,7 +180,7 @@ func (cell *Cell) ProvisionNode(clusterState structs.ClusterState, logger lager.
 	req.Header.Set("Content-Type", "application/json")
 	req.SetBasicAuth(cell.Config.Username, cell.Config.Password)
 
-	resp, err := client.Do(req)
+	resp, err := cell.client.Do(req)
 	if err != nil {
 		logger.Error("request-node.cell-provision-resp", err)
 		return
@@ -142,7 +197,6 @@ func (cell *Cell) ProvisionNode(clusterState structs.ClusterState, logger lager.
 
 func (cell *Cell) DeprovisionNode(clusterState structs.ClusterState, node *structs.Node, logger lager.Logger) (err error) {
 	url := fmt.Sprintf("%s/v2/service_instances/%s", cell.URI, node.ID)
-	client := &http.Client{}
 	buffer := &bytes.Buffer{}
 
 	deleteDetails := brokerapi.DeprovisionDetails{
@@ -162,7 +216,7 @@ func (cell *Cell) DeprovisionNode(clusterState structs.ClusterState, node *struc
 	req.Header.Set("Content-Type", "application/json")
 	req.SetBasicAuth(cell.Config.Username, cell.Config.Password)
 
-	resp, err := client.Do(req)
+	resp, err := cell.client.Do(req)
 	if err != nil {
 		logger.Error("remove-node.cell.do", err)
 		return err
@@ -171,3 +225,27 @@ func (cell *Cell) DeprovisionNode(clusterState structs.ClusterState, node *struc
 
 	return
 }
+
+// BackupNode asks the cell to take a base backup of a node's database, which must be the leader.
+// The cell responds once the backup has started; it is complete when its stop sentinel is archived.
+func (cell *Cell) BackupNode(node *structs.Node, logger lager.Logger) (err error) {
+	url := fmt.Sprintf("%s/v2/service_instances/%s/backup", cell.URI, node.ID)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		logger.Error("backup-node.cell.new-req", err)
+		return
+	}
+	req.SetBasicAuth(cell.Config.Username, cell.Config.Password)
+
+	resp, err := cell.client.Do(req)
+	if err != nil {
+		logger.Error("backup-node.cell.do", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Cell %s could not start a backup of node %s: status %d", cell.GUID, node.ID, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cells
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/testutil"
+)
+
+func TestCells_BackupNode(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCells_BackupNode"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if username != "containers" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path != "/v2/service_instances/node-a/backup" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	cell, err := newCell(&config.Cell{GUID: "cell", URI: server.URL, Username: "containers", Password: "secret"}, &FakeClusterLoader{})
+	if err != nil {
+		t.Fatalf("newCell failed %s", err)
+	}
+	if err := cell.BackupNode(&structs.Node{ID: "node-a"}, logger); err != nil {
+		t.Fatalf("BackupNode failed %s", err)
+	}
+	if len(requests) != 1 || requests[0] != "POST /v2/service_instances/node-a/backup" {
+		t.Fatalf("Expected one POST backup request, got %v", requests)
+	}
+	if err := cell.BackupNode(&structs.Node{ID: "node-b"}, logger); err == nil {
+		t.Fatalf("Expected error when the cell cannot back up the node")
+	}
+}
+
+func TestCells_TLS(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestCells_TLS"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]}))
+
+	for _, test := range []struct {
+		tls     config.TLSClient
+		success bool
+	}{
+		{tls: config.TLSClient{}, success: false},
+		{tls: config.TLSClient{CACert: caCert}, success: true},
+		{tls: config.TLSClient{SkipSslValidation: true}, success: true},
+	} {
+		cell, err := newCell(&config.Cell{GUID: "cell", URI: server.URL, TLS: test.tls}, &FakeClusterLoader{})
+		if err != nil {
+			t.Fatalf("newCell failed %s", err)
+		}
+		err = cell.BackupNode(&structs.Node{ID: "node-a"}, logger)
+		if test.success && err != nil {
+			t.Fatalf("Expected request with %v to succeed, got %s", test.tls, err)
+		}
+		if !test.success && err == nil {
+			t.Fatalf("Expected request with %v to fail certificate verification", test.tls)
+		}
+	}
+
+	if _, err := newCell(&config.Cell{GUID: "cell", TLS: config.TLSClient{CACert: "not a certificate"}}, &FakeClusterLoader{}); err == nil {
+		t.Fatalf("Expected error for invalid ca_cert")
+	}
+}
@@ -40,7 +40,10 @@ func TestHealth_Load_SomeUnusedCells(t *testing.T) {
 		&config.Cell{GUID: "cell4-az2", AvailabilityZone: "z2"},
 	}
 
-	cells := NewCells(availableCells, clusterLoader)
+	cells, err := NewCells(availableCells, clusterLoader)
+	if err != nil {
+		t.Fatalf("NewCells failed %s", err)
+	}
 	cellsStatus, err := cells.InspectHealth()
 	if err != nil {
 		t.Fatalf("Failed to load cells health", err)
@@ -90,7 +93,10 @@ func TestHealth_Load_SubsetAvailableCells(t *testing.T) {
 		&config.Cell{GUID: "cell4-az2", AvailabilityZone: "z2"},
 	}
 
-	cells := NewCells(availableCells, clusterLoader)
+	cells, err := NewCells(availableCells, clusterLoader)
+	if err != nil {
+		t.Fatalf("NewCells failed %s", err)
+	}
 	cellsStatus, err := cells.InspectHealth()
 	if err != nil {
 		t.Fatalf("Failed to load cells health", err)
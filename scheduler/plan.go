@@ -16,6 +16,7 @@ const (
 type plan struct {
 	clusterModel   interfaces.ClusterModel
 	patroni        interfaces.Patroni
+	postgresql     interfaces.Postgresql
 	newFeatures    structs.ClusterFeatures
 	availableCells cells.Cells
 	allCells       cells.Cells
@@ -30,6 +31,8 @@ func (s *Scheduler) newPlan(clusterModel interfaces.ClusterModel, features struc
 	if err != nil {
 		return plan{}, err
 	}
+	// every node of a cluster must run the same PostgreSQL version
+	cells = cells.SupportingPostgresVersion(features.PostgresVersion)
 
 	return plan{
 		clusterModel:   clusterModel,
@@ -39,6 +42,7 @@ func (s *Scheduler) newPlan(clusterModel interfaces.ClusterModel, features struc
 		allCells:       s.cells,
 		logger:         s.logger,
 		patroni:        s.patroni,
+		postgresql:     s.postgresql,
 	}, nil
 }
 
@@ -62,7 +66,7 @@ func (p plan) steps() (steps []step.Step) {
 	}
 
 	// we know the leader must survive because the cluster isn't being deleted
-	leaderID, _ := p.patroni.ClusterLeader(p.clusterModel.InstanceID())
+	leaderID, _ := p.patroni.ClusterLeader(p.clusterModel.PatroniScope())
 
 	addedNodes := false
 	for i := 0; i < p.clusterGrowingBy(); i++ {
@@ -106,6 +110,44 @@ func (p plan) steps() (steps []step.Step) {
 
 	steps = append(steps, step.NewWaitForLeader(p.clusterModel, p.patroni, p.logger))
 
+	// A new (or recreated) cluster starts without synchronous mode in Patroni's dynamic configuration.
+	// Without a "synchronous" parameter, the cluster keeps its recorded mode.
+	newCluster := p.clusterModel.NodeCount() == 0
+	synchronous := p.clusterModel.Synchronous()
+	if p.newFeatures.Synchronous != nil {
+		synchronous = *p.newFeatures.Synchronous
+	}
+	if synchronous != p.clusterModel.Synchronous() || (newCluster && synchronous) {
+		steps = append(steps, step.NewStepSetSynchronousMode(p.clusterModel, synchronous, p.patroni, p.logger))
+	}
+
+	// Without a "postgresql" parameter, the cluster keeps (or, if new, is given) its recorded parameters
+	requestedParameters := p.newFeatures.PostgresqlParameters
+	if requestedParameters == nil {
+		requestedParameters = p.clusterModel.PostgresqlParameters()
+	}
+	currentParameters := p.clusterModel.PostgresqlParameters()
+	if newCluster {
+		currentParameters = nil
+	}
+	changes, restart := structs.PostgresqlParameterChanges(currentParameters, requestedParameters)
+	if len(changes) > 0 {
+		steps = append(steps, step.NewStepConfigurePostgresql(p.clusterModel, requestedParameters, changes, p.patroni, p.logger))
+		if restart {
+			steps = append(steps, step.NewStepRestartCluster(p.clusterModel, p.patroni, p.logger))
+		}
+	}
+
+	// Extensions are never dropped, as that could drop user data; a new cluster has none yet
+	existingExtensions := p.clusterModel.Extensions()
+	if newCluster {
+		existingExtensions = nil
+	}
+	allExtensions := unionOfStrings(p.clusterModel.Extensions(), p.newFeatures.Extensions)
+	if missing := differenceOfStrings(allExtensions, existingExtensions); len(missing) > 0 {
+		steps = append(steps, step.NewStepCreateExtensions(p.clusterModel, missing, allExtensions, p.patroni, p.postgresql, p.logger))
+	}
+
 	return
 }
 
@@ -135,7 +177,7 @@ func (p plan) clusterShrinkingBy() int {
 
 func (p plan) nodesToBeReplaced(leaderID string) (replicas []*structs.Node, leader *structs.Node) {
 	for _, node := range p.clusterModel.Nodes() {
-		if !p.availableCells.ContainsCell(node.CellGUID) {
+		if p.newFeatures.ReplaceNodes || !p.availableCells.ContainsCell(node.CellGUID) {
 			if node.ID == leaderID {
 				leader = node
 			} else {
@@ -145,3 +187,29 @@ func (p plan) nodesToBeReplaced(leaderID string) (replicas []*structs.Node, lead
 	}
 	return
 }
+
+// unionOfStrings returns the strings in either list, in order of first appearance
+func unionOfStrings(a, b []string) (union []string) {
+	seen := map[string]bool{}
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			union = append(union, s)
+		}
+	}
+	return
+}
+
+// differenceOfStrings returns the strings in a that are not in b
+func differenceOfStrings(a, b []string) (difference []string) {
+	inB := map[string]bool{}
+	for _, s := range b {
+		inB[s] = true
+	}
+	for _, s := range a {
+		if !inB[s] {
+			difference = append(difference, s)
+		}
+	}
+	return
+}
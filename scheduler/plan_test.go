@@ -82,6 +82,182 @@ func TestPlan_Steps_NewCluster_IncreaseCount(t *testing.T) {
 	}
 }
 
+func TestPlan_Steps_NewCluster_Synchronous(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestPlan_Steps_NewCluster_Synchronous"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	schedulerConfig := config.Scheduler{
+		Cells: []*config.Cell{
+			&config.Cell{GUID: "cell1"},
+			&config.Cell{GUID: "cell2"},
+		},
+		Etcd: testutil.LocalEtcdConfig,
+	}
+	scheduler, err := NewScheduler(schedulerConfig, new(fakes.FakePatroni), logger)
+	if err != nil {
+		t.Fatalf("NewScheduler error: %v", err)
+	}
+
+	synchronous := true
+	clusterModel := state.NewClusterModel(&state.StateEtcd{}, structs.ClusterState{})
+	plan, err := scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, Synchronous: &synchronous})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes := []string{"AddNode", "AddNode", "WaitForAllMembers", "WaitForLeader", "SetSynchronousMode(true)"}
+	stepTypes := plan.stepTypes()
+	if !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+
+	clusterState := structs.ClusterState{
+		InstanceID:  "test",
+		Synchronous: true,
+		Nodes: []*structs.Node{
+			&structs.Node{ID: "a", CellGUID: "cell1"},
+			&structs.Node{ID: "b", CellGUID: "cell2"},
+		},
+	}
+	clusterModel = state.NewClusterModel(&state.StateEtcd{}, clusterState)
+	plan, err = scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, Synchronous: &synchronous})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes = []string{"WaitForLeader"}
+	stepTypes = plan.stepTypes()
+	if !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+
+	// an update without "synchronous" keeps the current mode
+	plan, err = scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	stepTypes = plan.stepTypes()
+	if !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+
+	synchronous = false
+	plan, err = scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, Synchronous: &synchronous})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes = []string{"WaitForLeader", "SetSynchronousMode(false)"}
+	stepTypes = plan.stepTypes()
+	if !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+}
+
+func TestPlan_Steps_PostgresqlParameters(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestPlan_Steps_PostgresqlParameters"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	schedulerConfig := config.Scheduler{
+		Cells: []*config.Cell{
+			&config.Cell{GUID: "cell1"},
+			&config.Cell{GUID: "cell2"},
+		},
+		Etcd: testutil.LocalEtcdConfig,
+	}
+	scheduler, err := NewScheduler(schedulerConfig, new(fakes.FakePatroni), logger)
+	if err != nil {
+		t.Fatalf("NewScheduler error: %v", err)
+	}
+
+	clusterState := structs.ClusterState{
+		InstanceID:           "test",
+		PostgresqlParameters: map[string]string{"work_mem": "4MB"},
+		Nodes: []*structs.Node{
+			&structs.Node{ID: "a", CellGUID: "cell1"},
+			&structs.Node{ID: "b", CellGUID: "cell2"},
+		},
+	}
+	clusterModel := state.NewClusterModel(&state.StateEtcd{}, clusterState)
+
+	plan, err := scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes := []string{"WaitForLeader"}
+	if stepTypes := plan.stepTypes(); !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+
+	plan, err = scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, PostgresqlParameters: map[string]string{"work_mem": "8MB"}})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes = []string{"WaitForLeader", "ConfigurePostgresql"}
+	if stepTypes := plan.stepTypes(); !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+
+	plan, err = scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, PostgresqlParameters: map[string]string{"max_connections": "200"}})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes = []string{"WaitForLeader", "ConfigurePostgresql", "RestartCluster"}
+	if stepTypes := plan.stepTypes(); !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+}
+
+func TestPlan_Steps_Extensions(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestPlan_Steps_Extensions"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	schedulerConfig := config.Scheduler{
+		Cells: []*config.Cell{
+			&config.Cell{GUID: "cell1"},
+			&config.Cell{GUID: "cell2"},
+		},
+		Etcd: testutil.LocalEtcdConfig,
+	}
+	scheduler, err := NewScheduler(schedulerConfig, new(fakes.FakePatroni), logger)
+	if err != nil {
+		t.Fatalf("NewScheduler error: %v", err)
+	}
+
+	// recreated cluster: recorded extensions are created again
+	clusterModel := state.NewClusterModel(&state.StateEtcd{}, structs.ClusterState{Extensions: []string{"hstore"}})
+	plan, err := scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, Extensions: []string{"postgis"}})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes := []string{"AddNode", "AddNode", "WaitForAllMembers", "WaitForLeader", "CreateExtensions(hstore,postgis)"}
+	if stepTypes := plan.stepTypes(); !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+
+	// running cluster: only new extensions are created
+	clusterState := structs.ClusterState{
+		InstanceID: "test",
+		Extensions: []string{"hstore"},
+		Nodes: []*structs.Node{
+			&structs.Node{ID: "a", CellGUID: "cell1"},
+			&structs.Node{ID: "b", CellGUID: "cell2"},
+		},
+	}
+	clusterModel = state.NewClusterModel(&state.StateEtcd{}, clusterState)
+	plan, err = scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, Extensions: []string{"hstore", "postgis"}})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes = []string{"WaitForLeader", "CreateExtensions(postgis)"}
+	if stepTypes := plan.stepTypes(); !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+}
+
 func TestPlan_Steps_NewCluster_DecreaseCount(t *testing.T) {
 	t.Parallel()
 
@@ -248,3 +424,42 @@ func TestPlan_Steps_NewCluster_MoveEverything(t *testing.T) {
 		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
 	}
 }
+
+func TestPlan_Steps_ReplaceNodes(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestPlan_Steps_ReplaceNodes"
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	schedulerConfig := config.Scheduler{
+		Cells: []*config.Cell{
+			&config.Cell{GUID: "cell1"},
+			&config.Cell{GUID: "cell2"},
+		},
+		Etcd: testutil.LocalEtcdConfig,
+	}
+	patroni := new(fakes.FakePatroni)
+	patroni.ClusterLeaderStub = func(structs.ClusterID) (string, error) { return "a", nil }
+	scheduler, err := NewScheduler(schedulerConfig, patroni, logger)
+	if err != nil {
+		t.Fatalf("NewScheduler error: %v", err)
+	}
+
+	clusterState := structs.ClusterState{
+		InstanceID: "test",
+		Nodes: []*structs.Node{
+			&structs.Node{ID: "a", CellGUID: "cell1"},
+			&structs.Node{ID: "b", CellGUID: "cell2"},
+		},
+	}
+	clusterModel := state.NewClusterModel(&state.StateEtcd{}, clusterState)
+	plan, err := scheduler.newPlan(clusterModel, structs.ClusterFeatures{NodeCount: 2, ReplaceNodes: true})
+	if err != nil {
+		t.Fatalf("scheduler.newPlan error: %v", err)
+	}
+	expectedStepTypes := []string{"AddNode", "AddNode", "WaitForAllMembers", "RemoveNode(b)", "WaitForAllMembers", "FailoverFrom(a)", "RemoveNode(a)", "WaitForLeader"}
+	stepTypes := plan.stepTypes()
+	if !reflect.DeepEqual(stepTypes, expectedStepTypes) {
+		t.Fatalf("plan should have steps %v, got %v", expectedStepTypes, stepTypes)
+	}
+}
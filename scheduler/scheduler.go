@@ -6,30 +6,36 @@ import (
 	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
 	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/postgresql"
 	"github.com/dingotiles/dingo-postgresql-broker/scheduler/cells"
 	"github.com/dingotiles/dingo-postgresql-broker/state"
 	"github.com/pivotal-golang/lager"
 )
 
 type Scheduler struct {
-	logger  lager.Logger
-	config  config.Scheduler
-	cells   cells.Cells
-	patroni interfaces.Patroni
+	logger     lager.Logger
+	config     config.Scheduler
+	cells      cells.Cells
+	patroni    interfaces.Patroni
+	postgresql interfaces.Postgresql
 }
 
 func NewScheduler(config config.Scheduler, patroni interfaces.Patroni, logger lager.Logger) (*Scheduler, error) {
 	s := &Scheduler{
-		config:  config,
-		logger:  logger,
-		patroni: patroni,
+		config:     config,
+		logger:     logger,
+		patroni:    patroni,
+		postgresql: postgresql.NewPsql(config.PostgreSQL, logger),
 	}
 
 	clusterLoader, err := state.NewStateEtcd(config.Etcd, s.logger)
 	if err != nil {
 		return nil, err
 	}
-	s.cells = cells.NewCells(config.Cells, clusterLoader)
+	s.cells, err = cells.NewCells(config.Cells, clusterLoader)
+	if err != nil {
+		return nil, err
+	}
 
 	return s, nil
 }
@@ -71,6 +77,22 @@ func (s *Scheduler) StopCluster(clusterModel interfaces.ClusterModel) error {
 	return s.executePlan(clusterModel, plan)
 }
 
+// BackupNode asks the cell running a node of the cluster to take a base backup of it
+func (s *Scheduler) BackupNode(clusterModel interfaces.ClusterModel, nodeID string) error {
+	for _, node := range clusterModel.Nodes() {
+		if node.ID != nodeID {
+			continue
+		}
+		for _, cell := range s.cells {
+			if cell.GUID == node.CellGUID {
+				return cell.BackupNode(node, s.logger)
+			}
+		}
+		return fmt.Errorf("Scheduler: Node %s is on unknown cell %s", nodeID, node.CellGUID)
+	}
+	return fmt.Errorf("Scheduler: Cluster %s has no node %s", clusterModel.InstanceID(), nodeID)
+}
+
 func (s *Scheduler) executePlan(clusterModel interfaces.ClusterModel, plan plan) error {
 	steps := plan.steps()
 	clusterModel.BeginScheduling(len(steps))
@@ -92,6 +114,11 @@ func (s *Scheduler) VerifyClusterFeatures(features structs.ClusterFeatures) (err
 	if err != nil {
 		return
 	}
+	availableCells = availableCells.SupportingPostgresVersion(features.PostgresVersion)
+	if len(availableCells) == 0 {
+		err = fmt.Errorf("Scheduler: No cells support PostgreSQL version %s", features.PostgresVersion)
+		return
+	}
 	if features.NodeCount > len(availableCells) {
 		availableCellGUIDs := make([]string, len(availableCells))
 		for i, cell := range availableCells {
@@ -128,3 +128,30 @@ func TestScheduler_VerifyClusterFeatures_UnknownCellGUIDs(t *testing.T) {
 		t.Fatalf("Expect 'Cell GUIDs do not match available cells' error")
 	}
 }
+
+func TestScheduler_VerifyClusterFeatures_PostgresVersion(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestScheduler_VerifyClusterFeatures_PostgresVersion"
+	logger := testutil.NewTestLogger(testPrefix, t)
+	scheduler, err := NewScheduler(config.Scheduler{
+		Cells: []*config.Cell{
+			&config.Cell{GUID: "a", PostgresVersions: []string{"9.5"}},
+			&config.Cell{GUID: "b", PostgresVersions: []string{"9.5", "9.6"}},
+		},
+		Etcd: testutil.LocalEtcdConfig,
+	}, new(fakes.FakePatroni), logger)
+	if err != nil {
+		t.Fatalf("NewScheduler error: %v", err)
+	}
+
+	features := structs.ClusterFeatures{NodeCount: 1, PostgresVersion: "9.6"}
+	if err = scheduler.VerifyClusterFeatures(features); err != nil {
+		t.Fatalf("Cluster features %v should be valid: %s", features, err)
+	}
+
+	features = structs.ClusterFeatures{NodeCount: 1, PostgresVersion: "9.6", CellGUIDs: []string{"a"}}
+	if err = scheduler.VerifyClusterFeatures(features); err == nil {
+		t.Fatalf("Expect 'No cells support PostgreSQL version' error")
+	}
+}
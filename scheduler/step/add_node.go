@@ -75,7 +75,7 @@ func (step AddNode) Perform() (err error) {
 
 	// 6. Wait until node registers itself in data store
 	logger.Info("add-node.perform.wait-til-exists", lager.Data{"member": provisionedNode.ID})
-	err = step.patroni.WaitForMember(step.clusterModel.InstanceID(), provisionedNode.ID)
+	err = step.patroni.WaitForMember(step.clusterModel.PatroniScope(), provisionedNode.ID)
 	if err != nil {
 		logger.Error("add-node.perform.wait-til-exists.error", err, lager.Data{"member": provisionedNode.ID})
 		return err
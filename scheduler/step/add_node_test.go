@@ -47,12 +47,15 @@ func TestAddNode_PrioritizeCells_FirstNode(t *testing.T) {
 			},
 		},
 	}
-	availableCells := cells.NewCells([]*config.Cell{
+	availableCells, err := cells.NewCells([]*config.Cell{
 		&config.Cell{GUID: "cell-n1-z1", AvailabilityZone: "z1"},
 		&config.Cell{GUID: "cell-n2-z1", AvailabilityZone: "z1"},
 		&config.Cell{GUID: "cell-n3-z2", AvailabilityZone: "z2"},
 		&config.Cell{GUID: "cell-n4-z2", AvailabilityZone: "z2"},
 	}, clusterLoader)
+	if err != nil {
+		t.Fatalf("NewCells failed %s", err)
+	}
 
 	currentClusterNodes := []*structs.Node{}
 
@@ -97,12 +100,15 @@ func TestAddNode_PrioritizeCells_SecondNodeDiffAZ(t *testing.T) {
 			},
 		},
 	}
-	availableCells := cells.NewCells([]*config.Cell{
+	availableCells, err := cells.NewCells([]*config.Cell{
 		&config.Cell{GUID: "cell-n1-z1", AvailabilityZone: "z1"},
 		&config.Cell{GUID: "cell-n2-z1", AvailabilityZone: "z1"},
 		&config.Cell{GUID: "cell-n3-z2", AvailabilityZone: "z2"},
 		&config.Cell{GUID: "cell-n4-z2", AvailabilityZone: "z2"},
 	}, clusterLoader)
+	if err != nil {
+		t.Fatalf("NewCells failed %s", err)
+	}
 	currentClusterNodes := []*structs.Node{
 		&structs.Node{ID: "node-1", CellGUID: "cell-n1-z1"},
 	}
@@ -0,0 +1,47 @@
+package step
+
+import (
+	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
+	"github.com/pivotal-golang/lager"
+)
+
+// ConfigurePostgresql applies the user's PostgreSQL parameters through Patroni's dynamic configuration
+type ConfigurePostgresql struct {
+	parameters   map[string]string
+	changes      map[string]interface{}
+	clusterModel interfaces.ClusterModel
+	patroni      interfaces.Patroni
+	logger       lager.Logger
+}
+
+// NewStepConfigurePostgresql creates a ConfigurePostgresql command.
+// changes are sent to Patroni, and parameters are then recorded as the cluster's parameters.
+func NewStepConfigurePostgresql(clusterModel interfaces.ClusterModel, parameters map[string]string, changes map[string]interface{}, patroni interfaces.Patroni, logger lager.Logger) Step {
+	return ConfigurePostgresql{
+		parameters:   parameters,
+		changes:      changes,
+		clusterModel: clusterModel,
+		patroni:      patroni,
+		logger:       logger,
+	}
+}
+
+// StepType prints the type of step
+func (step ConfigurePostgresql) StepType() string {
+	return "ConfigurePostgresql"
+}
+
+// Perform runs the Step action upon the Cluster
+func (step ConfigurePostgresql) Perform() (err error) {
+	logger := step.logger
+	scope := step.clusterModel.PatroniScope()
+	logger.Info("configure-postgresql.perform", lager.Data{"patroni-scope": scope, "changes": step.changes})
+
+	err = step.patroni.PatchPostgresqlParameters(scope, step.changes)
+	if err != nil {
+		logger.Error("configure-postgresql.perform.error", err, lager.Data{"patroni-scope": scope})
+		return err
+	}
+
+	return step.clusterModel.SetPostgresqlParameters(step.parameters)
+}
@@ -0,0 +1,59 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
+	"github.com/pivotal-golang/lager"
+)
+
+// CreateExtensions runs CREATE EXTENSION on the leader for the user's requested extensions
+type CreateExtensions struct {
+	extensions   []string
+	allRequested []string
+	clusterModel interfaces.ClusterModel
+	patroni      interfaces.Patroni
+	postgresql   interfaces.Postgresql
+	logger       lager.Logger
+}
+
+// NewStepCreateExtensions creates a CreateExtensions command.
+// extensions are created, and allRequested are then recorded as the cluster's extensions.
+func NewStepCreateExtensions(clusterModel interfaces.ClusterModel, extensions []string, allRequested []string, patroni interfaces.Patroni, postgresql interfaces.Postgresql, logger lager.Logger) Step {
+	return CreateExtensions{
+		extensions:   extensions,
+		allRequested: allRequested,
+		clusterModel: clusterModel,
+		patroni:      patroni,
+		postgresql:   postgresql,
+		logger:       logger,
+	}
+}
+
+// StepType prints the type of step
+func (step CreateExtensions) StepType() string {
+	return fmt.Sprintf("CreateExtensions(%s)", strings.Join(step.extensions, ","))
+}
+
+// Perform runs the Step action upon the Cluster
+func (step CreateExtensions) Perform() (err error) {
+	logger := step.logger
+	scope := step.clusterModel.PatroniScope()
+	logger.Info("create-extensions.perform", lager.Data{"patroni-scope": scope, "extensions": step.extensions})
+
+	connURL, err := step.patroni.LeaderConnURL(scope)
+	if err != nil {
+		logger.Error("create-extensions.perform.leader", err, lager.Data{"patroni-scope": scope})
+		return err
+	}
+
+	credentials := step.clusterModel.ClusterState().SuperuserCredentials
+	err = step.postgresql.CreateExtensions(connURL, credentials, step.extensions)
+	if err != nil {
+		logger.Error("create-extensions.perform.error", err, lager.Data{"patroni-scope": scope})
+		return err
+	}
+
+	return step.clusterModel.SetExtensions(step.allRequested)
+}
@@ -35,9 +35,9 @@ func (step FailoverFrom) Perform() (err error) {
 	logger := step.logger
 	logger.Info("failover-from.perform", lager.Data{"instance-id": step.clusterModel.InstanceID(), "leader-id": step.leaderID})
 
-	instanceID := step.clusterModel.InstanceID()
+	scope := step.clusterModel.PatroniScope()
 
-	err = step.patroni.FailoverFrom(instanceID, step.leaderID)
+	err = step.patroni.FailoverFrom(scope, step.leaderID)
 	if err != nil {
 		logger.Error("failover-from.perform.error", err, lager.Data{"instance-id": step.clusterModel.InstanceID(), "leader-id": step.leaderID})
 		return err
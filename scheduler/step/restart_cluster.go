@@ -0,0 +1,58 @@
+package step
+
+import (
+	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
+	"github.com/pivotal-golang/lager"
+)
+
+// RestartCluster restarts PostgreSQL on each node, replicas first and the leader last
+type RestartCluster struct {
+	clusterModel interfaces.ClusterModel
+	patroni      interfaces.Patroni
+	logger       lager.Logger
+}
+
+// NewStepRestartCluster creates a RestartCluster command
+func NewStepRestartCluster(clusterModel interfaces.ClusterModel, patroni interfaces.Patroni, logger lager.Logger) Step {
+	return RestartCluster{
+		clusterModel: clusterModel,
+		patroni:      patroni,
+		logger:       logger,
+	}
+}
+
+// StepType prints the type of step
+func (step RestartCluster) StepType() string {
+	return "RestartCluster"
+}
+
+// Perform runs the Step action upon the Cluster
+func (step RestartCluster) Perform() (err error) {
+	logger := step.logger
+	scope := step.clusterModel.PatroniScope()
+	logger.Info("restart-cluster.perform", lager.Data{"patroni-scope": scope})
+
+	leaderID, err := step.patroni.ClusterLeader(scope)
+	if err != nil {
+		logger.Error("restart-cluster.perform.leader", err, lager.Data{"patroni-scope": scope})
+		return err
+	}
+
+	memberIDs := []string{}
+	for _, node := range step.clusterModel.Nodes() {
+		if node.ID != leaderID {
+			memberIDs = append(memberIDs, node.ID)
+		}
+	}
+	memberIDs = append(memberIDs, leaderID)
+
+	for _, memberID := range memberIDs {
+		err = step.patroni.Restart(scope, memberID)
+		if err != nil {
+			logger.Error("restart-cluster.perform.error", err, lager.Data{"patroni-scope": scope, "member": memberID})
+			return err
+		}
+	}
+
+	return step.patroni.WaitForLeader(scope)
+}
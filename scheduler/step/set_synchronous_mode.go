@@ -0,0 +1,46 @@
+package step
+
+import (
+	"fmt"
+
+	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
+	"github.com/pivotal-golang/lager"
+)
+
+// SetSynchronousMode turns Patroni's synchronous replication on or off for the cluster
+type SetSynchronousMode struct {
+	enabled      bool
+	clusterModel interfaces.ClusterModel
+	patroni      interfaces.Patroni
+	logger       lager.Logger
+}
+
+// NewStepSetSynchronousMode creates a SetSynchronousMode command
+func NewStepSetSynchronousMode(clusterModel interfaces.ClusterModel, enabled bool, patroni interfaces.Patroni, logger lager.Logger) Step {
+	return SetSynchronousMode{
+		enabled:      enabled,
+		clusterModel: clusterModel,
+		patroni:      patroni,
+		logger:       logger,
+	}
+}
+
+// StepType prints the type of step
+func (step SetSynchronousMode) StepType() string {
+	return fmt.Sprintf("SetSynchronousMode(%t)", step.enabled)
+}
+
+// Perform runs the Step action upon the Cluster
+func (step SetSynchronousMode) Perform() (err error) {
+	logger := step.logger
+	scope := step.clusterModel.PatroniScope()
+	logger.Info("set-synchronous-mode.perform", lager.Data{"patroni-scope": scope, "enabled": step.enabled})
+
+	err = step.patroni.SetSynchronousMode(scope, step.enabled)
+	if err != nil {
+		logger.Error("set-synchronous-mode.perform.error", err, lager.Data{"patroni-scope": scope})
+		return err
+	}
+
+	return step.clusterModel.SetSynchronous(step.enabled)
+}
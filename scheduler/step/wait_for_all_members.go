@@ -31,10 +31,10 @@ func (step WaitForAllMembers) Perform() (err error) {
 	logger := step.logger
 	logger.Info("wait-til-nodes-running.perform", lager.Data{"instance-id": step.clusterModel.InstanceID()})
 
-	instanceID := step.clusterModel.InstanceID()
+	scope := step.clusterModel.PatroniScope()
 	nodesCount := step.clusterModel.NodeCount()
 
-	err = step.patroni.WaitForAllMembers(instanceID, nodesCount)
+	err = step.patroni.WaitForAllMembers(scope, nodesCount)
 	if err != nil {
 		logger.Error("wait-til-nodes-running.perform.error", err, lager.Data{"instance-id": step.clusterModel.InstanceID()})
 		return err
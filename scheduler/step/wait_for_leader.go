@@ -31,9 +31,9 @@ func (step WaitForLeader) Perform() (err error) {
 	logger := step.logger
 	logger.Info("wait-for-leader.perform", lager.Data{"instance-id": step.clusterModel.InstanceID()})
 
-	instanceID := step.clusterModel.InstanceID()
+	scope := step.clusterModel.PatroniScope()
 
-	err = step.patroni.WaitForLeader(instanceID)
+	err = step.patroni.WaitForLeader(scope)
 	if err != nil {
 		logger.Error("wait-for-leader.perform.error", err, lager.Data{"instance-id": step.clusterModel.InstanceID()})
 		return err
@@ -2,6 +2,7 @@ package state
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/dingotiles/dingo-postgresql-broker/broker/interfaces"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
@@ -78,10 +79,55 @@ func (m *ClusterModel) InstanceID() structs.ClusterID {
 	return m.cluster.InstanceID
 }
 
+// PatroniScope is the name of the Patroni cluster serving the service instance
+func (m *ClusterModel) PatroniScope() structs.ClusterID {
+	return m.cluster.Scope()
+}
+
 func (m *ClusterModel) AllocatedPort() int {
 	return m.cluster.AllocatedPort
 }
 
+func (m *ClusterModel) AllocatedReplicaPort() int {
+	return m.cluster.AllocatedReplicaPort
+}
+
+// SetAllocatedReplicaPort records the public port used for read-only access to replicas
+func (m *ClusterModel) SetAllocatedReplicaPort(port int) error {
+	m.cluster.AllocatedReplicaPort = port
+	return m.save()
+}
+
+// Synchronous is true if the cluster was asked to use Patroni's synchronous replication mode
+func (m *ClusterModel) Synchronous() bool {
+	return m.cluster.Synchronous
+}
+
+func (m *ClusterModel) SetSynchronous(synchronous bool) error {
+	m.cluster.Synchronous = synchronous
+	return m.save()
+}
+
+// PostgresqlParameters are the user's PostgreSQL configuration parameters last applied to the cluster
+func (m *ClusterModel) PostgresqlParameters() map[string]string {
+	return m.cluster.PostgresqlParameters
+}
+
+func (m *ClusterModel) SetPostgresqlParameters(parameters map[string]string) error {
+	m.cluster.PostgresqlParameters = parameters
+	return m.save()
+}
+
+// Extensions are the PostgreSQL extensions created at the user's request
+func (m *ClusterModel) Extensions() []string {
+	return m.cluster.Extensions
+}
+
+func (m *ClusterModel) SetExtensions(extensions []string) error {
+	m.cluster.Extensions = extensions
+	return m.save()
+}
+
 func (m *ClusterModel) NodeCount() int {
 	return m.cluster.NodeCount()
 }
@@ -104,5 +150,104 @@ func (m *ClusterModel) UpdateCredentials(creds *structs.ClusterRecreationData) e
 	m.cluster.AdminCredentials = creds.AdminCredentials
 	m.cluster.SuperuserCredentials = creds.SuperuserCredentials
 	m.cluster.AppCredentials = creds.AppCredentials
+	m.cluster.AppOwnerRole = creds.AppOwnerRole
+	m.cluster.RetiringUsers = creds.RetiringUsers
+	return m.save()
+}
+
+func (m *ClusterModel) SetAdminCredentials(credentials structs.PostgresCredentials) error {
+	m.cluster.AdminCredentials = credentials
+	return m.save()
+}
+
+// ReplaceAppCredentials gives new bindings the credentials of a new app user.
+// The replaced app user is kept as a RetiringUser until retireAt.
+func (m *ClusterModel) ReplaceAppCredentials(credentials structs.PostgresCredentials, retireAt time.Time) error {
+	m.cluster.AppOwnerRole = m.cluster.AppOwner()
+	m.cluster.RetiringUsers = append(m.cluster.RetiringUsers, structs.RetiringUser{
+		Username: m.cluster.AppCredentials.Username,
+		RetireAt: retireAt,
+	})
+	m.cluster.AppCredentials = credentials
+	return m.save()
+}
+
+// RetiringUsers are the replaced app users that can still log in
+func (m *ClusterModel) RetiringUsers() []structs.RetiringUser {
+	return m.cluster.RetiringUsers
+}
+
+// ForgetRetiringUser stops recording a replaced app user once it can no longer log in
+func (m *ClusterModel) ForgetRetiringUser(username string) error {
+	remaining := []structs.RetiringUser{}
+	for _, user := range m.cluster.RetiringUsers {
+		if user.Username != username {
+			remaining = append(remaining, user)
+		}
+	}
+	m.cluster.RetiringUsers = remaining
 	return m.save()
 }
+
+// PostgresVersion is the PostgreSQL major version the cluster runs
+func (m *ClusterModel) PostgresVersion() string {
+	return m.cluster.PostgresVersion
+}
+
+func (m *ClusterModel) SetPostgresVersion(version string) error {
+	m.cluster.PostgresVersion = version
+	return m.save()
+}
+
+// SetPlanID records the plan that the service instance was last provisioned or updated with
+func (m *ClusterModel) SetPlanID(planID string) error {
+	m.cluster.PlanID = planID
+	return m.save()
+}
+
+// SetTLS records the server certificate that new nodes are given
+func (m *ClusterModel) SetTLS(tls *structs.ClusterTLS) error {
+	m.cluster.TLS = tls
+	return m.save()
+}
+
+// SetRestoreTarget records where new nodes stop replaying WAL when restoring from backups;
+// nil once the restored cluster is running, so that later nodes replicate from it
+func (m *ClusterModel) SetRestoreTarget(target *structs.RestoreTarget) error {
+	m.cluster.RestoreTarget = target
+	return m.save()
+}
+
+// PreviousCluster is the cluster kept for rolling back a major version upgrade, if any
+func (m *ClusterModel) PreviousCluster() *structs.PreviousCluster {
+	return m.cluster.PreviousCluster
+}
+
+// ForgetPreviousCluster stops recording the cluster kept for rolling back an upgrade
+func (m *ClusterModel) ForgetPreviousCluster() error {
+	m.cluster.PreviousCluster = nil
+	return m.save()
+}
+
+// ReplaceCluster records that the service instance is now served by the Patroni cluster scope.
+// The replaced cluster is returned, and kept as the PreviousCluster if retainUntil is set.
+func (m *ClusterModel) ReplaceCluster(scope structs.ClusterID, postgresVersion string, nodes []*structs.Node, retainUntil time.Time) (replaced structs.PreviousCluster, err error) {
+	replaced = structs.PreviousCluster{
+		PatroniScope:    m.cluster.Scope(),
+		PostgresVersion: m.cluster.PostgresVersion,
+		Nodes:           m.cluster.Nodes,
+		RetainUntil:     retainUntil,
+	}
+	if scope == m.cluster.InstanceID {
+		scope = ""
+	}
+	m.cluster.PatroniScope = scope
+	m.cluster.PostgresVersion = postgresVersion
+	m.cluster.Nodes = nodes
+	if retainUntil.IsZero() {
+		m.cluster.PreviousCluster = nil
+	} else {
+		m.cluster.PreviousCluster = &replaced
+	}
+	return replaced, m.save()
+}
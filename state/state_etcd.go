@@ -3,6 +3,7 @@ package state
 import (
 	"encoding/json"
 	"fmt"
+	"path"
 	"regexp"
 
 	"golang.org/x/net/context"
@@ -10,12 +11,14 @@ import (
 	etcd "github.com/coreos/etcd/client"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
 	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/encryption"
 	"github.com/pivotal-golang/lager"
 )
 
 type StateEtcd struct {
 	etcdApi etcd.KeysAPI
 	prefix  string
+	keyring *encryption.Keyring
 	logger  lager.Logger
 }
 
@@ -38,7 +41,18 @@ func NewStateEtcdWithPrefix(etcdConfig config.Etcd, prefix string, logger lager.
 	return state, nil
 }
 
+// SetKeyring encrypts the credentials of clusters, and tombstones, saved after it is set.
+// Credentials are decrypted when loaded.
+func (s *StateEtcd) SetKeyring(keyring *encryption.Keyring) {
+	s.keyring = keyring
+}
+
 func (s *StateEtcd) SaveCluster(clusterState structs.ClusterState) (err error) {
+	clusterState, err = s.keyring.EncryptClusterState(clusterState)
+	if err != nil {
+		s.logger.Error("state.save-cluster.encrypt", err)
+		return
+	}
 	s.logger.Info("state.save-cluster", lager.Data{
 		"cluster": clusterState,
 	})
@@ -72,12 +86,21 @@ func (s *StateEtcd) setupEtcd(cfg config.Etcd) (etcd.KeysAPI, error) {
 	return api, nil
 }
 
-func (s *StateEtcd) ClusterExists(instanceID structs.ClusterID) bool {
+// ClusterExists is true if the service instance has stored state. Errors other than
+// the state not being found are returned, rather than taken to mean it does not exist.
+func (s *StateEtcd) ClusterExists(instanceID structs.ClusterID) (bool, error) {
 	ctx := context.Background()
 	s.logger.Info("state.cluster-exists")
 	key := fmt.Sprintf("%s/service/%s/state", s.prefix, instanceID)
 	_, err := s.etcdApi.Get(ctx, key, &etcd.GetOptions{})
-	return err == nil
+	if etcd.IsKeyNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		s.logger.Error("state.cluster-exists", err)
+		return false, err
+	}
+	return true, nil
 }
 
 // LoadCluster fetches the latest data/state for specific cluster
@@ -109,10 +132,17 @@ func (s *StateEtcd) LoadCluster(instanceID structs.ClusterID) (cluster structs.C
 	}
 	cluster.Nodes = nodes
 
+	cluster, err = s.keyring.DecryptClusterState(cluster)
+	if err != nil {
+		s.logger.Error("state.load-cluster.decrypt", err)
+		return structs.ClusterState{}, err
+	}
 	return
 }
 
-// LoadAllRunningClusters fetches the /state information for all running clusters
+// LoadAllRunningClusters fetches the /state information for all running clusters.
+// Clusters that cannot be loaded, such as those that cannot be decrypted, are logged and skipped,
+// as are Patroni clusters without stored state.
 func (s *StateEtcd) LoadAllRunningClusters() (clusters []*structs.ClusterState, err error) {
 	ctx := context.Background()
 	servicesKey := fmt.Sprintf("%s/service", s.prefix)
@@ -124,10 +154,56 @@ func (s *StateEtcd) LoadAllRunningClusters() (clusters []*structs.ClusterState,
 	instanceIDRegExp, _ := regexp.Compile("/service/(.*)")
 	for _, service := range services.Node.Nodes {
 		instanceID := instanceIDRegExp.FindStringSubmatch(service.Key)[1]
-		cluster, _ := s.LoadCluster(structs.ClusterID(instanceID))
+		cluster, err := s.LoadCluster(structs.ClusterID(instanceID))
+		if err != nil {
+			s.logger.Error("state.load-all-running-clusters.load-cluster", err, lager.Data{"instance-id": instanceID})
+			continue
+		}
+		if cluster.InstanceID == "" {
+			// only the keys of a Patroni cluster, such as one that a service instance was upgraded to
+			continue
+		}
 		clusters = append(clusters, &cluster)
 	}
-	return
+	return clusters, nil
+}
+
+// LoadAllClusterIDs lists the service instances with stored state, including those
+// whose state cannot be loaded
+func (s *StateEtcd) LoadAllClusterIDs() (instanceIDs []structs.ClusterID, err error) {
+	ctx := context.Background()
+	servicesKey := fmt.Sprintf("%s/service", s.prefix)
+	services, err := s.etcdApi.Get(ctx, servicesKey, &etcd.GetOptions{Recursive: false})
+	if err != nil {
+		return nil, err
+	}
+
+	instanceIDs = []structs.ClusterID{}
+	for _, service := range services.Node.Nodes {
+		_, err = s.etcdApi.Get(ctx, fmt.Sprintf("%s/state", service.Key), &etcd.GetOptions{})
+		if etcd.IsKeyNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		instanceIDs = append(instanceIDs, structs.ClusterID(path.Base(service.Key)))
+	}
+	return instanceIDs, nil
+}
+
+// DeleteClusterState removes only the stored state of a cluster, leaving the keys of its running Patroni cluster
+func (s *StateEtcd) DeleteClusterState(instanceID structs.ClusterID) error {
+	ctx := context.Background()
+	s.logger.Info("state.delete-cluster-state")
+	key := fmt.Sprintf("%s/service/%s/state", s.prefix, instanceID)
+
+	_, err := s.etcdApi.Delete(ctx, key, &etcd.DeleteOptions{})
+	if err != nil {
+		s.logger.Error("state.delete-cluster-state", err)
+	}
+
+	return err
 }
 
 func (s *StateEtcd) DeleteCluster(instanceID structs.ClusterID) error {
@@ -142,3 +218,86 @@ func (s *StateEtcd) DeleteCluster(instanceID structs.ClusterID) error {
 
 	return err
 }
+
+// SaveTombstone stores a deprovisioned service instance, apart from running clusters
+func (s *StateEtcd) SaveTombstone(tombstone structs.Tombstone) error {
+	s.logger.Info("state.save-tombstone", lager.Data{"instance-id": tombstone.InstanceID()})
+	ctx := context.Background()
+	key := fmt.Sprintf("%s/tombstone/%s", s.prefix, tombstone.InstanceID())
+
+	var err error
+	tombstone.RecreationData, err = s.keyring.EncryptRecreationData(tombstone.RecreationData)
+	if err != nil {
+		s.logger.Error("state.save-tombstone.encrypt", err)
+		return err
+	}
+	data, err := json.Marshal(tombstone)
+	if err != nil {
+		s.logger.Error("state.save-tombstone.marshal", err)
+		return err
+	}
+	_, err = s.etcdApi.Set(ctx, key, string(data), &etcd.SetOptions{})
+	if err != nil {
+		s.logger.Error("state.save-tombstone.set", err)
+	}
+	return err
+}
+
+func (s *StateEtcd) LoadTombstone(instanceID structs.ClusterID) (tombstone structs.Tombstone, err error) {
+	ctx := context.Background()
+	s.logger.Info("state.load-tombstone")
+	key := fmt.Sprintf("%s/tombstone/%s", s.prefix, instanceID)
+
+	resp, err := s.etcdApi.Get(ctx, key, &etcd.GetOptions{})
+	if err != nil {
+		s.logger.Error("state.load-tombstone.error", err)
+		return
+	}
+	if err = json.Unmarshal([]byte(resp.Node.Value), &tombstone); err != nil {
+		return
+	}
+	tombstone.RecreationData, err = s.keyring.DecryptRecreationData(tombstone.RecreationData)
+	return
+}
+
+// LoadAllTombstones fetches every deprovisioned service instance that is still recoverable
+func (s *StateEtcd) LoadAllTombstones() (tombstones []*structs.Tombstone, err error) {
+	ctx := context.Background()
+	key := fmt.Sprintf("%s/tombstone", s.prefix)
+	resp, err := s.etcdApi.Get(ctx, key, &etcd.GetOptions{Recursive: false})
+	if err != nil {
+		if etcd.IsKeyNotFound(err) {
+			return []*structs.Tombstone{}, nil
+		}
+		return nil, err
+	}
+
+	tombstones = []*structs.Tombstone{}
+	for _, node := range resp.Node.Nodes {
+		var tombstone structs.Tombstone
+		if err = json.Unmarshal([]byte(node.Value), &tombstone); err != nil {
+			s.logger.Error("state.load-all-tombstones.unmarshal", err, lager.Data{"key": node.Key})
+			continue
+		}
+		// a tombstone that cannot be decrypted is still listed, so that its ports stay reserved
+		if decrypted, err := s.keyring.DecryptRecreationData(tombstone.RecreationData); err == nil {
+			tombstone.RecreationData = decrypted
+		} else {
+			s.logger.Error("state.load-all-tombstones.decrypt", err, lager.Data{"key": node.Key})
+		}
+		tombstones = append(tombstones, &tombstone)
+	}
+	return tombstones, nil
+}
+
+func (s *StateEtcd) DeleteTombstone(instanceID structs.ClusterID) error {
+	ctx := context.Background()
+	s.logger.Info("state.delete-tombstone")
+	key := fmt.Sprintf("%s/tombstone/%s", s.prefix, instanceID)
+
+	_, err := s.etcdApi.Delete(ctx, key, &etcd.DeleteOptions{})
+	if err != nil {
+		s.logger.Error("state.delete-tombstone", err)
+	}
+	return err
+}
@@ -1,14 +1,18 @@
 package state
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	etcd "github.com/coreos/etcd/client"
 	"github.com/dingotiles/dingo-postgresql-broker/broker/structs"
+	"github.com/dingotiles/dingo-postgresql-broker/config"
+	"github.com/dingotiles/dingo-postgresql-broker/encryption"
 	"github.com/dingotiles/dingo-postgresql-broker/testutil"
 	"github.com/pborman/uuid"
 	"golang.org/x/net/context"
@@ -78,11 +82,11 @@ func TestState_ClusterExists(t *testing.T) {
 		t.Fatalf("SaveCluster failed %s", err)
 	}
 
-	if !state.ClusterExists(clusterID) {
+	if exists, err := state.ClusterExists(clusterID); err != nil || !exists {
 		t.Fatalf("Cluster %s should exist", clusterID)
 	}
 
-	if state.ClusterExists("fakeID") {
+	if exists, err := state.ClusterExists("fakeID"); err != nil || exists {
 		t.Fatalf("Cluster %s should not exist", "fakeID")
 	}
 }
@@ -171,3 +175,124 @@ func TestState_DeleteCluster(t *testing.T) {
 		}
 	}
 }
+
+func TestState_Tombstones(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestState_Tombstones"
+	testutil.ResetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	state, err := NewStateEtcdWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create state %s", err)
+	}
+
+	tombstones, err := state.LoadAllTombstones()
+	if err != nil || len(tombstones) != 0 {
+		t.Fatalf("Expected no tombstones, got %v %s", tombstones, err)
+	}
+
+	instanceID := structs.ClusterID(uuid.New())
+	tombstone := structs.Tombstone{
+		RecreationData: structs.ClusterRecreationData{InstanceID: instanceID, AllocatedPort: 33004},
+		BackupsURI:     "file:///backups/" + string(instanceID),
+		DeletedAt:      time.Now().UTC().Truncate(time.Second),
+		RetainUntil:    time.Now().UTC().Truncate(time.Second).Add(time.Hour),
+	}
+	if err = state.SaveTombstone(tombstone); err != nil {
+		t.Fatalf("SaveTombstone failed %s", err)
+	}
+	if exists, _ := state.ClusterExists(instanceID); exists {
+		t.Fatalf("A tombstone should not be a running cluster")
+	}
+
+	loaded, err := state.LoadTombstone(instanceID)
+	if err != nil || !reflect.DeepEqual(tombstone, loaded) {
+		t.Fatalf("Loaded tombstone %v should equal saved %v (%v)", loaded, tombstone, err)
+	}
+	tombstones, err = state.LoadAllTombstones()
+	if err != nil || len(tombstones) != 1 || !reflect.DeepEqual(tombstone, *tombstones[0]) {
+		t.Fatalf("Expected to load the tombstone, got %v %s", tombstones, err)
+	}
+
+	if err = state.DeleteTombstone(instanceID); err != nil {
+		t.Fatalf("DeleteTombstone failed %s", err)
+	}
+	if _, err = state.LoadTombstone(instanceID); err == nil {
+		t.Fatalf("Expected deleted tombstone not to be found")
+	}
+}
+
+func TestState_LoadCluster_Undecryptable(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestState_LoadCluster_Undecryptable"
+	testutil.ResetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	state, err := NewStateEtcdWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create state %s", err)
+	}
+	keyring, err := encryption.NewKeyring(config.Encryption{
+		ActiveKey: "removed",
+		Keys:      map[string]string{"removed": base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))},
+	})
+	if err != nil {
+		t.Fatalf("NewKeyring failed %s", err)
+	}
+	state.SetKeyring(keyring)
+
+	instanceID := structs.ClusterID(uuid.New())
+	clusterState := structs.ClusterState{
+		InstanceID:       instanceID,
+		AdminCredentials: structs.PostgresCredentials{Username: "pgadmin", Password: "secret"},
+	}
+	if err = state.SaveCluster(clusterState); err != nil {
+		t.Fatalf("SaveCluster failed %s", err)
+	}
+
+	// the key the credentials were encrypted with is no longer configured
+	state.SetKeyring(nil)
+	loaded, err := state.LoadCluster(instanceID)
+	if err == nil || !reflect.DeepEqual(loaded, structs.ClusterState{}) {
+		t.Fatalf("Expected an error and no cluster state, got %v (%v)", loaded, err)
+	}
+	clusters, err := state.LoadAllRunningClusters()
+	if err != nil || len(clusters) != 0 {
+		t.Fatalf("Expected the cluster to be skipped, got %v (%v)", clusters, err)
+	}
+	instanceIDs, err := state.LoadAllClusterIDs()
+	if err != nil || !reflect.DeepEqual(instanceIDs, []structs.ClusterID{instanceID}) {
+		t.Fatalf("Expected the cluster to be listed, got %v (%v)", instanceIDs, err)
+	}
+}
+
+func TestState_LoadAllRunningClusters_SkipsPatroniScopes(t *testing.T) {
+	t.Parallel()
+
+	testPrefix := "TestState_LoadAllRunningClusters_SkipsPatroniScopes"
+	etcdApi := testutil.ResetEtcd(t, testPrefix)
+	logger := testutil.NewTestLogger(testPrefix, t)
+
+	state, err := NewStateEtcdWithPrefix(testutil.LocalEtcdConfig, testPrefix, logger)
+	if err != nil {
+		t.Fatalf("Could not create state %s", err)
+	}
+
+	instanceID := structs.ClusterID(uuid.New())
+	if err = state.SaveCluster(structs.ClusterState{InstanceID: instanceID}); err != nil {
+		t.Fatalf("SaveCluster failed %s", err)
+	}
+	// the Patroni cluster that the service instance was upgraded to has no state of its own
+	key := fmt.Sprintf("/%s/service/%s-pg96/leader", testPrefix, instanceID)
+	if _, err = etcdApi.Set(context.Background(), key, "member", &etcd.SetOptions{}); err != nil {
+		t.Fatalf("Could not store Patroni key %s", err)
+	}
+
+	clusters, err := state.LoadAllRunningClusters()
+	if err != nil || len(clusters) != 1 || clusters[0].InstanceID != instanceID {
+		t.Fatalf("Expected only service instance %s, got %v (%v)", instanceID, clusters, err)
+	}
+}